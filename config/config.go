@@ -1,23 +1,87 @@
 package config
 
 import (
-	"log"
+	"fmt"
+	"log/slog"
+	"maps"
 	"os"
 	"strconv"
+	"strings"
+	"time"
+
+	"gopatch/internal/validate"
+	"gopatch/internal/writeback"
 
 	"github.com/joho/godotenv"
 )
 
 // Application-wide configuration variables
 var (
-	APIUrl         string  // URL for the API endpoint
-	ServiceRoleKey string  // Key to identify the service role
-	Function       string  // Name of the function to invoke for processing
-	Trigger        string  // Trigger identifier for the device or operation
-	LoopStr        string  // Looping parameter in string format
-	Loop           float64 // Looping parameter converted to float64
-	Filter         string  // Filter for processing MQTT messages
-	InsertMode     string  // Default Mode : Patch, Option" Upsert
+	APIUrl         string   // URL for the API endpoint
+	ServiceRoleKey string   // Key to identify the service role
+	Function       string   // Name of the function to invoke for processing
+	Trigger        string   // Trigger identifier for the device or operation
+	LoopStr        string   // Looping parameter in string format
+	Loop           float64  // Looping parameter converted to float64
+	Filter         string   // Filter for processing MQTT messages
+	InsertMode     string   // Default Mode : Patch, Option" Upsert
+	Channels       []string // Filling channel names, e.g. ch1,ch2,ch3
+
+	APIHeaders map[string]string   // Extra request headers; API_URL, BASH_API and the header values are record templates
+	Endpoints  map[string]Endpoint // API_URL, BASH_API and API_HEADERS of a case or of a channel of a case
+
+	UpsertOnConflict string // Unique columns an upsert conflicts on, comma separated, "" for the primary key
+	UpsertResolution string // "merge" (default) updates the conflicting row, "ignore" keeps it
+
+	WriteBack writeback.Mapping // Values of the API response written to the PLC after a record is sent
+
+	APIAuth      string                 // Name of the AUTH_<NAME>_* profile authorizing the API requests, "" sends SERVICE_ROLE_KEY
+	AuthProfiles map[string]AuthProfile // Auth profiles by lower-case name
+
+	Sinks map[string][]string // Sinks every record of a case is written to, "" is the default for every case
+
+	RetryMaxAttempts int           // Attempts to write a record before giving up, 1 never retries
+	RetryBaseDelay   time.Duration // Delay before the first retry, doubled before every next one
+	RetryMaxDelay    time.Duration // Upper bound of the retry delay
+
+	BatchMaxItems int           // Records per request of the batch sink
+	BatchMaxWait  time.Duration // Longest a record waits for its batch to fill
+
+	OutboxDir       string        // Queue every record on disk here and deliver it in the background, "" to write directly
+	OutboxRetention time.Duration // Drop queued records older than this, 0 keeps them until delivered
+	OutboxMaxBytes  int64         // Drop the oldest queued records past this size, 0 for no cap
+
+	DeadLetterFile string // Save the records given up on here, for "gopatch dlq", "" to drop them
+
+	CycleTimeouts map[string]time.Duration // Cycle timeout per case key, "" is the default for every case
+	TimeoutAPIUrl string                   // Optional dead-letter endpoint for timed out cycles
+	StatusField   string                   // Record field carrying the cycle status, e.g. "timeout"
+
+	RecordRules map[string]validate.Rules // Completeness rules per case key
+	ReasonField string                    // Record field carrying the validation failure reason
+
+	EmitMode      string // "cycle" (default) waits for every channel, "channel" emits each channel on its own
+	MergeChannels bool   // Merge per-channel records into one row keyed by the cycle ID
+	CycleIDField  string // Record field carrying the cycle ID
+	ChannelField  string // Record field carrying the channel name of a per-channel record
+
+	CycleStartField string // Record field carrying when the cycle started, "" to leave out
+	CycleEndField   string // Record field carrying when the cycle was emitted, "" to leave out
+
+	DryRun     bool   // Log outgoing API requests and PLC writes instead of sending them
+	DryRunFile string // Optional JSONL file receiving the dry-run requests
+
+	SessionStoreFile     string        // Snapshot the hold sessions to this file and restore them on start
+	SessionStoreInterval time.Duration // Minimum time between two snapshots, 0 after every batch
+
+	AdminAddr  string // Listen address of the admin HTTP API, e.g. ":8081", "" to disable
+	AdminToken string // Bearer token every admin request must carry
+
+	MetricsAddr string // Listen address of the Prometheus /metrics endpoint, e.g. ":9090", "" to disable
+
+	LogLevel  string // debug, info (default), warn or error
+	LogFormat string // text (default) or json
+	LogDev    bool   // Pretty print the sent records in color, for local runs
 
 	Broker        string // MQTT broker hostname
 	Port          string // MQTT broker port
@@ -26,6 +90,17 @@ var (
 	ECScaCert     string // ESC version direct read from params store
 	ECSclientCert string // ESC version direct read from params store
 	ECSclientKey  string // ESC version direct read from params store
+	RecordFile    string // Append every MQTT batch to this JSONL file, for replay
+
+	HttpTimeout         time.Duration // Timeout of an API request, from connecting to reading the response
+	HttpDialTimeout     time.Duration // Timeout of connecting to the API
+	HttpIdleConnTimeout time.Duration // Close kept-alive API connections idle this long
+	HttpMaxIdleConns    int           // Kept-alive connections per API host
+	HttpMaxConns        int           // Connections per API host, 0 for no limit
+	ApiProxy            string        // Proxy URL for the API, "" uses HTTPS_PROXY, HTTP_PROXY and NO_PROXY
+	ApiCaCert           string        // PEM CA certificate of the API, "" uses the system roots
+	ApiClientCert       string        // PEM client certificate for mTLS to the API
+	ApiClientKey        string        // PEM private key of ApiClientCert
 
 	PlcHost         string // plcHost stores the PLC's hostname
 	PlcPort         int    // plcPort stores the PLC's port number
@@ -43,6 +118,7 @@ type MqttConfig struct {
 	ECScaCert     string
 	ECSclientCert string
 	ECSclientKey  string
+	RecordFile    string
 }
 
 func GetMqttConfig() MqttConfig {
@@ -54,6 +130,7 @@ func GetMqttConfig() MqttConfig {
 		ECScaCert:     ECScaCert,
 		ECSclientCert: ECSclientCert,
 		ECSclientKey:  ECSclientKey,
+		RecordFile:    RecordFile,
 	}
 }
 
@@ -66,10 +143,203 @@ type AppConfig struct {
 	Loop           float64
 	Filter         string
 	InsertMode     string
+	Channels       []string
+
+	APIHeaders map[string]string
+	Endpoints  map[string]Endpoint
+
+	UpsertOnConflict string
+	UpsertResolution string
+
+	WriteBack writeback.Mapping
+
+	APIAuth      string
+	AuthProfiles map[string]AuthProfile
+
+	Sinks map[string][]string
+
+	RetryMaxAttempts int
+	RetryBaseDelay   time.Duration
+	RetryMaxDelay    time.Duration
+
+	BatchMaxItems int
+	BatchMaxWait  time.Duration
+
+	DeadLetterFile string
+
+	CycleTimeouts map[string]time.Duration
+	TimeoutAPIUrl string
+	StatusField   string
+
+	RecordRules map[string]validate.Rules
+	ReasonField string
+
+	EmitMode      string
+	MergeChannels bool
+	CycleIDField  string
+	ChannelField  string
+
+	CycleStartField string
+	CycleEndField   string
+
+	DryRun     bool
+	DryRunFile string
+
+	SessionStoreFile     string
+	SessionStoreInterval time.Duration
 
 	Plc PlcConfig
 }
 
+// Emit modes of the weight and filling cases
+const (
+	EmitPerCycle   = "cycle"   // One record once every channel completed
+	EmitPerChannel = "channel" // One record per channel as soon as it completed
+)
+
+// SinksFor returns the names of the sinks the records of a case are written to,
+// "upsert" or "rest" following INSERT_MODE when unset
+func (c AppConfig) SinksFor(caseKey string) []string {
+	if sinks, ok := c.Sinks[rulesKey(caseKey)]; ok {
+		return sinks
+	}
+	if sinks, ok := c.Sinks[""]; ok {
+		return sinks
+	}
+	if c.InsertMode == "upsert" {
+		return []string{"upsert"}
+	}
+	return []string{"rest"}
+}
+
+// Endpoint overrides API_URL, BASH_API, API_HEADERS, API_AUTH, the UPSERT_* settings and WRITEBACK
+// for a case or a channel of a case, unset fields keep the general setting
+type Endpoint struct {
+	URL        string
+	Method     string
+	Headers    map[string]string // Added to API_HEADERS
+	Auth       string
+	OnConflict string
+	Resolution string
+	WriteBack  writeback.Mapping // Replaces WRITEBACK
+}
+
+// Auth types of an AuthProfile
+const (
+	AuthKey    = "key"    // Key as apikey and bearer token, like SERVICE_ROLE_KEY
+	AuthBasic  = "basic"  // User and Password
+	AuthHeader = "header" // Header as "Name: value"
+	AuthOAuth2 = "oauth2" // Bearer token of the OAuth2 client credentials grant
+	AuthJWT    = "jwt"    // Bearer JWT signed with SigningKey
+)
+
+// AuthProfile is how the API requests authenticate, read from AUTH_<NAME>_*.
+// Tokens are renewed RefreshBefore their expiry, so a request never waits on an expired one.
+type AuthProfile struct {
+	Type string
+
+	Key            string // key: SERVICE_ROLE_KEY when unset
+	User, Password string // basic
+	Header         string // header
+
+	TokenURL     string // oauth2
+	ClientID     string // oauth2
+	ClientSecret string // oauth2
+	Scopes       string // oauth2, space separated
+
+	SigningKey string        // jwt: PEM private key for RS256 and ES256, the secret for HS256
+	Algorithm  string        // jwt: HS256 (default), RS256 or ES256
+	Issuer     string        // jwt
+	Subject    string        // jwt
+	TTL        time.Duration // jwt: lifetime of a token
+
+	Audience      string        // oauth2 and jwt, optional
+	RefreshBefore time.Duration // oauth2 and jwt
+}
+
+// Auth returns the profile named by API_AUTH, false when the requests send SERVICE_ROLE_KEY;
+// an error when no AUTH_<NAME>_* setting defines it
+func (c AppConfig) Auth() (AuthProfile, bool, error) {
+	if c.APIAuth == "" {
+		return AuthProfile{}, false, nil
+	}
+	p, ok := c.AuthProfiles[strings.ToLower(c.APIAuth)]
+	if !ok {
+		return AuthProfile{}, false, fmt.Errorf("unknown auth profile %q, expected AUTH_%s_TYPE", c.APIAuth, strings.ToUpper(c.APIAuth))
+	}
+	if p.Type == AuthKey && p.Key == "" {
+		p.Key = c.ServiceRoleKey
+	}
+	return p, true, nil
+}
+
+// EndpointFor returns the configuration with the endpoint of the case, or of the channel of the case
+// when it has one: API_URL_<CASE>_<CHANNEL>, then API_URL_<CASE>, then API_URL
+func (c AppConfig) EndpointFor(caseKey, channel string) AppConfig {
+	keys := []string{rulesKey(caseKey)}
+	if channel != "" {
+		keys = append(keys, rulesKey(caseKey)+"_"+strings.ToLower(channel))
+	}
+	for _, key := range keys {
+		e, ok := c.Endpoints[key]
+		if !ok {
+			continue
+		}
+		if e.URL != "" {
+			c.APIUrl = e.URL
+		}
+		if e.Method != "" {
+			c.Function = e.Method
+		}
+		if e.Auth != "" {
+			c.APIAuth = e.Auth
+		}
+		if e.OnConflict != "" {
+			c.UpsertOnConflict = e.OnConflict
+		}
+		if e.Resolution != "" {
+			c.UpsertResolution = e.Resolution
+		}
+		if len(e.WriteBack) > 0 {
+			c.WriteBack = e.WriteBack
+		}
+		if len(e.Headers) > 0 {
+			headers := make(map[string]string, len(c.APIHeaders)+len(e.Headers))
+			maps.Copy(headers, c.APIHeaders)
+			maps.Copy(headers, e.Headers)
+			c.APIHeaders = headers
+		}
+	}
+	return c
+}
+
+// EndpointChannels returns the channels of a case with an endpoint of their own
+func (c AppConfig) EndpointChannels(caseKey string) []string {
+	var channels []string
+	for _, channel := range c.Channels {
+		if _, ok := c.Endpoints[rulesKey(caseKey)+"_"+strings.ToLower(channel)]; ok {
+			channels = append(channels, channel)
+		}
+	}
+	return channels
+}
+
+// CycleTimeoutFor returns the cycle timeout of a case, 0 when disabled
+func (c AppConfig) CycleTimeoutFor(caseKey string) time.Duration {
+	if timeout, ok := c.CycleTimeouts[caseKey]; ok {
+		return timeout
+	}
+	return c.CycleTimeouts[""]
+}
+
+// RulesFor returns the completeness rules of a case, the "more than 3 nulls" default when unset
+func (c AppConfig) RulesFor(caseKey string) validate.Rules {
+	if rules, ok := c.RecordRules[rulesKey(caseKey)]; ok {
+		return rules
+	}
+	return validate.Default()
+}
+
 func GetAppConfig() AppConfig {
 	return AppConfig{
 		APIUrl:         APIUrl,
@@ -80,11 +350,79 @@ func GetAppConfig() AppConfig {
 		Loop:           Loop,
 		Filter:         Filter,
 		InsertMode:     InsertMode,
+		Channels:       Channels,
+
+		APIHeaders: APIHeaders,
+		Endpoints:  Endpoints,
+
+		UpsertOnConflict: UpsertOnConflict,
+		UpsertResolution: UpsertResolution,
+
+		WriteBack: WriteBack,
+
+		APIAuth:      APIAuth,
+		AuthProfiles: AuthProfiles,
+
+		Sinks: Sinks,
+
+		RetryMaxAttempts: RetryMaxAttempts,
+		RetryBaseDelay:   RetryBaseDelay,
+		RetryMaxDelay:    RetryMaxDelay,
+		BatchMaxItems:    BatchMaxItems,
+		BatchMaxWait:     BatchMaxWait,
+		DeadLetterFile:   DeadLetterFile,
+
+		CycleTimeouts: CycleTimeouts,
+		TimeoutAPIUrl: TimeoutAPIUrl,
+		StatusField:   StatusField,
+
+		RecordRules: RecordRules,
+		ReasonField: ReasonField,
+
+		EmitMode:      EmitMode,
+		MergeChannels: MergeChannels,
+		CycleIDField:  CycleIDField,
+		ChannelField:  ChannelField,
+
+		CycleStartField: CycleStartField,
+		CycleEndField:   CycleEndField,
+
+		DryRun:     DryRun,
+		DryRunFile: DryRunFile,
+
+		SessionStoreFile:     SessionStoreFile,
+		SessionStoreInterval: SessionStoreInterval,
 
 		Plc: GetPlcConfig(),
 	}
 }
 
+type HttpConfig struct {
+	Timeout         time.Duration
+	DialTimeout     time.Duration
+	IdleConnTimeout time.Duration
+	MaxIdleConns    int
+	MaxConns        int
+	Proxy           string
+	CaCert          string
+	ClientCert      string
+	ClientKey       string
+}
+
+func GetHttpConfig() HttpConfig {
+	return HttpConfig{
+		Timeout:         HttpTimeout,
+		DialTimeout:     HttpDialTimeout,
+		IdleConnTimeout: HttpIdleConnTimeout,
+		MaxIdleConns:    HttpMaxIdleConns,
+		MaxConns:        HttpMaxConns,
+		Proxy:           ApiProxy,
+		CaCert:          ApiCaCert,
+		ClientCert:      ApiClientCert,
+		ClientKey:       ApiClientKey,
+	}
+}
+
 type PlcConfig struct {
 	PlcHost         string // plcHost stores the PLC's hostname
 	PlcPort         int    // plcPort stores the PLC's port number
@@ -112,7 +450,7 @@ func Load(files ...string) {
 		for _, file := range files {
 			err := godotenv.Load(file)
 			if err != nil {
-				log.Printf("Info: %s not found or failed to load local.env, falling back to system environment", file)
+				slog.Info("Env file not found or failed to load, falling back to system environment", "file", file)
 			}
 		}
 	}
@@ -120,9 +458,59 @@ func Load(files ...string) {
 	APIUrl = os.Getenv("API_URL")
 	ServiceRoleKey = getEnv("SERVICE_ROLE_KEY", "")
 	Function = getEnv("BASH_API", "")
+	APIHeaders = parseHeaders("API_HEADERS", os.Getenv("API_HEADERS"))
+	Endpoints = loadEndpoints()
+	UpsertOnConflict = os.Getenv("UPSERT_ON_CONFLICT")
+	UpsertResolution = strings.ToLower(getEnv("UPSERT_RESOLUTION", "merge"))
+	WriteBack = parseWriteBack("WRITEBACK", os.Getenv("WRITEBACK"))
+	APIAuth = os.Getenv("API_AUTH")
+	AuthProfiles = loadAuthProfiles()
 	Trigger = getEnv("TRIGGER_DEVICE", "")
 	Filter = getEnv("FILTER", "d174")
 	InsertMode = os.Getenv("INSERT_MODE")
+	Channels = parseList(getEnv("CHANNELS", "ch1,ch2,ch3"))
+	Sinks = loadSinks()
+	RetryMaxAttempts = parseInt("RETRY_MAX_ATTEMPTS", "5")
+	RetryBaseDelay = parseDuration("RETRY_BASE_DELAY", "500ms")
+	RetryMaxDelay = parseDuration("RETRY_MAX_DELAY", "30s")
+	BatchMaxItems = parseInt("BATCH_MAX_ITEMS", "100")
+	BatchMaxWait = parseDuration("BATCH_MAX_WAIT", "500ms")
+
+	OutboxDir = os.Getenv("OUTBOX_DIR")
+	OutboxRetention = parseDuration("OUTBOX_RETENTION", "168h")
+	OutboxMaxBytes = int64(parseInt("OUTBOX_MAX_MB", "512")) << 20
+
+	DeadLetterFile = os.Getenv("DEAD_LETTER_FILE")
+
+	CycleTimeouts = loadCycleTimeouts()
+	TimeoutAPIUrl = os.Getenv("TIMEOUT_API_URL")
+	StatusField = getEnv("CYCLE_STATUS_FIELD", "status")
+
+	RecordRules = loadRecordRules()
+	ReasonField = getEnv("VALIDATION_REASON_FIELD", "validation_error")
+
+	EmitMode = strings.ToLower(getEnv("EMIT_MODE", EmitPerCycle))
+	MergeChannels, _ = strconv.ParseBool(getEnv("MERGE_CHANNELS", "false"))
+	CycleIDField = getEnv("CYCLE_ID_FIELD", "cycle_id")
+	ChannelField = getEnv("CHANNEL_FIELD", "channel")
+
+	CycleStartField = getEnvOptional("CYCLE_START_FIELD", "cycle_started_at")
+	CycleEndField = getEnvOptional("CYCLE_END_FIELD", "cycle_ended_at")
+
+	DryRun, _ = strconv.ParseBool(getEnv("DRY_RUN", "false"))
+	DryRunFile = os.Getenv("DRY_RUN_FILE")
+
+	SessionStoreFile = os.Getenv("SESSION_STORE_FILE")
+	SessionStoreInterval = parseDuration("SESSION_STORE_INTERVAL", "1s")
+
+	AdminAddr = os.Getenv("ADMIN_ADDR")
+	AdminToken = os.Getenv("ADMIN_TOKEN")
+
+	MetricsAddr = os.Getenv("METRICS_ADDR")
+
+	LogLevel = getEnv("LOG_LEVEL", "info")
+	LogFormat = getEnv("LOG_FORMAT", "text")
+	LogDev, _ = strconv.ParseBool(getEnv("LOG_DEV", "false"))
 
 	LoopStr = getEnv("LOOPING", "1")
 	Loop, _ = strconv.ParseFloat(LoopStr, 64)
@@ -134,6 +522,17 @@ func Load(files ...string) {
 	ECScaCert = os.Getenv("ECS_MQTT_CA_CERTIFICATE")
 	ECSclientCert = os.Getenv("ECS_MQTT_CLIENT_CERTIFICATE")
 	ECSclientKey = os.Getenv("ECS_MQTT_PRIVATE_KEY")
+	RecordFile = os.Getenv("RECORD_FILE")
+
+	HttpTimeout = parseDuration("HTTP_TIMEOUT", "30s")
+	HttpDialTimeout = parseDuration("HTTP_DIAL_TIMEOUT", "10s")
+	HttpIdleConnTimeout = parseDuration("HTTP_IDLE_TIMEOUT", "90s")
+	HttpMaxIdleConns = parseInt("HTTP_MAX_IDLE_CONNS", "8")
+	HttpMaxConns = parseInt("HTTP_MAX_CONNS", "0")
+	ApiProxy = os.Getenv("API_PROXY")
+	ApiCaCert = os.Getenv("API_CA_CERTIFICATE")
+	ApiClientCert = os.Getenv("API_CLIENT_CERTIFICATE")
+	ApiClientKey = os.Getenv("API_PRIVATE_KEY")
 
 	PlcHost = os.Getenv("PLC_HOST")
 	PlcPortStr := getEnv("PLC_PORT", "5011")
@@ -143,6 +542,16 @@ func Load(files ...string) {
 	PlcData = os.Getenv("PLC_DATA")
 	PlcDeviceUpsert = os.Getenv("PLC_DEVICE_UPSERT")
 
+	// PLC_DEVICE_UPSERT is the write-back of the vacuum case from before WRITEBACK
+	if e := Endpoints["vacuum"]; PlcDeviceUpsert != "" && len(WriteBack) == 0 && len(e.WriteBack) == 0 {
+		m, err := writeback.Legacy(PlcDeviceUpsert)
+		if err != nil {
+			slog.Warn("Invalid setting", "key", "PLC_DEVICE_UPSERT", "value", PlcDeviceUpsert, "err", err)
+		}
+		e.WriteBack = m
+		Endpoints["vacuum"] = e
+	}
+
 }
 
 // Helper to get environment variable with fallback
@@ -154,3 +563,365 @@ func getEnv(key, fallback string) string {
 	}
 	return value
 }
+
+// Like getEnv, but a variable set to "" keeps the empty value, e.g. to switch a field off
+func getEnvOptional(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return fallback
+}
+
+// Helper to split a comma separated list, dropping empty items
+func parseList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Helper to read a duration, falling back to the default when invalid
+func parseDuration(key, fallback string) time.Duration {
+	value := getEnv(key, fallback)
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		slog.Warn("Invalid setting, using the default", "key", key, "value", value, "err", err)
+		d, _ = time.ParseDuration(fallback)
+	}
+	return d
+}
+
+// Helper to read a whole number, falling back to the default when invalid
+func parseInt(key, fallback string) int {
+	value := getEnv(key, fallback)
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		slog.Warn("Invalid setting, using the default", "key", key, "value", value, "err", err)
+		n, _ = strconv.Atoi(fallback)
+	}
+	return n
+}
+
+// Helper to read the sinks of every case, SINK for the default and SINK_<CASE> for one case,
+// e.g. SINK_HOLDFILLINGWEIGHT=upsert,merge
+func loadSinks() map[string][]string {
+	const prefix = "SINK"
+	sinks := make(map[string][]string)
+
+	for _, env := range os.Environ() {
+		parts := strings.SplitN(env, "=", 2)
+		if len(parts) != 2 || !strings.HasPrefix(parts[0], prefix) || parts[1] == "" {
+			continue
+		}
+
+		caseKey := strings.TrimPrefix(parts[0], prefix)
+		if caseKey != "" && !strings.HasPrefix(caseKey, "_") {
+			continue
+		}
+		caseKey = strings.ToLower(strings.TrimPrefix(caseKey, "_"))
+
+		names := parseList(strings.ToLower(parts[1]))
+		if len(names) == 0 {
+			continue
+		}
+		sinks[caseKey] = names
+	}
+
+	return sinks
+}
+
+// Helper to read the endpoints of the cases and channels, e.g. for channel ch1 of case holdfillingweight:
+//
+//	API_URL_HOLDFILLINGWEIGHT_CH1=https://db.local/rest/v1/filling_ch1?ink_lot=eq.{{.ink_lot}}
+//	BASH_API_HOLDFILLINGWEIGHT_CH1=PATCH
+//	API_HEADERS_HOLDFILLINGWEIGHT_CH1=Prefer: return=minimal
+//	API_AUTH_HOLDFILLINGWEIGHT_CH1=partner
+//	UPSERT_ON_CONFLICT_HOLDFILLINGWEIGHT_CH1=cycle_id
+//	WRITEBACK_HOLDFILLINGWEIGHT_CH1=result=D,610,1,1:int
+func loadEndpoints() map[string]Endpoint {
+	endpoints := make(map[string]Endpoint)
+
+	for _, env := range os.Environ() {
+		parts := strings.SplitN(env, "=", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			continue
+		}
+
+		for _, prefix := range []string{"API_URL_", "BASH_API_", "API_HEADERS_", "API_AUTH_", "UPSERT_ON_CONFLICT_", "UPSERT_RESOLUTION_", "WRITEBACK_"} {
+			key, ok := strings.CutPrefix(parts[0], prefix)
+			if !ok || key == "" {
+				continue
+			}
+			key = strings.ToLower(key)
+
+			e := endpoints[key]
+			switch prefix {
+			case "API_URL_":
+				e.URL = parts[1]
+			case "BASH_API_":
+				e.Method = parts[1]
+			case "API_HEADERS_":
+				e.Headers = parseHeaders(parts[0], parts[1])
+			case "API_AUTH_":
+				e.Auth = parts[1]
+			case "UPSERT_ON_CONFLICT_":
+				e.OnConflict = parts[1]
+			case "UPSERT_RESOLUTION_":
+				e.Resolution = strings.ToLower(parts[1])
+			case "WRITEBACK_":
+				e.WriteBack = parseWriteBack(parts[0], parts[1])
+			}
+			endpoints[key] = e
+		}
+	}
+
+	return endpoints
+}
+
+// Helper to parse a comma separated list of "Name: value" headers
+func parseHeaders(key, value string) map[string]string {
+	headers := make(map[string]string)
+	for _, item := range parseList(value) {
+		name, val, found := strings.Cut(item, ":")
+		if name = strings.TrimSpace(name); !found || name == "" {
+			slog.Warn("Invalid setting", "key", key, "value", item, "err", "expected Name: value")
+			continue
+		}
+		headers[name] = strings.TrimSpace(val)
+	}
+	return headers
+}
+
+// authFields are the settings of an auth profile, longest first so AUTH_<NAME>_SIGNING_KEY isn't read as _KEY
+var authFields = []string{
+	"REFRESH_BEFORE", "CLIENT_SECRET", "SIGNING_KEY", "TOKEN_URL", "CLIENT_ID", "ALGORITHM",
+	"AUDIENCE", "PASSWORD", "SUBJECT", "HEADER", "ISSUER", "SCOPES", "TYPE", "USER", "KEY", "TTL",
+}
+
+// Helper to read the auth profiles, e.g. for the profile partner:
+//
+//	AUTH_PARTNER_TYPE=oauth2
+//	AUTH_PARTNER_TOKEN_URL=https://login.local/oauth2/token
+//	AUTH_PARTNER_CLIENT_ID=gopatch
+//	AUTH_PARTNER_CLIENT_SECRET=secret
+func loadAuthProfiles() map[string]AuthProfile {
+	profiles := make(map[string]AuthProfile)
+
+	for _, env := range os.Environ() {
+		parts := strings.SplitN(env, "=", 2)
+		key, ok := strings.CutPrefix(parts[0], "AUTH_")
+		if !ok || len(parts) != 2 || parts[1] == "" {
+			continue
+		}
+
+		for _, field := range authFields {
+			name, ok := strings.CutSuffix(key, "_"+field)
+			if !ok || name == "" {
+				continue
+			}
+			name = strings.ToLower(name)
+
+			p := profiles[name]
+			switch field {
+			case "TYPE":
+				p.Type = strings.ToLower(parts[1])
+			case "KEY":
+				p.Key = parts[1]
+			case "USER":
+				p.User = parts[1]
+			case "PASSWORD":
+				p.Password = parts[1]
+			case "HEADER":
+				p.Header = parts[1]
+			case "TOKEN_URL":
+				p.TokenURL = parts[1]
+			case "CLIENT_ID":
+				p.ClientID = parts[1]
+			case "CLIENT_SECRET":
+				p.ClientSecret = parts[1]
+			case "SCOPES":
+				p.Scopes = strings.Join(strings.FieldsFunc(parts[1], func(r rune) bool { return r == ',' || r == ' ' }), " ")
+			case "SIGNING_KEY":
+				p.SigningKey = parts[1]
+			case "ALGORITHM":
+				p.Algorithm = strings.ToUpper(parts[1])
+			case "ISSUER":
+				p.Issuer = parts[1]
+			case "SUBJECT":
+				p.Subject = parts[1]
+			case "AUDIENCE":
+				p.Audience = parts[1]
+			case "TTL":
+				p.TTL = parseDuration(parts[0], "5m")
+			case "REFRESH_BEFORE":
+				p.RefreshBefore = parseDuration(parts[0], "1m")
+			}
+			profiles[name] = p
+			break
+		}
+	}
+
+	// Defaults of the settings left unset
+	for name, p := range profiles {
+		if p.Type == "" {
+			p.Type = AuthKey
+		}
+		if p.Algorithm == "" {
+			p.Algorithm = "HS256"
+		}
+		if p.TTL == 0 {
+			p.TTL = 5 * time.Minute
+		}
+		if p.RefreshBefore == 0 {
+			p.RefreshBefore = time.Minute
+		}
+		profiles[name] = p
+	}
+
+	return profiles
+}
+
+// Helper to parse a write-back mapping, see writeback.Parse
+func parseWriteBack(key, value string) writeback.Mapping {
+	m, err := writeback.Parse(value)
+	if err != nil {
+		slog.Warn("Invalid setting", "key", key, "value", value, "err", err)
+		return nil
+	}
+	return m
+}
+
+// Helper to read CYCLE_TIMEOUT (every case) and CYCLE_TIMEOUT_<CASE> (one case, e.g. CYCLE_TIMEOUT_HOLDFILLINGWEIGHT)
+func loadCycleTimeouts() map[string]time.Duration {
+	const prefix = "CYCLE_TIMEOUT"
+	timeouts := make(map[string]time.Duration)
+
+	for _, env := range os.Environ() {
+		parts := strings.SplitN(env, "=", 2)
+		if len(parts) != 2 || !strings.HasPrefix(parts[0], prefix) || parts[1] == "" {
+			continue
+		}
+
+		caseKey := strings.TrimPrefix(parts[0], prefix)
+		if caseKey != "" && !strings.HasPrefix(caseKey, "_") {
+			continue
+		}
+		caseKey = strings.ToLower(strings.TrimPrefix(caseKey, "_"))
+
+		timeout, err := time.ParseDuration(parts[1])
+		if err != nil {
+			slog.Warn("Invalid setting", "key", parts[0], "value", parts[1], "err", err)
+			continue
+		}
+		timeouts[caseKey] = timeout
+	}
+
+	return timeouts
+}
+
+// Helper to read the completeness rules of each case, e.g. for case holdfillingweight:
+//
+//	RULES_HOLDFILLINGWEIGHT_REQUIRED=ink_lot,ch1_weighing
+//	RULES_HOLDFILLINGWEIGHT_OPTIONAL=ch1_do
+//	RULES_HOLDFILLINGWEIGHT_TYPE_ink_lot=string
+//	RULES_HOLDFILLINGWEIGHT_RANGE_ch1_weighing=0:500
+//	RULES_HOLDFILLINGWEIGHT_MAX_NULLS=0
+//	RULES_HOLDFILLINGWEIGHT_ON_INVALID=drop|flag|route
+//	RULES_HOLDFILLINGWEIGHT_ROUTE_API_URL=http://localhost/rest/v1/rejected
+func loadRecordRules() map[string]validate.Rules {
+	const prefix = "RULES_"
+	suffixes := []string{"_REQUIRED", "_OPTIONAL", "_TYPE_", "_RANGE_", "_MAX_NULLS", "_ON_INVALID", "_ROUTE_API_URL"}
+	rules := make(map[string]validate.Rules)
+
+	for _, env := range os.Environ() {
+		parts := strings.SplitN(env, "=", 2)
+		if len(parts) != 2 || !strings.HasPrefix(parts[0], prefix) {
+			continue
+		}
+		name, value := strings.TrimPrefix(parts[0], prefix), parts[1]
+
+		for _, suffix := range suffixes {
+			i := strings.Index(name, suffix)
+			if i <= 0 {
+				continue
+			}
+			caseKey := rulesKey(name[:i])
+			field := name[i+len(suffix):]
+
+			r, ok := rules[caseKey]
+			if !ok {
+				r = validate.Rules{MaxNulls: -1, OnInvalid: validate.ActionDrop}
+			}
+
+			switch suffix {
+			case "_REQUIRED":
+				r.Required = parseList(value)
+			case "_OPTIONAL":
+				r.Optional = parseList(value)
+			case "_TYPE_":
+				if r.Types == nil {
+					r.Types = make(map[string]string)
+				}
+				r.Types[field] = strings.ToLower(value)
+			case "_RANGE_":
+				bounds, err := parseRange(value)
+				if err != nil {
+					slog.Warn("Invalid setting", "key", parts[0], "value", value, "err", err)
+					break
+				}
+				if r.Ranges == nil {
+					r.Ranges = make(map[string]validate.Range)
+				}
+				r.Ranges[field] = bounds
+			case "_MAX_NULLS":
+				maxNulls, err := strconv.Atoi(value)
+				if err != nil {
+					slog.Warn("Invalid setting", "key", parts[0], "value", value, "err", err)
+					break
+				}
+				r.MaxNulls = maxNulls
+			case "_ON_INVALID":
+				r.OnInvalid = strings.ToLower(value)
+			case "_ROUTE_API_URL":
+				r.RouteAPIUrl = value
+			}
+
+			rules[caseKey] = r
+			break
+		}
+	}
+
+	return rules
+}
+
+// Helper to normalise a case key for env lookups, e.g. "time.duration" and "TIME_DURATION"
+func rulesKey(caseKey string) string {
+	return strings.ToLower(strings.ReplaceAll(caseKey, ".", "_"))
+}
+
+// Helper to parse a "min:max" range, either side may be empty
+func parseRange(value string) (validate.Range, error) {
+	var bounds validate.Range
+	minStr, maxStr, found := strings.Cut(value, ":")
+	if !found {
+		return bounds, fmt.Errorf("expected min:max")
+	}
+	if minStr = strings.TrimSpace(minStr); minStr != "" {
+		min, err := strconv.ParseFloat(minStr, 64)
+		if err != nil {
+			return bounds, err
+		}
+		bounds.Min = &min
+	}
+	if maxStr = strings.TrimSpace(maxStr); maxStr != "" {
+		max, err := strconv.ParseFloat(maxStr, 64)
+		if err != nil {
+			return bounds, err
+		}
+		bounds.Max = &max
+	}
+	return bounds, nil
+}
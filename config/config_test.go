@@ -2,7 +2,9 @@ package config
 
 import (
 	"os"
+	"reflect"
 	"testing"
+	"time"
 )
 
 // TestLoadEnv verifies that environment variables are correctly loaded from a file
@@ -26,3 +28,206 @@ func TestLoadEnv(t *testing.T) {
 		t.Errorf("Expected 'env_value', got '%s'", value)
 	}
 }
+
+// TestLoadChannels verifies that the channel list is parsed from CHANNELS
+func TestLoadChannels(t *testing.T) {
+	t.Setenv("CHANNELS", "ch1, ch2,,ch3,ch4,ch5,ch6")
+
+	Load()
+
+	expected := []string{"ch1", "ch2", "ch3", "ch4", "ch5", "ch6"}
+	if len(Channels) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, Channels)
+	}
+	for i := range expected {
+		if Channels[i] != expected[i] {
+			t.Errorf("Expected %v, got %v", expected, Channels)
+		}
+	}
+}
+
+// TestCycleTimeouts verifies the default and per-case cycle timeouts
+func TestCycleTimeouts(t *testing.T) {
+	t.Setenv("CYCLE_TIMEOUT", "5m")
+	t.Setenv("CYCLE_TIMEOUT_HOLDFILLINGWEIGHT", "90s")
+
+	Load()
+	cfg := GetAppConfig()
+
+	if got := cfg.CycleTimeoutFor("holdfillingweight"); got != 90*time.Second {
+		t.Errorf("Expected 90s, got %s", got)
+	}
+	if got := cfg.CycleTimeoutFor("hold"); got != 5*time.Minute {
+		t.Errorf("Expected 5m, got %s", got)
+	}
+}
+
+// TestInvalidNumbers verifies an invalid number falls back to the default
+func TestInvalidNumbers(t *testing.T) {
+	t.Setenv("RETRY_MAX_ATTEMPTS", "five")
+	t.Setenv("BATCH_MAX_ITEMS", "250")
+
+	Load()
+	cfg := GetAppConfig()
+
+	if cfg.RetryMaxAttempts != 5 {
+		t.Errorf("Expected the default of 5 attempts, got %d", cfg.RetryMaxAttempts)
+	}
+	if cfg.BatchMaxItems != 250 {
+		t.Errorf("Expected 250 items, got %d", cfg.BatchMaxItems)
+	}
+}
+
+// TestSinks verifies the default sinks follow INSERT_MODE and can be set per case
+func TestSinks(t *testing.T) {
+	t.Setenv("INSERT_MODE", "upsert")
+	t.Setenv("SINK_HOLDFILLINGWEIGHT", "REST, merge")
+	t.Setenv("SINK_TIME_DURATION", "rest")
+
+	Load()
+	cfg := GetAppConfig()
+
+	if got := cfg.SinksFor("hold"); !reflect.DeepEqual(got, []string{"upsert"}) {
+		t.Errorf("Expected [upsert], got %v", got)
+	}
+	if got := cfg.SinksFor("holdfillingweight"); !reflect.DeepEqual(got, []string{"rest", "merge"}) {
+		t.Errorf("Expected [rest merge], got %v", got)
+	}
+	if got := cfg.SinksFor("time.duration"); !reflect.DeepEqual(got, []string{"rest"}) {
+		t.Errorf("Expected [rest] for the dotted case key, got %v", got)
+	}
+}
+
+// TestEndpoints verifies the endpoint of a channel overrides the one of its case, which overrides API_URL
+func TestEndpoints(t *testing.T) {
+	t.Setenv("API_URL", "https://db.local/rest/v1/filling?ink_lot=eq.{{.ink_lot}}")
+	t.Setenv("BASH_API", "PATCH")
+	t.Setenv("API_HEADERS", "Prefer: return=minimal, X-Line: {{.line}}")
+	t.Setenv("API_URL_HOLDFILLINGWEIGHT", "https://db.local/rest/v1/hold")
+	t.Setenv("BASH_API_HOLDFILLINGWEIGHT_CH2", "POST")
+	t.Setenv("API_HEADERS_HOLDFILLINGWEIGHT_CH2", "Prefer: return=representation")
+	t.Setenv("UPSERT_ON_CONFLICT_HOLDFILLINGWEIGHT", "cycle_id")
+	t.Setenv("UPSERT_RESOLUTION_HOLDFILLINGWEIGHT_CH2", "Ignore")
+	t.Setenv("CHANNELS", "ch1,ch2")
+
+	Load()
+	cfg := GetAppConfig()
+
+	if got := cfg.EndpointFor("degas", ""); got.APIUrl != APIUrl || got.Function != "PATCH" {
+		t.Errorf("Expected API_URL and BASH_API for degas, got %s %s", got.Function, got.APIUrl)
+	}
+	if got := cfg.EndpointFor("holdfillingweight", "ch1"); got.APIUrl != "https://db.local/rest/v1/hold" || got.Function != "PATCH" {
+		t.Errorf("Expected the case URL for ch1, got %s %s", got.Function, got.APIUrl)
+	}
+	got := cfg.EndpointFor("holdfillingweight", "ch2")
+	if got.APIUrl != "https://db.local/rest/v1/hold" || got.Function != "POST" {
+		t.Errorf("Expected the case URL and channel method for ch2, got %s %s", got.Function, got.APIUrl)
+	}
+	want := map[string]string{"Prefer": "return=representation", "X-Line": "{{.line}}"}
+	if !reflect.DeepEqual(got.APIHeaders, want) {
+		t.Errorf("Expected %v, got %v", want, got.APIHeaders)
+	}
+	if cfg.APIHeaders["Prefer"] != "return=minimal" {
+		t.Errorf("Expected API_HEADERS unchanged, got %v", cfg.APIHeaders)
+	}
+	if got.UpsertOnConflict != "cycle_id" || got.UpsertResolution != "ignore" {
+		t.Errorf("Expected on_conflict of the case and resolution of the channel, got %q %q", got.UpsertOnConflict, got.UpsertResolution)
+	}
+	if got := cfg.EndpointFor("degas", ""); got.UpsertOnConflict != "" || got.UpsertResolution != "merge" {
+		t.Errorf("Expected the merge default for degas, got %q %q", got.UpsertOnConflict, got.UpsertResolution)
+	}
+	if channels := cfg.EndpointChannels("holdfillingweight"); !reflect.DeepEqual(channels, []string{"ch2"}) {
+		t.Errorf("Expected [ch2], got %v", channels)
+	}
+}
+
+// TestWriteBack verifies the write-back of a case replaces WRITEBACK, and PLC_DEVICE_UPSERT still maps the vacuum case
+func TestWriteBack(t *testing.T) {
+	t.Setenv("WRITEBACK_HOLDFILLINGWEIGHT", "result=D,610,1,1:int")
+	t.Setenv("PLC_DEVICE_UPSERT", "D,610,1,1,D,611,1,1,M,612,1,1")
+
+	Load()
+	cfg := GetAppConfig()
+
+	if got := cfg.EndpointFor("holdfillingweight", "").WriteBack; len(got) != 1 || got[0].Path != "result" || got[0].Type != "int" {
+		t.Errorf("Expected the result mapping for holdfillingweight, got %+v", got)
+	}
+	got := cfg.EndpointFor("vacuum", "").WriteBack
+	if len(got) != 3 || got[0].Path != "y_status" || got[2].Device != "M,612,1,1" {
+		t.Errorf("Expected the PLC_DEVICE_UPSERT mapping for vacuum, got %+v", got)
+	}
+	if got := cfg.EndpointFor("hold", "").WriteBack; len(got) != 0 {
+		t.Errorf("Expected no write-back for hold, got %+v", got)
+	}
+}
+
+// TestAuthProfiles verifies the AUTH_<NAME>_* profiles and the profile of an endpoint
+func TestAuthProfiles(t *testing.T) {
+	t.Setenv("SERVICE_ROLE_KEY", "service-role-key")
+	t.Setenv("API_AUTH", "supabase")
+	t.Setenv("AUTH_SUPABASE_TYPE", "key")
+	t.Setenv("AUTH_PARTNER_API_TYPE", "JWT")
+	t.Setenv("AUTH_PARTNER_API_SIGNING_KEY", "secret")
+	t.Setenv("AUTH_PARTNER_API_ISSUER", "gopatch")
+	t.Setenv("AUTH_PARTNER_API_TTL", "2m")
+	t.Setenv("API_AUTH_HOLDFILLINGWEIGHT", "partner_api")
+
+	Load()
+	cfg := GetAppConfig()
+
+	p, ok, err := cfg.Auth()
+	if err != nil || !ok || p.Type != AuthKey || p.Key != "service-role-key" {
+		t.Errorf("Expected the key profile with SERVICE_ROLE_KEY, got %+v, %v, %v", p, ok, err)
+	}
+	p, _, err = cfg.EndpointFor("holdfillingweight", "").Auth()
+	want := AuthProfile{Type: AuthJWT, SigningKey: "secret", Algorithm: "HS256", Issuer: "gopatch", TTL: 2 * time.Minute, RefreshBefore: time.Minute}
+	if err != nil || p != want {
+		t.Errorf("Expected %+v, got %+v, %v", want, p, err)
+	}
+
+	cfg.APIAuth = "unknown"
+	if _, _, err := cfg.Auth(); err == nil {
+		t.Error("Expected an error for an unknown profile")
+	}
+}
+
+// TestRecordRules verifies the completeness rules are read per case
+func TestRecordRules(t *testing.T) {
+	t.Setenv("RULES_HOLDFILLINGWEIGHT_REQUIRED", "ink_lot,ch1_weighing")
+	t.Setenv("RULES_HOLDFILLINGWEIGHT_TYPE_ink_lot", "String")
+	t.Setenv("RULES_HOLDFILLINGWEIGHT_RANGE_ch1_weighing", "0:500")
+	t.Setenv("RULES_HOLDFILLINGWEIGHT_ON_INVALID", "route")
+	t.Setenv("RULES_TIME_DURATION_MAX_NULLS", "1")
+
+	Load()
+	cfg := GetAppConfig()
+
+	rules := cfg.RulesFor("holdfillingweight")
+	if len(rules.Required) != 2 || rules.Types["ink_lot"] != "string" || rules.OnInvalid != "route" || rules.MaxNulls != -1 {
+		t.Errorf("Unexpected rules %+v", rules)
+	}
+	if r := rules.Ranges["ch1_weighing"]; r.Min == nil || *r.Min != 0 || r.Max == nil || *r.Max != 500 {
+		t.Errorf("Unexpected range %+v", r)
+	}
+	if rules := cfg.RulesFor("time.duration"); rules.MaxNulls != 1 {
+		t.Errorf("Expected MaxNulls 1 for time.duration, got %+v", rules)
+	}
+	if rules := cfg.RulesFor("hold"); rules.MaxNulls != 3 || rules.OnInvalid != "drop" {
+		t.Errorf("Expected default rules for hold, got %+v", rules)
+	}
+}
+
+// TestCycleFields verifies the cycle fields default and can be switched off
+func TestCycleFields(t *testing.T) {
+	t.Setenv("CYCLE_END_FIELD", "")
+
+	Load()
+	cfg := GetAppConfig()
+
+	if cfg.CycleIDField != "cycle_id" || cfg.CycleStartField != "cycle_started_at" {
+		t.Errorf("Unexpected cycle fields %q, %q", cfg.CycleIDField, cfg.CycleStartField)
+	}
+	if cfg.CycleEndField != "" {
+		t.Errorf("Expected CYCLE_END_FIELD to be switched off, got %q", cfg.CycleEndField)
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gopatch/config"
+	"gopatch/handler"
+	"gopatch/internal/app"
+	"gopatch/internal/auth"
+	"gopatch/internal/deadletter"
+	"gopatch/internal/logging"
+	"gopatch/internal/sink"
+	"gopatch/patch"
+)
+
+// runDLQ inspects the records saved to DEAD_LETTER_FILE and resends or drops them.
+//
+//	gopatch dlq list [-env .env.local] [-file path] [-v]
+//	gopatch dlq resend [-env .env.local] [-file path] [-all] [id...]
+//	gopatch dlq drop [-env .env.local] [-file path] [-all] [id...]
+//
+// An id may be a prefix of the ID shown by list.
+func runDLQ(args []string) error {
+	usage := "Usage: gopatch dlq list|resend|drop [-env file] [-file path] [-all] [-v] [id...]"
+	if len(args) == 0 {
+		return fmt.Errorf("%s", usage)
+	}
+	command := args[0]
+
+	fs := flag.NewFlagSet("dlq "+command, flag.ExitOnError)
+	envFile := fs.String("env", ".env.local", "env file with the sink configuration")
+	file := fs.String("file", "", "dead-letter file, default DEAD_LETTER_FILE")
+	all := fs.Bool("all", false, "resend or drop every dead letter")
+	verbose := fs.Bool("v", false, "list the record and response body as well")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), usage)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args[1:])
+
+	config.Load(*envFile)
+	logging.Setup(os.Stderr, config.LogLevel, config.LogFormat, config.LogDev)
+	if *file == "" {
+		*file = config.DeadLetterFile
+	}
+	if *file == "" {
+		return fmt.Errorf("no dead-letter file, set DEAD_LETTER_FILE or -file")
+	}
+
+	ids := fs.Args()
+	selected := func(e deadletter.Entry) bool {
+		return *all || deadletter.Match(e, ids)
+	}
+	if command != "list" && !*all && len(ids) == 0 {
+		fs.Usage()
+		return fmt.Errorf("expected dead-letter IDs or -all")
+	}
+
+	switch command {
+	case "list":
+		entries, err := deadletter.Read(*file)
+		if err != nil {
+			return err
+		}
+		listDeadLetters(os.Stdout, entries, *verbose)
+		return nil
+
+	case "resend":
+		cfg := config.GetAppConfig()
+		if err := sink.Check(cfg); err != nil {
+			return err
+		}
+		apiClient, err := patch.NewClient(config.GetHttpConfig())
+		if err != nil {
+			return err
+		}
+		patch.SetClient(apiClient)
+		auth.SetClient(apiClient)
+		entries, err := deadletter.Read(*file)
+		if err != nil {
+			return err
+		}
+
+		// Send without holding the file, so the running service can still add dead letters meanwhile
+		results := map[string]error{}
+		var plc app.PLCWriter
+		plcTried := false
+		for _, e := range entries {
+			if !selected(e) {
+				continue
+			}
+			// Connect to the PLC for the first record writing to it; without it those records stay
+			if !plcTried && handler.NeedsPLC(e, cfg) {
+				plcTried = true
+				plcApp, err := app.NewApplication(config.GetPlcConfig(), slog.Default().With("component", "plc"))
+				if err != nil {
+					slog.Error("Failed to connect to the PLC, records writing to it are not resent", "err", err)
+				} else {
+					defer plcApp.Close()
+					plc = plcApp
+				}
+			}
+			err := handler.Resend(context.Background(), e, cfg, plc)
+			results[e.ID] = err
+			if err != nil {
+				fmt.Printf("%s\tfailed\t%v\n", e.ID, err)
+			} else {
+				fmt.Printf("%s\tsent\n", e.ID)
+			}
+		}
+
+		// The sent entries are removed, the failed ones stay with the new error
+		return deadletter.Update(*file, func(entries []deadletter.Entry) ([]deadletter.Entry, error) {
+			var kept []deadletter.Entry
+			for _, e := range entries {
+				err, resent := results[e.ID]
+				if resent && err == nil {
+					continue
+				}
+				if resent {
+					e.Error, e.Time, e.Status, e.Response = err.Error(), time.Now(), 0, ""
+					var status *patch.StatusError
+					if errors.As(err, &status) {
+						e.Status, e.Response = status.Code, status.Body
+					}
+				}
+				kept = append(kept, e)
+			}
+			return kept, nil
+		})
+
+	case "drop":
+		return deadletter.Update(*file, func(entries []deadletter.Entry) ([]deadletter.Entry, error) {
+			var kept []deadletter.Entry
+			for _, e := range entries {
+				if selected(e) {
+					fmt.Printf("%s\tdropped\n", e.ID)
+					continue
+				}
+				kept = append(kept, e)
+			}
+			return kept, nil
+		})
+	}
+
+	fs.Usage()
+	return fmt.Errorf("unknown dlq command %q", command)
+}
+
+// listDeadLetters prints one line per dead letter, with the record and response below it when verbose
+func listDeadLetters(out *os.File, entries []deadletter.Entry, verbose bool) {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tTIME\tCASE\tCYCLE\tSTATUS\tERROR")
+	for _, e := range entries {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\n",
+			e.ID, e.Time.Format(time.RFC3339), e.Case, e.Cycle, e.Status, firstLine(e.Error))
+		if verbose {
+			record, _ := json.Marshal(e.Data)
+			fmt.Fprintf(w, "\trecord: %s\n", record)
+			if e.Response != "" {
+				fmt.Fprintf(w, "\tresponse: %s\n", firstLine(e.Response))
+			}
+		}
+	}
+	w.Flush()
+	fmt.Fprintf(out, "%d dead letters\n", len(entries))
+}
+
+func firstLine(s string) string {
+	s, _, _ = strings.Cut(strings.TrimSpace(s), "\n")
+	return s
+}
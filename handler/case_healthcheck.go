@@ -1,46 +1,53 @@
 package handler
 
 import (
+	"context"
 	"gopatch/config"
 	"gopatch/internal/app"
+	"gopatch/internal/clock"
 	"gopatch/internal/session"
 	"gopatch/internal/utils"
+	"log/slog"
 	"os"
 )
 
 // CASE 10, Vacuum; Collect Vacuum Check data to patch.
 func handleVacuumCase(session *session.Session, jsonPayloads *utils.SafeJsonPayloads,
-	cfg config.AppConfig, rMsgJSONChan <-chan string, plcApp *app.Application) {
+	cfg config.AppConfig, rMsgJSONChan <-chan string, plcApp app.PLCWriter, clk clock.Clock) {
 
 	// Check trigger
 	triggerValue, ok := jsonPayloads.GetBool(os.Getenv("CASE_10_TRIGGER_UPLOAD"))
 	if ok && triggerValue {
-		session.Mutex.Lock()
-		defer session.Mutex.Unlock()
-
 		MAP_NAME := "healthcheck"
-		if session.ProcessedPayloadsMap[MAP_NAME] == nil {
-			session.ProcessedPayloadsMap[MAP_NAME] = make(map[string]any)
-		}
 
 		for _, channel := range []string{"1min", "2min", "3min"} {
 			if val, found := jsonPayloads.Get(os.Getenv("CASE_10_VACUUM_LEAVE_" + channel)); found {
-				session.ProcessedPayloadsMap[MAP_NAME]["vacuum_leave_"+channel] = val
+				session.SetField(MAP_NAME, "vacuum_leave_"+channel, val)
 			}
 		}
 
 		if val2, found := jsonPayloads.Get(os.Getenv("CASE_10_VACUUM_START")); found {
-			session.ProcessedPayloadsMap[MAP_NAME]["vacuum_start"] = val2
+			session.SetField(MAP_NAME, "vacuum_start", val2)
 		}
 
-		session.IsProcessing = true
+		session.SetProcessing(true)
 	}
 
-	if session.IsProcessing {
+	if session.IsProcessing() {
 		keys := []string{
 			"healthcheck",
 		}
-		processPatch(session, keys, cfg, func() {}, rMsgJSONChan, plcApp)
+		processPatch(session, "vacuum", keys, cfg, func() {}, rMsgJSONChan, plcApp, clk)
 	}
 
 }
+
+// signalPLC tells the PLC the vacuum check was written (PLC_DEVICE/PLC_DATA)
+func signalPLC(ctx context.Context, cfg config.AppConfig, plcApp app.PLCWriter) {
+	if plcApp == nil {
+		return
+	}
+	if err := plcApp.WritePLC(ctx, cfg.Plc.PlcDevice, cfg.Plc.PlcData); err != nil {
+		slog.ErrorContext(ctx, "PLC write failed", "case", "vacuum", "device", cfg.Plc.PlcDevice, "err", err)
+	}
+}
@@ -1,63 +1,58 @@
 package handler
 
 import (
-	"encoding/json"
 	"fmt"
 	"gopatch/config"
+	"gopatch/internal/app"
+	"gopatch/internal/clock"
 	"gopatch/internal/session"
 	"gopatch/internal/utils"
 	"gopatch/model"
-	"gopatch/patch"
+	"log/slog"
 	"os"
 	"strconv"
 	"strings"
 	"sync"
-	"time"
 )
 
 // CASE 3, Trigger; handling the device when triggered and hold for 4second to collect data to patch.
 func handleTriggerCase(tk utils.TriggerKey, jsonPayloads *utils.SafeJsonPayloads, messages []model.Message,
-	cfg config.AppConfig) {
+	cfg config.AppConfig, plcApp app.PLCWriter, clk clock.Clock) {
 
 	if value, ok := jsonPayloads.GetFloat64(tk.TriggerKey); ok && value == 1 {
 
-		startTime := time.Now()
-		processMessagesLoop(jsonPayloads, messages, startTime, cfg.Loop)
+		startTime := clk.Now()
+		processMessagesLoop(jsonPayloads, messages, startTime, cfg.Loop, clk)
 
 		if _filter, ok := jsonPayloads.GetFloat64(cfg.Filter); ok && _filter != 0 {
 			utils.CalculateAndStoreInklot(jsonPayloads)
 			utils.ChangeName(jsonPayloads)
+			ctx := stampPayloadCycle(jsonPayloads, startTime, cfg, clk)
 
-			jsonData, err := json.Marshal(jsonPayloads)
-			if err != nil {
-				fmt.Println("Error marshaling JSON:", err)
+			if err := writeRecord(ctx, tk.CaseKey, jsonPayloads.GetData(), recordTarget{}, cfg, plcApp, clk); err != nil {
+				slog.ErrorContext(ctx, "Failed to send record", "case", tk.CaseKey, "err", err)
 				return
 			}
 
-			_, err = patch.SendPatchRequest(cfg.APIUrl, cfg.ServiceRoleKey, jsonData, cfg.Function)
-			if err != nil {
-				panic(err)
-			}
-
-			elapsedTime := time.Since(startTime)
-			prettyPrintJSONWithTime(jsonPayloads, elapsedTime)
+			elapsedTime := clk.Since(startTime)
+			logRecord(ctx, tk.CaseKey, jsonPayloads, elapsedTime)
 		}
 	}
 }
 
 // CASE 4, Hold; hold the data and wait until patch trigger
 func handleHoldCase(session *session.Session, jsonPayloads *utils.SafeJsonPayloads, messages []model.Message,
-	cfg config.AppConfig, checkAccumulateRate AccumCheckFunc) {
+	cfg config.AppConfig, checkAccumulateRate AccumCheckFunc, plcApp app.PLCWriter, clk clock.Clock) {
 
 	if checkAccumulateRate() {
 		return
 	}
 
 	// handle the different types (string and float64) of CH1_TRIGGER.
-	// And Store the Filling parameter of CH1 when the trigger is true.
-	processChannelTrigger("CASE_4_TRIGGER_CH1", "ch1_", jsonPayloads, messages, session)
-	processChannelTrigger("CASE_4_TRIGGER_CH2", "ch2_", jsonPayloads, messages, session)
-	processChannelTrigger("CASE_4_TRIGGER_CH3", "ch3_", jsonPayloads, messages, session)
+	// And Store the Filling parameter of each channel when the trigger is true.
+	for _, channel := range cfg.Channels {
+		processChannelTrigger(channelEnv("CASE_4_TRIGGER_", channel), channel+"_", jsonPayloads, messages, session)
+	}
 
 	VACUUM_TRIGGER, _ := jsonPayloads.Get(os.Getenv("CASE_4_VACUUM_reach_20pa"))
 	if VACUUM_TRIGGER != nil {
@@ -69,90 +64,60 @@ func handleHoldCase(session *session.Session, jsonPayloads *utils.SafeJsonPayloa
 			// Use the function with the condition
 			//processAndPrintforVacuum("vacuum", jsonPayloads, messages, loop)
 			value, exists := jsonPayloads.Get("vacuum")
-			if exists {
-				fmt.Println(value)
-			} else {
-				fmt.Println("Key not found")
-			}
+			slog.Debug("Sealing started", "case", "hold", "vacuum", value, "found", exists)
 
 			// After the goroutine has finished, set prevSealing = sealing
-			session.PrevSealing = sealing
-		} else if sealing == 0 && session.PrevSealing == 1 {
+			session.SetPrevSealing(sealing)
+		} else if sealing == 0 && session.PrevSealing() == 1 {
 			// Use the function to merge payloads
-			data := mergeNonEmptyMaps(
-				session.ProcessedPayloadsMap["ch1_"],
-				session.ProcessedPayloadsMap["ch2_"],
-				session.ProcessedPayloadsMap["ch3_"],
-				session.ProcessedPayloadsMap["vacuum"],
-			)
-
-			startTime := time.Now()
-			jsonData, err := json.Marshal(data)
-			if err != nil {
-				fmt.Println("Error marshaling JSON:", err)
+			data := session.Merge(append(channelKeys(cfg.Channels, "", "_"), "vacuum")...)
+			ctx := stampSessionCycle(session, "hold", data, cfg, clk)
+
+			target, ok := checkRecord("hold", data, cfg)
+			if !ok {
+				session.SetPrevSealing(sealing)
+				endCycle(session, "hold", cycleInvalid)
 				return
 			}
 
-			_, err = patch.SendPatchRequest(cfg.APIUrl, cfg.ServiceRoleKey, jsonData, cfg.Function)
-			if err != nil {
-				panic(err)
+			startTime := clk.Now()
+			if err := writeRecord(ctx, "hold", data, target, cfg, plcApp, clk); err != nil {
+				slog.ErrorContext(ctx, "Failed to send record", "case", "hold", "err", err)
+				session.SetPrevSealing(sealing)
+				endCycle(session, "hold", cycleFailed)
+				return
 			}
 
-			elapsedTime := time.Since(startTime)
-			prettyPrintJSONWithTime(data, elapsedTime)
+			elapsedTime := clk.Since(startTime)
+			logRecord(ctx, "hold", data, elapsedTime)
 			// Update the previous state of sealing
-			session.PrevSealing = sealing
+			session.SetPrevSealing(sealing)
+			endCycle(session, "hold", cycleCompleted)
 		}
 	}
 }
 
 // CASE 6, HoldFilling; handling the device when triggered and hold for 4second to collect data to patch.
 func handleHoldFillingCase(session *session.Session, jsonPayloads *utils.SafeJsonPayloads, messages []model.Message,
-	cfg config.AppConfig, rMsgJSONChan <-chan string) {
-
-	triggerChannels := []string{"ch1", "ch2", "ch3"}
-
-	for _, channel := range triggerChannels {
-		// Retrieve NUMBERofSTATE from environment variable and convert to float64
-		NUMBERofSTATEStr := os.Getenv("CASE_6_TRIGGER_NUMBERofSTATE")
-		NUMBERofSTATE, err := strconv.ParseFloat(NUMBERofSTATEStr, 64)
-		if err != nil {
-			fmt.Println("Error parsing NUMBERofSTATE:", err)
-			continue
-		}
+	cfg config.AppConfig, rMsgJSONChan <-chan string, plcApp app.PLCWriter, clk clock.Clock) {
 
-		// Retrieve trigger value from JSON payload
-		triggerValue, ok := jsonPayloads.GetFloat64(os.Getenv("CASE_6_TRIGGER_" + channel))
-		if ok && triggerValue == NUMBERofSTATE {
-			session.Mutex.Lock()
-			defer session.Mutex.Unlock()
-
-			session.ProcessedPayloadsMap[channel][channel+"_fill"] = 1
-			session.IsProcessing = true
-		}
-	}
+	markFillingChannels(session, jsonPayloads, cfg.Channels)
 
 	// Check if all channels are successful and processing is active
-	// Use a flag to track if all channels have success = 0
-	ch1, ok1 := jsonPayloads.GetFloat64(os.Getenv("CASE_6_TRIGGER_ch1"))
-	ch2, ok2 := jsonPayloads.GetFloat64(os.Getenv("CASE_6_TRIGGER_ch2"))
-	ch3, ok3 := jsonPayloads.GetFloat64(os.Getenv("CASE_6_TRIGGER_ch3"))
-	session.AllSuccessZero = ok1 && ok2 && ok3 && ch1 == 0 && ch2 == 0 && ch3 == 0
+	session.SetAllSuccessZero(allChannelsAtZero(jsonPayloads, cfg.Channels))
 
-	if session.AllSuccessZero && session.IsProcessing {
+	if session.AllSuccessZero() && session.IsProcessing() {
 		prevDo := false
 
-		session.ProcessedPayloadsMap["do"] = utils.ProcessTriggerGeneric(jsonPayloads, messages, func(payload *utils.SafeJsonPayloads) map[string]any {
+		session.SetPayload("do", utils.ProcessTriggerGeneric(jsonPayloads, messages, func(payload *utils.SafeJsonPayloads) map[string]any {
 			prevDo = true
 			return utils.Hold_changeName_generic(payload, "CASE_6_DO_", nil)
-		})
+		}))
 
 		processWeightTriggers(session, jsonPayloads, messages)
 		if shouldPatch("case8", prevDo, session) {
-			keys := []string{
-				"ch1", "ch2", "ch3", "do",
-			}
-			processPatch(session, keys, cfg, func() { prevDo = false }, rMsgJSONChan, nil)
+			keys := append(channelKeys(cfg.Channels, "", ""), "do")
+			processPatch(session, "holdfilling", keys, cfg, func() { prevDo = false }, rMsgJSONChan, plcApp, clk)
 		}
 
 	}
@@ -161,19 +126,21 @@ func handleHoldFillingCase(session *session.Session, jsonPayloads *utils.SafeJso
 
 // CASE 7, Weight; hold the data and wait until weighing scale trigger to collect data to patch.
 func handleWeight(session *session.Session, jsonPayloads *utils.SafeJsonPayloads, messages []model.Message,
-	cfg config.AppConfig, chance bool, checkAccumulateRate AccumCheckFunc, rMsgJSONChan <-chan string) {
+	cfg config.AppConfig, chance bool, checkAccumulateRate AccumCheckFunc, rMsgJSONChan <-chan string, plcApp app.PLCWriter, clk clock.Clock) {
 
 	if checkAccumulateRate() {
 		chance = true
 	}
 
-	// Process to handling counter when ch1 started
-	processChannelTrigger("CASE_4_TRIGGER_CH1", "counterch_", jsonPayloads, messages, session)
+	// Process to handling counter when the first channel started
+	if len(cfg.Channels) > 0 {
+		processChannelTrigger(channelEnv("CASE_4_TRIGGER_", cfg.Channels[0]), "counterch_", jsonPayloads, messages, session)
+	}
 
 	// Process triggers for each channel
 	// Handle different types (string and float64) of CH1_TRIGGER, CH2_TRIGGER, CH3_TRIGGER.
-	for _, channel := range []string{"ch1_", "ch2_", "ch3_"} {
-		processChannelTrigger("CASE_4_TRIGGER_"+strings.ToUpper(channel[:3]), channel, jsonPayloads, messages, session)
+	for _, channel := range cfg.Channels {
+		processChannelTrigger(channelEnv("CASE_4_TRIGGER_", channel), channel+"_", jsonPayloads, messages, session)
 	}
 
 	// Process Vacuum Trigger
@@ -182,66 +149,54 @@ func handleWeight(session *session.Session, jsonPayloads *utils.SafeJsonPayloads
 		processAndPrintforVacuum("vacuum", jsonPayloads, messages, session)
 	}
 
-	// Process CH1, CH2, CH3 Weight Triggers
-	// Check if all weight triggers (CH1, CH2, CH3) are inactive, but were previously active
+	// Process the weight trigger of every channel
+	// Check if all weight triggers are inactive, but were previously active
 	processWeightTriggers(session, jsonPayloads, messages)
-	fmt.Println(jsonPayloads)
+	slog.Debug("Batch processed", "case", "weight", "payloads", jsonPayloads)
+	if cfg.EmitMode == config.EmitPerChannel {
+		emitCompletedChannels(session, "weight", chance, cfg, func(channel string) []string {
+			return []string{channel + "_", "weight" + channel + "_", "vacuum", "counterch_"}
+		}, plcApp, clk)
+		return
+	}
 	if shouldPatch("case7", chance, session) {
-		keys := []string{
-			"ch1_", "ch2_", "ch3_", "vacuum", "weightch1_", "weightch2_", "weightch3_", "counterch_",
-		}
-		processPatch(session, keys, cfg, func() { session.IsProcessing = false }, rMsgJSONChan, nil)
+		keys := channelKeys(cfg.Channels, "", "_")
+		keys = append(keys, "vacuum")
+		keys = append(keys, channelKeys(cfg.Channels, "weight", "_")...)
+		keys = append(keys, "counterch_")
+		processPatch(session, "weight", keys, cfg, func() { session.SetProcessing(false) }, rMsgJSONChan, plcApp, clk)
 	}
 
 }
 
 // CASE 8, HoldFillingWeight; hold the data and wait until weighing scale trigger to collect data to patch.
 func handleHoldFillingWeightCase(session *session.Session, jsonPayloads *utils.SafeJsonPayloads, messages []model.Message,
-	cfg config.AppConfig, rMsgJSONChan <-chan string) {
-
-	for _, channel := range []string{"ch1", "ch2", "ch3"} {
-		// Retrieve NUMBERofSTATE from environment variable and convert to float64
-		NUMBERofSTATEStr := os.Getenv("CASE_6_TRIGGER_NUMBERofSTATE")
-		NUMBERofSTATE, err := strconv.ParseFloat(NUMBERofSTATEStr, 64)
-		if err != nil {
-			fmt.Println("Error parsing NUMBERofSTATE:", err)
-			continue
-		}
-
-		triggerValue, ok := jsonPayloads.GetFloat64(os.Getenv("CASE_6_TRIGGER_" + channel))
-		if ok && triggerValue == NUMBERofSTATE {
-			session.Mutex.Lock()
+	cfg config.AppConfig, rMsgJSONChan <-chan string, plcApp app.PLCWriter, clk clock.Clock) {
 
-			if session.ProcessedPayloadsMap[channel] == nil {
-				session.ProcessedPayloadsMap[channel] = make(map[string]any)
-			}
-			session.ProcessedPayloadsMap[channel][channel+"_fill"] = 1
-			session.Mutex.Unlock()
-			session.IsProcessing = true
-		}
-	}
+	markFillingChannels(session, jsonPayloads, cfg.Channels)
 
 	// Check if all channels are successful and processing is active
-	// Use a flag to track if all channels have success = 0
-	ch1, ok1 := jsonPayloads.GetFloat64(os.Getenv("CASE_6_TRIGGER_ch1"))
-	ch2, ok2 := jsonPayloads.GetFloat64(os.Getenv("CASE_6_TRIGGER_ch2"))
-	ch3, ok3 := jsonPayloads.GetFloat64(os.Getenv("CASE_6_TRIGGER_ch3"))
-	session.AllSuccessZero = ok1 && ok2 && ok3 && ch1 == 0 && ch2 == 0 && ch3 == 0
+	session.SetAllSuccessZero(allChannelsAtZero(jsonPayloads, cfg.Channels))
 
-	if session.AllSuccessZero && session.IsProcessing {
+	if session.AllSuccessZero() && session.IsProcessing() {
 		prevDo := false
-		session.ProcessedPayloadsMap["do"] = utils.ProcessTriggerGeneric(jsonPayloads, messages, func(payload *utils.SafeJsonPayloads) map[string]any {
+		session.SetPayload("do", utils.ProcessTriggerGeneric(jsonPayloads, messages, func(payload *utils.SafeJsonPayloads) map[string]any {
 			prevDo = true
 			return utils.Hold_changeName_generic(payload, "CASE_6_DO_", nil)
-		})
+		}))
 
 		processWeightTriggers(session, jsonPayloads, messages)
 
+		if cfg.EmitMode == config.EmitPerChannel {
+			emitCompletedChannels(session, "holdfillingweight", prevDo, cfg, func(channel string) []string {
+				return []string{channel, "weight" + channel + "_", "do"}
+			}, plcApp, clk)
+			return
+		}
 		if shouldPatch("case8", prevDo, session) {
-			keys := []string{
-				"ch1", "ch2", "ch3", "do", "weightch1_", "weightch2_", "weightch3_",
-			}
-			processPatch(session, keys, cfg, func() { prevDo = false }, rMsgJSONChan, nil)
+			keys := append(channelKeys(cfg.Channels, "", ""), "do")
+			keys = append(keys, channelKeys(cfg.Channels, "weight", "_")...)
+			processPatch(session, "holdfillingweight", keys, cfg, func() { prevDo = false }, rMsgJSONChan, plcApp, clk)
 		}
 
 	}
@@ -250,81 +205,42 @@ func handleHoldFillingWeightCase(session *session.Session, jsonPayloads *utils.S
 
 // CASE 9, HoldMCS; hold the data and wait MCS system trigger to collect data to patch.
 func handleHoldMCSCase(session *session.Session, jsonPayloads *utils.SafeJsonPayloads, messages []model.Message,
-	cfg config.AppConfig, rMsgJSONChan <-chan string) {
-
-	for _, channel := range []string{"ch1", "ch2", "ch3"} {
-		// Retrieve NUMBERofSTATE from environment variable and convert to float64
-		NUMBERofSTATEStr := os.Getenv("CASE_6_TRIGGER_NUMBERofSTATE")
-		NUMBERofSTATE, err := strconv.ParseFloat(NUMBERofSTATEStr, 64)
-		if err != nil {
-			fmt.Println("Error parsing NUMBERofSTATE:", err)
-			continue
-		}
+	cfg config.AppConfig, rMsgJSONChan <-chan string, plcApp app.PLCWriter, clk clock.Clock) {
 
-		triggerValue, ok := jsonPayloads.GetFloat64(os.Getenv("CASE_6_TRIGGER_" + channel))
-		if ok && triggerValue == NUMBERofSTATE {
-			session.Mutex.Lock()
-			if session.ProcessedPayloadsMap[channel] == nil {
-				session.ProcessedPayloadsMap[channel] = make(map[string]any)
-			}
-			session.ProcessedPayloadsMap[channel][channel+"_fill"] = 1
-			session.Mutex.Unlock()
-			session.IsProcessing = true
-		}
-	}
+	markFillingChannels(session, jsonPayloads, cfg.Channels)
 
 	utils.ChangeName(jsonPayloads)
 	utils.ConvertAndStoreModelName(jsonPayloads)
 	utils.StoreFlattenedPayloadToSession(jsonPayloads, session)
 
 	// Check if all channels are successful and processing is active
-	// Use a flag to track if all channels have success = 0
-	ch1, ok1 := jsonPayloads.GetFloat64(os.Getenv("CASE_6_TRIGGER_ch1"))
-	ch2, ok2 := jsonPayloads.GetFloat64(os.Getenv("CASE_6_TRIGGER_ch2"))
-	ch3, ok3 := jsonPayloads.GetFloat64(os.Getenv("CASE_6_TRIGGER_ch3"))
-	session.AllSuccessZero = ok1 && ok2 && ok3 && ch1 == 0 && ch2 == 0 && ch3 == 0
+	session.SetAllSuccessZero(allChannelsAtZero(jsonPayloads, cfg.Channels))
 
-	if session.AllSuccessZero && session.IsProcessing {
+	if session.AllSuccessZero() && session.IsProcessing() {
 		prevDo := false
-		session.ProcessedPayloadsMap["do"] = utils.ProcessTriggerGeneric(jsonPayloads, messages, func(payload *utils.SafeJsonPayloads) map[string]any {
+		session.SetPayload("do", utils.ProcessTriggerGeneric(jsonPayloads, messages, func(payload *utils.SafeJsonPayloads) map[string]any {
 			prevDo = true
 			return utils.Hold_changeName_generic(payload, "CASE_6_DO_", nil)
-		})
+		}))
 
 		processWeightTriggers(session, jsonPayloads, messages)
 
 		if shouldPatch("case8", prevDo, session) {
-			keys := []string{
-				"ch1", "ch2", "ch3", "do", "weightch1_", "weightch2_", "weightch3_", "ink_lot", "model_name", "lower_limit", "standard", "upper_limit",
-			}
-			processPatch(session, keys, cfg, func() { prevDo = false }, rMsgJSONChan, nil)
+			keys := append(channelKeys(cfg.Channels, "", ""), "do")
+			keys = append(keys, channelKeys(cfg.Channels, "weight", "_")...)
+			keys = append(keys, "ink_lot", "model_name", "lower_limit", "standard", "upper_limit")
+			processPatch(session, "holdmcs", keys, cfg, func() { prevDo = false }, rMsgJSONChan, plcApp, clk)
 		}
 
 	}
 
 }
 
-// Helper Function to merges non-empty maps and returns a new map.
-func mergeNonEmptyMaps(maps ...map[string]any) map[string]any {
-	result := make(map[string]any)
-
-	for _, m := range maps {
-		if len(m) > 0 {
-			for key, value := range m {
-				result[key] = value
-			}
-		}
-	}
-
-	return result
-}
-
 // Helper function to compares and updates values in a nested map based on the provided keys.
 // It updates the map if the new value is larger than the existing one; for CASE 7 only
-func compareAndUpdateNestedMap(parentMap map[string]map[string]any, parentKey string,
-	updateData map[string]any, keysToCheck []string, prevWeightValue *float64) {
+func compareAndUpdateNestedMap(nestedMap map[string]any, updateData map[string]any, keysToCheck []string,
+	prevWeightValue *float64) {
 
-	nestedMap := parentMap[parentKey]
 	if nestedMap == nil {
 		return
 	}
@@ -375,26 +291,26 @@ func compareAndUpdateNestedMap(parentMap map[string]map[string]any, parentKey st
 
 // Procees to assigning the common logic to a function and then call that function inside each case
 // Handle the common logic for case string and float64;
-func processAndPrint(session *session.Session, key string, jsonPayloads *utils.SafeJsonPayloads, messages []model.Message, prevWeightValue *float64) {
-	session.Mutex.Lock()
-	defer session.Mutex.Unlock()
+// channel names the weighing channel whose previous weight is tracked, "" for none.
+// Each key is only written by one goroutine at a time, so reading and storing it separately is safe.
+func processAndPrint(session *session.Session, key string, jsonPayloads *utils.SafeJsonPayloads, messages []model.Message, channel string) {
+	old := session.Payload(key)
 
 	processed := utils.ProcessTriggerGeneric(jsonPayloads, messages, func(payload *utils.SafeJsonPayloads) map[string]any {
-		if old, exists := session.ProcessedPayloadsMap[key]; exists {
-			session.Prev = utils.DeepCopyMap(old)
-		}
-
-		updatedMap := utils.Hold_changeName_generic(payload, "HOLD_KEY_TRANSOFRMATION_"+key, session)
+		updatedMap := utils.Hold_changeName_generic(payload, "HOLD_KEY_TRANSOFRMATION_"+key, old)
 
-		keysToCheck := []string{"ch3_weighing", "ch1_weighing", "ch2_weighing"}
-		compareAndUpdateNestedMap(session.ProcessedPayloadsMap, key, updatedMap, keysToCheck, prevWeightValue)
+		if channel != "" {
+			prevWeightValue := session.Channel(channel).PrevWeightValue
+			keysToCheck := channelKeys(session.Channels(), "", "_weighing")
+			compareAndUpdateNestedMap(old, updatedMap, keysToCheck, &prevWeightValue)
+			session.UpdateChannel(channel, func(state *channelState) { state.PrevWeightValue = prevWeightValue })
+		}
 
 		return updatedMap
 	})
 
-	//fmt.Println(session.ProcessedPayloadsMap)
 	if processed != nil {
-		session.ProcessedPayloadsMap[key] = processed
+		session.SetPayload(key, processed)
 	}
 }
 
@@ -405,17 +321,17 @@ func processChannelTrigger(triggerEnvVar, prefix string, jsonPayloads *utils.Saf
 
 	TRIGGER, ok := jsonPayloads.Get(os.Getenv(triggerEnvVar))
 	if !ok {
-		fmt.Printf("Trigger key %s not found", os.Getenv(triggerEnvVar))
+		slog.Debug("Trigger not in batch", "env", triggerEnvVar, "address", os.Getenv(triggerEnvVar))
 		return
 	}
 	switch v := TRIGGER.(type) {
 	case string:
 		if v == "1" {
-			processAndPrint(session, prefix, jsonPayloads, messages, nil)
+			processAndPrint(session, prefix, jsonPayloads, messages, "")
 		}
 	case float64:
 		if v == 1 {
-			processAndPrint(session, prefix, jsonPayloads, messages, nil)
+			processAndPrint(session, prefix, jsonPayloads, messages, "")
 		}
 	}
 }
@@ -425,26 +341,24 @@ func processChannelTrigger(triggerEnvVar, prefix string, jsonPayloads *utils.Saf
 // Handle the common logic for case if not nil;
 // for CASE 4 & CASE 7.
 func processAndPrintforVacuum(key string, jsonPayloads *utils.SafeJsonPayloads, messages []model.Message, session *session.Session) {
-	session.ProcessedPayloadsMap[key] = utils.ProcessTriggerGeneric(jsonPayloads, messages,
+	prev := session.Payload(key)
+	session.SetPayload(key, utils.ProcessTriggerGeneric(jsonPayloads, messages,
 		func(payload *utils.SafeJsonPayloads) map[string]any {
-			session.Prev = session.ProcessedPayloadsMap[key]
-			return utils.Hold_changeName_generic(payload, "CASE_4_VACUUM_", session)
-		})
+			return utils.Hold_changeName_generic(payload, "CASE_4_VACUUM_", prev)
+		}))
 }
 
-// Process for weight triggers (CH1, CH2, CH3); for CASE 7 & CASE 8
+// Process for weight triggers of every channel; for CASE 7 & CASE 8
 func processWeightTriggers(session *session.Session, jsonPayloads *utils.SafeJsonPayloads, messages []model.Message) {
 	var wg sync.WaitGroup
 
 	// A helper function to process each weight trigger concurrently
-	processWeightTrigger := func(channel string, triggerKey string, weightTrigger *bool,
-		prevWeightTrigger *bool, prevWeightValue *float64) {
-
+	processWeightTrigger := func(channel string, triggerKey string) {
 		defer wg.Done()
 
 		triggerValue, ok := jsonPayloads.GetDC(os.Getenv(triggerKey))
 		if !ok {
-			fmt.Printf("Trigger key %s not found\n", os.Getenv(triggerKey))
+			slog.Debug("Trigger not in batch", "channel", channel, "env", triggerKey, "address", os.Getenv(triggerKey))
 			return
 		}
 
@@ -455,27 +369,76 @@ func processWeightTriggers(session *session.Session, jsonPayloads *utils.SafeJso
 		case float64:
 			isTriggered = (v == 1)
 		default:
-			fmt.Printf("Unexpected type for trigger value: %T\n", v)
+			slog.Warn("Unexpected trigger value type", "channel", channel, "address", os.Getenv(triggerKey), "type", fmt.Sprintf("%T", v))
 			return
 		}
 
 		if isTriggered {
-			processAndPrint(session, channel, jsonPayloads, messages, prevWeightValue)
-			*weightTrigger = true
-			*prevWeightTrigger = true
-		} else {
-			*weightTrigger = false
+			processAndPrint(session, "weight"+channel+"_", jsonPayloads, messages, channel)
 		}
+		session.UpdateChannel(channel, func(state *channelState) {
+			state.WeightTrigger = isTriggered
+			if isTriggered {
+				state.PrevWeightTrigger = true
+			}
+		})
 	}
 
-	// Add three goroutines to the WaitGroup
-	wg.Add(3)
-
 	// Run each trigger processing in its own goroutine
-	go processWeightTrigger("weightch1_", "CASE_7_TRIGGER_WEIGHING_CH1", &session.WeightTriggerCh1, &session.PrevWeightTriggerCh1, session.PrevWeightValueCh1)
-	go processWeightTrigger("weightch2_", "CASE_7_TRIGGER_WEIGHING_CH2", &session.WeightTriggerCh2, &session.PrevWeightTriggerCh2, session.PrevWeightValueCh2)
-	go processWeightTrigger("weightch3_", "CASE_7_TRIGGER_WEIGHING_CH3", &session.WeightTriggerCh3, &session.PrevWeightTriggerCh3, session.PrevWeightValueCh3)
+	for _, channel := range session.Channels() {
+		wg.Add(1)
+		go processWeightTrigger(channel, channelEnv("CASE_7_TRIGGER_WEIGHING_", channel))
+	}
 
 	// Wait for all goroutines to finish
 	wg.Wait()
 }
+
+// Helper function to mark a channel as filled when its trigger reaches
+// CASE_6_TRIGGER_NUMBERofSTATE; for CASE 6, CASE 8 & CASE 9
+func markFillingChannels(session *session.Session, jsonPayloads *utils.SafeJsonPayloads, channels []string) {
+	// Retrieve NUMBERofSTATE from environment variable and convert to float64
+	NUMBERofSTATEStr := os.Getenv("CASE_6_TRIGGER_NUMBERofSTATE")
+	NUMBERofSTATE, err := strconv.ParseFloat(NUMBERofSTATEStr, 64)
+	if err != nil {
+		slog.Error("Invalid CASE_6_TRIGGER_NUMBERofSTATE", "value", NUMBERofSTATEStr, "err", err)
+		return
+	}
+
+	for _, channel := range channels {
+		triggerValue, ok := jsonPayloads.GetFloat64(os.Getenv("CASE_6_TRIGGER_" + channel))
+		if ok && triggerValue == NUMBERofSTATE {
+			session.SetField(channel, channel+"_fill", 1)
+			session.SetProcessing(true)
+		}
+	}
+}
+
+// Helper function to check that every channel trigger reports success (= 0);
+// for CASE 6, CASE 8 & CASE 9
+func allChannelsAtZero(jsonPayloads *utils.SafeJsonPayloads, channels []string) bool {
+	if len(channels) == 0 {
+		return false
+	}
+	for _, channel := range channels {
+		value, ok := jsonPayloads.GetFloat64(os.Getenv("CASE_6_TRIGGER_" + channel))
+		if !ok || value != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Helper function to build the session map keys of every channel, e.g. "weight" + ch1 + "_"
+func channelKeys(channels []string, prefix, suffix string) []string {
+	keys := make([]string, 0, len(channels))
+	for _, channel := range channels {
+		keys = append(keys, prefix+channel+suffix)
+	}
+	return keys
+}
+
+// Helper function to build the env var name of a channel trigger, e.g. CASE_4_TRIGGER_CH1
+func channelEnv(prefix, channel string) string {
+	return prefix + strings.ToUpper(channel)
+}
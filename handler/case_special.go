@@ -1,47 +1,47 @@
 package handler
 
 import (
-	"encoding/json"
-	"fmt"
 	"gopatch/config"
+	"gopatch/internal/app"
+	"gopatch/internal/clock"
 	"gopatch/internal/session"
 	"gopatch/internal/utils"
 	"gopatch/model"
-	"gopatch/patch"
+	"log/slog"
 	"time"
 )
 
 // CASE 5, Special; handling a device's highest value and average value and patch it, when the trigger is 1
 func handleSpecialCase(session *session.Session, tk utils.TriggerKey, jsonPayloads *utils.SafeJsonPayloads, messages []model.Message,
-	cfg config.AppConfig) {
+	cfg config.AppConfig, plcApp app.PLCWriter, clk clock.Clock) {
 	// Assuming these variables need to be declared and initialized
 	var startTime time.Time
 
 	if trigger, ok := jsonPayloads.GetFloat64(tk.TriggerKey); ok {
 
 		if trigger == 1 {
-			session.IsProcessing = true
-			// Assuming processedPayloadsMap is a map[string]map[string]interface{}
-			if session.ProcessedPayloadsMap["degas"]["pica1"] == nil {
-				session.ProcessedPayloadsMap["degas"]["pica1"] = make([]float64, 0)
-			}
+			session.SetProcessing(true)
+			pica1Values, _ := session.Payload("degas")["pica1"].([]float64)
 
-			result := ProcessTriggerGenericSpecial(jsonPayloads, messages, trigger, func(payload *utils.SafeJsonPayloads) map[string]interface{} {
+			result := ProcessTriggerGenericSpecial(jsonPayloads, messages, trigger, clk, func(payload *utils.SafeJsonPayloads) map[string]interface{} {
 				return utils.Hold_changeName_generic(payload, "CASE_5_DEGAS_", nil)
 			})
 
 			// Assuming pica1 is a float64 value in the result map
 			if pica1, ok := result["pica1"].(float64); ok {
-				session.ProcessedPayloadsMap["degas"]["pica1"] = append(session.ProcessedPayloadsMap["degas"]["pica1"].([]float64), pica1)
+				// Copy the samples, the stored slice stays untouched until it is replaced
+				session.SetField("degas", "pica1", append(append([]float64(nil), pica1Values...), pica1))
 			}
-
-			//fmt.Println(session.ProcessedPayloadsMap["degas"]["pica1"])
 		}
 
-		if trigger == 0 && session.IsProcessing {
-			session.IsProcessing = false
+		if trigger == 0 && session.IsProcessing() {
+			session.SetProcessing(false)
 
-			pica1Values, ok := session.ProcessedPayloadsMap["degas"]["pica1"].([]float64)
+			degas := session.Payload("degas")
+			if degas == nil {
+				degas = make(map[string]interface{})
+			}
+			pica1Values, ok := degas["pica1"].([]float64)
 
 			if ok && len(pica1Values) > 0 { // Check if there are values in the slice
 
@@ -52,7 +52,7 @@ func handleSpecialCase(session *session.Session, tk utils.TriggerKey, jsonPayloa
 						max = value
 					}
 				}
-				session.ProcessedPayloadsMap["degas"]["pica1_max"] = max
+				degas["pica1_max"] = max
 
 				// Calculate average
 				var sum float64
@@ -60,30 +60,28 @@ func handleSpecialCase(session *session.Session, tk utils.TriggerKey, jsonPayloa
 					sum += value
 				}
 				average := sum / float64(len(pica1Values))
-				session.ProcessedPayloadsMap["degas"]["pica1_average"] = average
+				degas["pica1_average"] = average
 			} else {
 				// Handle the case where there are no values in the pica1Values slice
-				fmt.Println("No values found for pica1.")
+				slog.Warn("No pica1 samples collected", "case", "special")
 			}
 
 			// Clear degas values
-			delete(session.ProcessedPayloadsMap["degas"], "pica1")
-
-			// Convert session.ProcessedPayloadsMap["degas"] to JSON, patch to API, print, etc.
-			jsonData, err := json.Marshal(session.ProcessedPayloadsMap["degas"])
-			if err != nil {
-				fmt.Println("Error marshaling JSON:", err)
+			delete(degas, "pica1")
+
+			// Convert degas to JSON, patch to API, print, etc.
+			ctx := stampSessionCycle(session, "special", degas, cfg, clk)
+			if err := writeRecord(ctx, "special", degas, recordTarget{}, cfg, plcApp, clk); err != nil {
+				slog.ErrorContext(ctx, "Failed to send record", "case", "special", "err", err)
+				session.ClearPayloads("degas")
+				endCycle(session, "special", cycleFailed)
 				return
 			}
 
-			_, err = patch.SendPatchRequest(cfg.APIUrl, cfg.ServiceRoleKey, jsonData, cfg.Function)
-			if err != nil {
-				panic(err)
-			}
-
-			elapsedTime := time.Since(startTime)
-			prettyPrintJSONWithTime(session.ProcessedPayloadsMap["degas"], elapsedTime)
-			session.ProcessedPayloadsMap["degas"] = make(map[string]interface{})
+			elapsedTime := clk.Since(startTime)
+			logRecord(ctx, "special", degas, elapsedTime)
+			session.ClearPayloads("degas")
+			endCycle(session, "special", cycleCompleted)
 		}
 	}
 }
@@ -95,10 +93,10 @@ func handleSpecialCase(session *session.Session, tk utils.TriggerKey, jsonPayloa
 // and return the corresponding processed payload
 // Using for Case Special
 func ProcessTriggerGenericSpecial(jsonPayloads *utils.SafeJsonPayloads, messages []model.Message,
-	loop float64, changeNameFunc func(*utils.SafeJsonPayloads) map[string]interface{}) map[string]interface{} {
+	loop float64, clk clock.Clock, changeNameFunc func(*utils.SafeJsonPayloads) map[string]interface{}) map[string]interface{} {
 
-	startTime := time.Now()
-	processMessagesLoop(jsonPayloads, messages, startTime, 1)
+	startTime := clk.Now()
+	processMessagesLoop(jsonPayloads, messages, startTime, 1, clk)
 	processedPayload := changeNameFunc(jsonPayloads)
 
 	return processedPayload
@@ -1,38 +1,88 @@
 package handler
 
 import (
-	"encoding/json"
-	"fmt"
 	"gopatch/config"
+	"gopatch/internal/app"
+	"gopatch/internal/clock"
 	"gopatch/internal/utils"
 	"gopatch/model"
-	"gopatch/patch"
+	"log/slog"
+	"os"
 	"strings"
+	"sync"
 	"time"
 )
 
 var processPrevTriggerKeyMap = make(map[string]string)
 
-// Stopwatch to count the device duration in Case 1.
-var deviceStartTimeMap = make(map[string]time.Time)
+// durationState is the stopwatch of a single device in Case 1.
+type durationState struct {
+	prevStart bool      // Last seen level of the start device
+	prevStop  bool      // Last seen level of the stop device
+	running   bool      // Start edge seen, waiting for the stop edge
+	startTime time.Time // Time of the start edge
+}
 
-// CASE 1, time.Duration; handling the process of time taken from 0 to 1, and record the total time duration
-func handleTimeDurationCase(tk utils.TriggerKey, jsonPayloads *utils.SafeJsonPayloads, messages []model.Message,
-	loop float64) {
+// Stopwatch to count the device duration in Case 1, one per trigger device.
+var (
+	deviceDurationMap   = make(map[string]*durationState)
+	deviceDurationMutex sync.Mutex
+)
 
-	processKey := generateProcessKey(tk.TriggerKey)
+// CASE 1, time.Duration; measure the time between the start edge and the stop edge of the trigger,
+// and patch the record with <name>_duration_ms.
+//
+// CASE_1_START_EDGE / CASE_1_STOP_EDGE select "rising" (0 -> 1) or "falling" (1 -> 0), default rising / falling.
+// CASE_1_STOP_DEVICE_<trigger> ends the measurement on another device, default the trigger itself.
+// CASE_1_NAME_<trigger> names the duration field, default the trigger device.
+func handleTimeDurationCase(tk utils.TriggerKey, jsonPayloads *utils.SafeJsonPayloads, cfg config.AppConfig, plcApp app.PLCWriter, clk clock.Clock) {
+	startLevel, startOk := jsonPayloads.GetBool(tk.TriggerKey)
+	stopDevice := getEnvOr("CASE_1_STOP_DEVICE_"+tk.TriggerKey, tk.TriggerKey)
+	stopLevel, stopOk := jsonPayloads.GetBool(stopDevice)
+
+	startEdge := getEnvOr("CASE_1_START_EDGE", "rising")
+	stopEdge := getEnvOr("CASE_1_STOP_EDGE", "falling")
+
+	deviceDurationMutex.Lock()
+	state, exists := deviceDurationMap[tk.TriggerKey]
+	if !exists {
+		// First sample only records the current level, an edge needs a previous value
+		state = &durationState{prevStart: startLevel, prevStop: stopLevel}
+		deviceDurationMap[tk.TriggerKey] = state
+		deviceDurationMutex.Unlock()
+		return
+	}
 
-	if tk.TriggerKey != processPrevTriggerKeyMap[processKey] {
-		processPrevTriggerKeyMap[processKey] = tk.TriggerKey
+	now := clk.Now()
+	var elapsed time.Duration
+	stopped := false
 
-		if trigger, ok := jsonPayloads.GetFloat64(tk.TriggerKey); ok && trigger != 0 {
-			handleTimeDurationTrigger(tk, jsonPayloads, messages, loop)
+	// Check the stop edge first, so a device using the same edge for start and stop measures its period
+	if stopOk {
+		if state.running && isEdge(stopEdge, state.prevStop, stopLevel) {
+			elapsed = now.Sub(state.startTime)
+			state.running = false
+			stopped = true
+		}
+		state.prevStop = stopLevel
+	}
+	if startOk {
+		if !state.running && isEdge(startEdge, state.prevStart, startLevel) {
+			state.startTime = now
+			state.running = true
 		}
+		state.prevStart = startLevel
+	}
+	deviceDurationMutex.Unlock()
+
+	if stopped {
+		handleTimeDurationTrigger(tk, jsonPayloads, elapsed, cfg, plcApp, clk)
 	}
 }
 
 // CASE 2, Standard; handling a devices value and patch it, when the trigger is different with previous key
-func handleStandardCase(tk utils.TriggerKey, jsonPayloads *utils.SafeJsonPayloads, messages []model.Message, cfg config.AppConfig) {
+func handleStandardCase(tk utils.TriggerKey, jsonPayloads *utils.SafeJsonPayloads, messages []model.Message, cfg config.AppConfig,
+	plcApp app.PLCWriter, clk clock.Clock) {
 
 	processKey := generateProcessKey(tk.TriggerKey)
 
@@ -40,54 +90,62 @@ func handleStandardCase(tk utils.TriggerKey, jsonPayloads *utils.SafeJsonPayload
 		processPrevTriggerKeyMap[processKey] = tk.TriggerKey
 
 		if trigger, ok := jsonPayloads.GetFloat64(tk.TriggerKey); ok && trigger != 0 {
-			var startTime time.Time
-			processMessagesLoop(jsonPayloads, messages, startTime, cfg.Loop)
+			startTime := clk.Now()
+			// A zero start time makes the loop a single pass
+			processMessagesLoop(jsonPayloads, messages, time.Time{}, cfg.Loop, clk)
 
 			utils.CalculateAndStoreInklot(jsonPayloads)
 			utils.ChangeName(jsonPayloads)
 
 			if trigger, ok := jsonPayloads.GetFloat64(tk.TriggerKey); ok && trigger == 0 {
-				fmt.Println("Case 1")
-				fmt.Println(jsonPayloads)
+				ctx := stampPayloadCycle(jsonPayloads, startTime, cfg, clk)
 
-				jsonData, err := json.Marshal(jsonPayloads)
-				if err != nil {
-					fmt.Println("Error marshaling JSON:", err)
+				if err := writeRecord(ctx, tk.CaseKey, jsonPayloads.GetData(), recordTarget{}, cfg, plcApp, clk); err != nil {
+					slog.ErrorContext(ctx, "Failed to send record", "case", tk.CaseKey, "err", err)
 					return
 				}
 
-				_, err = patch.SendPatchRequest(cfg.APIUrl, cfg.ServiceRoleKey, jsonData, cfg.Function)
-				if err != nil {
-					panic(err)
-				}
-
-				elapsedTime := time.Since(startTime)
-				prettyPrintJSONWithTime(jsonPayloads, elapsedTime)
+				elapsedTime := clk.Since(startTime)
+				logRecord(ctx, tk.CaseKey, jsonPayloads, elapsedTime)
 			}
 		}
 	}
 }
 
-// Process to check the time taken from 0 to 1; or CASE 1
-func handleTimeDurationTrigger(tk utils.TriggerKey, jsonPayloads *utils.SafeJsonPayloads, messages []model.Message, loop float64,
-) {
-	if val, ok := jsonPayloads.Get(tk.TriggerKey); ok {
-		fmt.Printf("Device name: %s, Payload: %v\n", tk.TriggerKey, val)
-	} else {
-		fmt.Printf("Device name: %s, Payload: <no data>\n", tk.TriggerKey)
+// Process to patch the measured duration of the trigger; for CASE 1
+func handleTimeDurationTrigger(tk utils.TriggerKey, jsonPayloads *utils.SafeJsonPayloads, elapsed time.Duration,
+	cfg config.AppConfig, plcApp app.PLCWriter, clk clock.Clock) {
+
+	name := getEnvOr("CASE_1_NAME_"+tk.TriggerKey, tk.TriggerKey)
+
+	utils.CalculateAndStoreInklot(jsonPayloads)
+	utils.ChangeName(jsonPayloads)
+	jsonPayloads.Set(name+"_duration_ms", elapsed.Milliseconds())
+	startTime := clk.Now()
+	ctx := stampPayloadCycle(jsonPayloads, startTime.Add(-elapsed), cfg, clk)
+
+	if err := writeRecord(ctx, tk.CaseKey, jsonPayloads.GetData(), recordTarget{}, cfg, plcApp, clk); err != nil {
+		slog.ErrorContext(ctx, "Failed to send record", "case", tk.CaseKey, "err", err)
+		return
 	}
 
-	if startTime, exists := deviceStartTimeMap[tk.TriggerKey]; !exists {
-		deviceStartTimeMap[tk.TriggerKey] = time.Now()
-	} else {
-		if trigger, ok := jsonPayloads.GetFloat64(tk.TriggerKey); ok && trigger == 0 {
-			utils.CalculateAndStoreInklot(jsonPayloads)
-			utils.ChangeName(jsonPayloads)
-			processMessagesLoop(jsonPayloads, messages, startTime, loop)
-		}
+	logRecord(ctx, tk.CaseKey, jsonPayloads, clk.Since(startTime))
+}
+
+// isEdge reports whether the level change from prev to current matches the edge ("rising" or "falling").
+func isEdge(edge string, prev, current bool) bool {
+	if strings.EqualFold(edge, "falling") {
+		return prev && !current
+	}
+	return !prev && current
+}
 
-		deviceStartTimeMap[tk.TriggerKey] = time.Now()
+// getEnvOr returns the environment variable or the fallback when it is unset.
+func getEnvOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
 	}
+	return fallback
 }
 
 // generateProcessKey creates a unique key for each process based on relevant parameters.
@@ -99,7 +157,7 @@ func generateProcessKey(triggerKey string) string {
 // processMessagesLoop receives messages within a specified time and updates a JSON payload map.
 // If a key is repeated, it overwrites the existing value.
 func processMessagesLoop(jsonPayloads *utils.SafeJsonPayloads, messages []model.Message,
-	startTime time.Time, loop float64) {
+	startTime time.Time, loop float64, clk clock.Clock) {
 
 	for {
 		for _, message := range messages {
@@ -107,9 +165,9 @@ func processMessagesLoop(jsonPayloads *utils.SafeJsonPayloads, messages []model.
 			fieldValue := message.Value
 			jsonPayloads.Set(fieldNameLower, fieldValue)
 		}
-		time.Sleep(time.Second)
+		clk.Sleep(time.Second)
 
-		if time.Since(startTime).Seconds() >= loop {
+		if clk.Since(startTime).Seconds() >= loop {
 			break
 		}
 	}
@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"gopatch/config"
+	"gopatch/internal/clock"
+	"gopatch/internal/utils"
+)
+
+func TestHandleTimeDurationCase(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		records []map[string]any
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var record map[string]any
+		if err := json.Unmarshal(body, &record); err != nil {
+			t.Errorf("Invalid JSON body: %v", err)
+		}
+		mu.Lock()
+		records = append(records, record)
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	t.Setenv("CASE_1_NAME_m100", "press")
+	cfg := config.AppConfig{APIUrl: server.URL, Function: "POST"}
+	clk := clock.NewFake(time.Date(2025, 1, 1, 8, 0, 0, 0, time.UTC))
+	press := utils.TriggerKey{TriggerKey: "m100", CaseKey: "time.duration"}
+	oven := utils.TriggerKey{TriggerKey: "m200", CaseKey: "time.duration"}
+
+	batch := func(m100, m200 float64) {
+		payloads := utils.NewSafeJsonPayloads()
+		payloads.Set("m100", m100)
+		payloads.Set("m200", m200)
+		handleTimeDurationCase(press, payloads, cfg, nil, clk)
+		handleTimeDurationCase(oven, payloads, cfg, nil, clk)
+	}
+
+	batch(0, 0)
+	batch(1, 0) // press starts
+	clk.Advance(20 * time.Millisecond)
+	batch(1, 1) // oven starts
+	batch(0, 1) // press stops
+	clk.Advance(30 * time.Millisecond)
+	batch(0, 0) // oven stops
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 records, got %d: %v", len(records), records)
+	}
+	if records[0]["press_duration_ms"] != float64(20) {
+		t.Errorf("Expected press_duration_ms 20, got %v", records[0])
+	}
+	if records[1]["m200_duration_ms"] != float64(30) {
+		t.Errorf("Expected m200_duration_ms 30, got %v", records[1])
+	}
+}
+
+func TestIsEdge(t *testing.T) {
+	if !isEdge("rising", false, true) || isEdge("rising", true, false) {
+		t.Error("Unexpected rising edge detection")
+	}
+	if !isEdge("falling", true, false) || isEdge("falling", false, true) {
+		t.Error("Unexpected falling edge detection")
+	}
+}
@@ -0,0 +1,81 @@
+package handler
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"gopatch/config"
+	"gopatch/internal/clock"
+	"gopatch/internal/session"
+	"gopatch/internal/utils"
+)
+
+var (
+	batchMu        sync.Mutex                    // Held while a batch runs through the handlers, so control actions never interleave with it
+	paused         atomic.Bool                   // Skip the handlers, batches only update latestPayloads
+	latestPayloads = utils.NewSafeJsonPayloads() // Last value of every address seen, never cleared
+)
+
+// Pause stops running batches through the handlers, the sessions keep their state
+func Pause() { paused.Store(true) }
+
+// Resume runs batches through the handlers again
+func Resume() { paused.Store(false) }
+
+// Paused reports whether processing is paused
+func Paused() bool { return paused.Load() }
+
+// BetweenBatches runs fn while no batch runs through the handlers, e.g. to snapshot the sessions
+func BetweenBatches(fn func()) {
+	batchMu.Lock()
+	defer batchMu.Unlock()
+	fn()
+}
+
+// LatestPayloads returns the last value of every address seen since start
+func LatestPayloads() map[string]any {
+	return latestPayloads.Data()
+}
+
+// ForceEmit sends whatever the session of caseKey collected so far with status "forced"
+// to the sinks of its case, then resets the session. Reports whether a record was sent.
+func ForceEmit(caseKey string, cfg config.AppConfig, clk clock.Clock) (bool, error) {
+	batchMu.Lock()
+	defer batchMu.Unlock()
+
+	s, ok := session.GetSession(caseKey)
+	if !ok {
+		return false, fmt.Errorf("no session %q", caseKey)
+	}
+	label := sessionCase(caseKey)
+	sent, err := sendPartialRecord(s, label, cycleForced, recordTarget{}, cfg, clk)
+	resetSession(s, label, cycleForced)
+	return sent, err
+}
+
+// ResetSession drops everything the session of caseKey collected, without sending it
+func ResetSession(caseKey string) error {
+	batchMu.Lock()
+	defer batchMu.Unlock()
+
+	s, ok := session.GetSession(caseKey)
+	if !ok {
+		return fmt.Errorf("no session %q", caseKey)
+	}
+	resetSession(s, sessionCase(caseKey), cycleReset)
+	return nil
+}
+
+// sessionCase returns the hold case of a session key (BASH_API + "_" + TRIGGER_DEVICE),
+// e.g. "holdfillingweight" for "PATCH_d800,holdfillingweight"
+func sessionCase(caseKey string) string {
+	_, trigger, _ := strings.Cut(caseKey, "_")
+	for _, tk := range utils.ParseTriggerKey(trigger) {
+		if cycleCases[tk.CaseKey] {
+			return tk.CaseKey
+		}
+	}
+	return caseKey
+}
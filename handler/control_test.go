@@ -0,0 +1,31 @@
+package handler
+
+import (
+	"testing"
+
+	"gopatch/config"
+	"gopatch/internal/clock"
+	"gopatch/internal/session"
+)
+
+func TestPausedBatchOnlyUpdatesLatest(t *testing.T) {
+	cfg := config.AppConfig{Function: "PATCH", Trigger: "d800,holdfilling", Channels: []string{"ch1"}}
+	caseKey := cfg.Function + "_" + cfg.Trigger
+	t.Cleanup(func() {
+		Resume()
+		session.ClearSession(caseKey)
+	})
+
+	Pause()
+	ch := make(chan string, 1)
+	ch <- `[{"address":"D800","value":1}]`
+	ProcessMQTTData(cfg, ch, nil, clock.Real)
+
+	if LatestPayloads()["d800"] != float64(1) {
+		t.Errorf("Expected the latest value of d800, got %v", LatestPayloads())
+	}
+	s, _ := session.GetSession(caseKey)
+	if s.IsProcessing() {
+		t.Error("Expected the paused batch to skip the handlers")
+	}
+}
@@ -0,0 +1,108 @@
+package handler
+
+import (
+	"context"
+	"gopatch/config"
+	"gopatch/internal/clock"
+	"gopatch/internal/cycle"
+	"gopatch/internal/metrics"
+	"gopatch/internal/session"
+	"gopatch/internal/utils"
+	"sync/atomic"
+	"time"
+)
+
+// baseContext is the parent of every cycle context; cancelling it aborts the in-flight requests, e.g. on shutdown
+var baseContext atomic.Pointer[context.Context]
+
+// SetContext makes ctx the parent of the contexts the records are written with
+func SetContext(ctx context.Context) {
+	baseContext.Store(&ctx)
+}
+
+func parentContext() context.Context {
+	if ctx := baseContext.Load(); ctx != nil {
+		return *ctx
+	}
+	return context.Background()
+}
+
+// Outcomes of a cycle; every one but cycleCompleted is the reason label of gopatch_cycles_aborted_total
+const (
+	cycleCompleted = "completed" // Emitted as a record
+	cycleInvalid   = "invalid"   // Record dropped by the completeness rules
+	cycleFailed    = "failed"    // Record could not be written, after the last retry or a permanent error
+	cycleTimeout   = "timeout"   // Abandoned after CYCLE_TIMEOUT
+	cycleForced    = "forced"    // Force-emitted from the admin API
+	cycleReset     = "reset"     // Reset from the admin API
+	cycleIdle      = "idle"      // Session went idle without emitting
+)
+
+// trackCycle gives the session a cycle ID and start time once a cycle of a hold case
+// becomes active, and clears both when it is idle again. Reports whether a cycle is active.
+func trackCycle(session *session.Session, caseKey string, clk clock.Clock) bool {
+	if !cycleCases[caseKey] {
+		return false
+	}
+
+	if !cycleActive(session) {
+		endCycle(session, caseKey, cycleIdle)
+		return false
+	}
+
+	startCycle(session, caseKey, clk)
+	return true
+}
+
+// startCycle returns the current cycle of the session, starting it if needed
+func startCycle(session *session.Session, caseKey string, clk clock.Clock) (string, time.Time) {
+	if _, startedAt := session.Cycle(); startedAt.IsZero() {
+		metrics.CyclesStarted.WithLabelValues(caseKey).Inc()
+	}
+	return session.StartCycle(cycle.NewID, clk.Now())
+}
+
+// endCycle forgets the cycle ID and start time once the cycle was emitted or abandoned
+func endCycle(session *session.Session, caseKey, outcome string) {
+	if _, startedAt := session.Cycle(); !startedAt.IsZero() {
+		if outcome == cycleCompleted {
+			metrics.CyclesCompleted.WithLabelValues(caseKey).Inc()
+		} else {
+			metrics.CyclesAborted.WithLabelValues(caseKey, outcome).Inc()
+		}
+	}
+	session.SetCycle("", time.Time{})
+}
+
+// stampCycle adds the cycle ID and the start and end time of the cycle to the record.
+// Returns the context carrying the cycle ID for the requests, PLC writes and logs of the record.
+func stampCycle(data map[string]any, id string, start, end time.Time, cfg config.AppConfig) context.Context {
+	if cfg.CycleIDField != "" {
+		data[cfg.CycleIDField] = id
+	}
+	if cfg.CycleStartField != "" {
+		data[cfg.CycleStartField] = start.UTC().Format(time.RFC3339Nano)
+	}
+	if cfg.CycleEndField != "" {
+		data[cfg.CycleEndField] = end.UTC().Format(time.RFC3339Nano)
+	}
+	return cycle.WithID(parentContext(), id)
+}
+
+// stampSessionCycle stamps the record with the current cycle of the session,
+// a cycle that started and ended within one batch gets its ID here.
+func stampSessionCycle(session *session.Session, caseKey string, data map[string]any, cfg config.AppConfig, clk clock.Clock) context.Context {
+	id, startedAt := startCycle(session, caseKey, clk)
+	return stampCycle(data, id, startedAt, clk.Now(), cfg)
+}
+
+// stampPayloadCycle stamps the record of a sessionless case (trigger, standard, time.duration),
+// each of its records is a cycle of its own.
+func stampPayloadCycle(jsonPayloads *utils.SafeJsonPayloads, start time.Time, cfg config.AppConfig, clk clock.Clock) context.Context {
+	fields := map[string]any{}
+	ctx := stampCycle(fields, cycle.NewID(), start, clk.Now(), cfg)
+	for key, value := range fields {
+		jsonPayloads.Set(key, value)
+	}
+	return ctx
+}
@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"testing"
+	"time"
+
+	"gopatch/config"
+	"gopatch/internal/clock"
+	"gopatch/internal/cycle"
+	"gopatch/internal/metrics"
+	"gopatch/internal/session"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestTrackCycle(t *testing.T) {
+	start := time.Date(2025, 1, 1, 8, 0, 0, 0, time.UTC)
+	clk := clock.NewFake(start)
+	s := session.NewSession([]string{"ch1"})
+
+	if id, _ := s.Cycle(); trackCycle(s, "holdfilling", clk) || id != "" {
+		t.Fatal("Expected an idle session to have no cycle")
+	}
+
+	s.SetProcessing(true)
+	if !trackCycle(s, "holdfilling", clk) {
+		t.Fatal("Expected the active session to get a cycle")
+	}
+	id, _ := s.Cycle()
+	if id == "" {
+		t.Fatal("Expected the active session to get a cycle ID")
+	}
+
+	clk.Advance(time.Second)
+	trackCycle(s, "holdfilling", clk)
+	if gotID, startedAt := s.Cycle(); gotID != id || !startedAt.Equal(start) {
+		t.Errorf("Expected the cycle to be kept across batches, got %s from %s", gotID, startedAt)
+	}
+
+	cfg := config.AppConfig{CycleIDField: "cycle_id", CycleStartField: "cycle_started_at", CycleEndField: "cycle_ended_at"}
+	data := map[string]any{}
+	ctx := stampSessionCycle(s, "holdfilling", data, cfg, clk)
+	if cycle.IDFrom(ctx) != id || data["cycle_id"] != id {
+		t.Errorf("Expected record and context to carry %s, got %v", id, data)
+	}
+	if data["cycle_started_at"] != "2025-01-01T08:00:00Z" || data["cycle_ended_at"] != "2025-01-01T08:00:01Z" {
+		t.Errorf("Unexpected cycle timestamps %v", data)
+	}
+
+	s.SetProcessing(false)
+	active := trackCycle(s, "holdfilling", clk)
+	if gotID, startedAt := s.Cycle(); active || gotID != "" || !startedAt.IsZero() {
+		t.Errorf("Expected the cycle to end once the session is idle, got %s", gotID)
+	}
+}
+
+func TestStampCycleOptionalFields(t *testing.T) {
+	clk := clock.NewFake(time.Date(2025, 1, 1, 8, 0, 0, 0, time.UTC))
+	data := map[string]any{}
+	stampCycle(data, cycle.NewID(), clk.Now(), clk.Now(), config.AppConfig{CycleIDField: "cycle_id"})
+	if len(data) != 1 {
+		t.Errorf("Expected only the cycle ID without start and end fields, got %v", data)
+	}
+}
+
+func TestCycleMetrics(t *testing.T) {
+	clk := clock.NewFake(time.Date(2025, 1, 1, 8, 0, 0, 0, time.UTC))
+	started := testutil.ToFloat64(metrics.CyclesStarted.WithLabelValues("holdmcs"))
+	completed := testutil.ToFloat64(metrics.CyclesCompleted.WithLabelValues("holdmcs"))
+	idle := testutil.ToFloat64(metrics.CyclesAborted.WithLabelValues("holdmcs", cycleIdle))
+
+	s := session.NewSession([]string{"ch1"})
+	s.SetProcessing(true)
+	trackCycle(s, "holdmcs", clk)
+	trackCycle(s, "holdmcs", clk)
+	endCycle(s, "holdmcs", cycleCompleted)
+
+	// A cycle that never emitted is aborted once the session goes idle
+	trackCycle(s, "holdmcs", clk)
+	s.SetProcessing(false)
+	trackCycle(s, "holdmcs", clk)
+	trackCycle(s, "holdmcs", clk)
+
+	if got := testutil.ToFloat64(metrics.CyclesStarted.WithLabelValues("holdmcs")) - started; got != 2 {
+		t.Errorf("Expected 2 started cycles, got %v", got)
+	}
+	if got := testutil.ToFloat64(metrics.CyclesCompleted.WithLabelValues("holdmcs")) - completed; got != 1 {
+		t.Errorf("Expected 1 completed cycle, got %v", got)
+	}
+	if got := testutil.ToFloat64(metrics.CyclesAborted.WithLabelValues("holdmcs", cycleIdle)) - idle; got != 1 {
+		t.Errorf("Expected 1 idle cycle, got %v", got)
+	}
+}
@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"gopatch/config"
+	"gopatch/internal/app"
+	"gopatch/internal/clock"
+	"gopatch/internal/cycle"
+	"gopatch/internal/deadletter"
+	"gopatch/internal/sink"
+	"gopatch/patch"
+)
+
+// deadLetter saves a record given up on to DEAD_LETTER_FILE with the error and the response,
+// to be resent with "gopatch dlq resend" once the cause is fixed
+func deadLetter(ctx context.Context, caseKey string, data map[string]any, target json.RawMessage, cfg config.AppConfig, cause error) {
+	if cfg.DeadLetterFile == "" {
+		return
+	}
+
+	e := deadletter.Entry{
+		Cycle:  cycle.IDFrom(ctx),
+		Case:   caseKey,
+		Target: target,
+		Data:   data,
+		Error:  cause.Error(),
+	}
+	var status *patch.StatusError
+	if errors.As(cause, &status) {
+		e.Status, e.Response = status.Code, status.Body
+	}
+
+	if err := deadletter.Append(cfg.DeadLetterFile, e); err != nil {
+		slog.ErrorContext(ctx, "Failed to save dead letter, record lost", "case", caseKey, "file", cfg.DeadLetterFile, "err", err)
+		return
+	}
+	slog.WarnContext(ctx, "Record saved as dead letter", "case", caseKey, "file", cfg.DeadLetterFile)
+}
+
+// Resend writes a dead letter to the sink it failed on, once. A record that writes to the PLC,
+// back from the response (WRITEBACK) or to signal it, is refused without plcApp, see NeedsPLC.
+func Resend(ctx context.Context, e deadletter.Entry, cfg config.AppConfig, plcApp app.PLCWriter) error {
+	if plcApp == nil && NeedsPLC(e, cfg) {
+		return errors.New("record writes to the PLC, resend it with the PLC connected")
+	}
+	return deliverStored(cycle.WithID(ctx, e.Cycle), e.Case, e.Target, e.Data, cfg, plcApp)
+}
+
+// NeedsPLC reports whether resending the dead letter writes to the PLC
+func NeedsPLC(e deadletter.Entry, cfg config.AppConfig) bool {
+	var stored storedTarget
+	if err := json.Unmarshal(e.Target, &stored); err != nil {
+		return false
+	}
+	if stored.Signal {
+		return true
+	}
+	if !stored.WriteBack {
+		return false
+	}
+	if len(cfg.EndpointFor(e.Case, "").WriteBack) > 0 {
+		return true
+	}
+	for _, channel := range cfg.EndpointChannels(e.Case) {
+		if len(cfg.EndpointFor(e.Case, channel).WriteBack) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// UseBatches dead-letters the records of the batch sink that could not be written, as single
+// POSTs to the URL of their batch, and waits for MaxWait and retries on clk. Returns the function
+// sending the records still pending.
+func UseBatches(cfg config.AppConfig, clk clock.Clock) (flush func()) {
+	sink.SetBatchClock(clk)
+	sink.OnBatchFailure(func(ctx context.Context, url string, rec sink.Record, err error) {
+		target := recordTarget{apiUrl: url, function: http.MethodPost, routed: true}
+		deadLetter(ctx, rec.Case, rec.Data, storeTarget(target, nil), cfg, err)
+	})
+	return func() {
+		if err := sink.CloseBatches(context.Background()); err != nil {
+			slog.Error("Failed to send the pending batches", "err", err)
+		}
+	}
+}
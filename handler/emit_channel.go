@@ -0,0 +1,103 @@
+package handler
+
+import (
+	"gopatch/config"
+	"gopatch/internal/app"
+	"gopatch/internal/clock"
+	"gopatch/internal/cycle"
+	"gopatch/internal/session"
+	"log/slog"
+	"time"
+)
+
+// emitCompletedChannels sends one record per channel as soon as its own weighing is done,
+// instead of waiting for every channel (EMIT_MODE=channel); for CASE 7 & CASE 8.
+// recordKeys lists the session map keys that make up the record of a channel.
+// The records of a cycle share a cycle ID; a channel weighing again after it was sent,
+// or every channel being sent, starts the next cycle.
+func emitCompletedChannels(session *session.Session, caseKey string, ready bool, cfg config.AppConfig,
+	recordKeys func(channel string) []string, plcApp app.PLCWriter, clk clock.Clock) {
+
+	channels := session.Channels()
+
+	// A channel weighing again belongs to the next cycle
+	for _, channel := range channels {
+		if state := session.Channel(channel); state.WeightTrigger && state.Emitted {
+			nextChannelCycle(session, caseKey)
+			break
+		}
+	}
+	// Only a channel weighing starts the cycle, an idle batch leaves it alone
+	for _, channel := range channels {
+		if session.Channel(channel).WeightTrigger {
+			startCycle(session, caseKey, clk)
+			break
+		}
+	}
+
+	if !ready {
+		return
+	}
+
+	for _, channel := range channels {
+		state := session.Channel(channel)
+		if state.WeightTrigger || !state.PrevWeightTrigger || state.Emitted {
+			continue
+		}
+		emitChannel(session, caseKey, channel, recordKeys(channel), cfg, plcApp, clk)
+		session.UpdateChannel(channel, func(state *channelState) {
+			state.Emitted = true
+			state.PrevWeightTrigger = false
+			state.PrevWeightValue = 0
+		})
+	}
+
+	for _, channel := range channels {
+		if !session.Channel(channel).Emitted {
+			return
+		}
+	}
+	nextChannelCycle(session, caseKey)
+}
+
+// emitChannel validates and sends the record of a single channel, then clears its own payloads
+func emitChannel(session *session.Session, caseKey, channel string, keys []string, cfg config.AppConfig, plcApp app.PLCWriter, clk clock.Clock) {
+	data := session.Merge(keys...)
+	ctx := stampSessionCycle(session, caseKey, data, cfg, clk)
+	if !cfg.MergeChannels {
+		data[cfg.ChannelField] = channel
+	}
+
+	target, ok := checkRecord(caseKey, data, cfg)
+	if ok {
+		startTime := clk.Now()
+		// The channel records of a cycle merge into one row keyed by the cycle ID
+		target.merge = cfg.MergeChannels && !target.routed
+		if target.merge {
+			target.apiUrl = cfg.EndpointFor(caseKey, channel).APIUrl
+		}
+		if err := writeRecord(ctx, caseKey, data, target, cfg, plcApp, clk); err != nil {
+			slog.ErrorContext(ctx, "Failed to send channel record", "case", caseKey, "channel", channel, "err", err)
+		} else {
+			logRecord(ctx, caseKey, data, clk.Since(startTime))
+		}
+	}
+
+	// Clear the channel's own payloads, shared ones (vacuum, do, counter) stay for the other channels
+	session.ClearPayloads(channel, channel+"_", "weight"+channel+"_")
+}
+
+// nextChannelCycle closes the current per-channel cycle and starts a new one with a new cycle ID
+func nextChannelCycle(session *session.Session, caseKey string) {
+	session.ClearPayloads("vacuum", "do", "counterch_")
+
+	endCycle(session, caseKey, cycleCompleted)
+	session.SetCycle(cycle.NewID(), time.Time{})
+	session.SetProcessing(false)
+	session.SetAllSuccessZero(false)
+	session.UpdateChannels(func(_ string, state *channelState) {
+		state.Emitted = false
+		// Keep a weighing in progress, it is the first channel of the new cycle
+		state.PrevWeightTrigger = state.WeightTrigger
+	})
+}
@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gopatch/config"
+	"gopatch/internal/clock"
+	"gopatch/internal/metrics"
+	"gopatch/internal/session"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestEmitCompletedChannels(t *testing.T) {
+	var records []map[string]any
+	var prefers, conflicts []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var record map[string]any
+		_ = json.Unmarshal(body, &record)
+		records = append(records, record)
+		prefers = append(prefers, r.Header.Get("Prefer"))
+		conflicts = append(conflicts, r.URL.Query().Get("on_conflict"))
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	cfg := config.AppConfig{
+		APIUrl: server.URL, Function: "POST", EmitMode: config.EmitPerChannel,
+		CycleIDField: "cycle_id", ChannelField: "channel", StatusField: "status", ReasonField: "validation_error",
+	}
+	recordKeys := func(channel string) []string { return []string{"weight" + channel + "_", "vacuum"} }
+
+	// ch3 is down for maintenance and never weighs
+	s := session.NewSession([]string{"ch1", "ch2", "ch3"})
+	s.SetField("vacuum", "vacuum_lia1", 20.0)
+	s.SetField("weightch1_", "ch1_weighing", 101.0)
+	s.UpdateChannel("ch1", func(state *session.ChannelState) { state.PrevWeightTrigger = true })
+	s.UpdateChannel("ch2", func(state *session.ChannelState) { state.WeightTrigger = true })
+	s.UpdateChannel("ch2", func(state *session.ChannelState) { state.PrevWeightTrigger = true })
+
+	emitCompletedChannels(s, "weight", true, cfg, recordKeys, nil, clock.Real)
+	if len(records) != 1 || records[0]["channel"] != "ch1" || records[0]["ch1_weighing"] != 101.0 || records[0]["vacuum_lia1"] != 20.0 {
+		t.Fatalf("Expected the ch1 record only, got %v", records)
+	}
+	cycleID := records[0]["cycle_id"]
+
+	s.SetField("weightch2_", "ch2_weighing", 102.0)
+	s.UpdateChannel("ch2", func(state *session.ChannelState) { state.WeightTrigger = false })
+	emitCompletedChannels(s, "weight", true, cfg, recordKeys, nil, clock.Real)
+	if len(records) != 2 || records[1]["channel"] != "ch2" || records[1]["cycle_id"] != cycleID {
+		t.Fatalf("Expected the ch2 record in the same cycle, got %v", records)
+	}
+	if _, ok := records[1]["ch1_weighing"]; ok {
+		t.Errorf("Expected ch1 payload to be cleared after it was sent, got %v", records[1])
+	}
+
+	// ch1 weighing again starts the next cycle
+	s.UpdateChannel("ch1", func(state *session.ChannelState) { state.WeightTrigger = true })
+	emitCompletedChannels(s, "weight", true, cfg, recordKeys, nil, clock.Real)
+	if id, _ := s.Cycle(); id == cycleID || s.Channel("ch2").Emitted || !s.Channel("ch1").PrevWeightTrigger {
+		t.Errorf("Expected a new cycle keeping the ch1 weighing, got %+v", s)
+	}
+
+	// Merged records upsert into one row per cycle, with UPSERT_RESOLUTION
+	cfg.MergeChannels = true
+	cfg.UpsertResolution = "ignore"
+	s.UpdateChannel("ch1", func(state *session.ChannelState) { state.WeightTrigger = false })
+	emitCompletedChannels(s, "weight", true, cfg, recordKeys, nil, clock.Real)
+	if id, _ := s.Cycle(); len(records) != 3 || prefers[2] != "resolution=ignore-duplicates" || records[2]["cycle_id"] != id {
+		t.Fatalf("Expected a merged ch1 record, got %v %v", records, prefers)
+	}
+	if conflicts[2] != "cycle_id" {
+		t.Errorf("Expected the merge to conflict on the cycle ID, got %q", conflicts[2])
+	}
+	if _, ok := records[2]["channel"]; ok {
+		t.Errorf("Expected no channel field on merged records, got %v", records[2])
+	}
+}
+
+func TestEmitCompletedChannelsIdleBatch(t *testing.T) {
+	clk := clock.NewFake(time.Date(2025, 1, 1, 8, 0, 0, 0, time.UTC))
+	started := testutil.ToFloat64(metrics.CyclesStarted.WithLabelValues("weight"))
+	idle := testutil.ToFloat64(metrics.CyclesAborted.WithLabelValues("weight", cycleIdle))
+	cfg := config.AppConfig{EmitMode: config.EmitPerChannel, CycleIDField: "cycle_id"}
+	recordKeys := func(channel string) []string { return []string{"weight" + channel + "_"} }
+
+	// No channel weighs in either batch
+	s := session.NewSession([]string{"ch1", "ch2"})
+	for range 2 {
+		emitCompletedChannels(s, "weight", true, cfg, recordKeys, nil, clk)
+		trackCycle(s, "weight", clk)
+	}
+
+	if id, _ := s.Cycle(); id != "" {
+		t.Errorf("Expected no cycle while no channel weighs, got %s", id)
+	}
+	if got := testutil.ToFloat64(metrics.CyclesStarted.WithLabelValues("weight")) - started; got != 0 {
+		t.Errorf("Expected no started cycle, got %v", got)
+	}
+	if got := testutil.ToFloat64(metrics.CyclesAborted.WithLabelValues("weight", cycleIdle)) - idle; got != 0 {
+		t.Errorf("Expected no idle cycle, got %v", got)
+	}
+}
@@ -3,6 +3,7 @@ package handler
 import (
 	"gopatch/config"
 	"gopatch/internal/app"
+	"gopatch/internal/clock"
 	"gopatch/internal/session"
 	"gopatch/internal/utils"
 	"gopatch/model"
@@ -17,7 +18,8 @@ func Trigger(
 	messages []model.Message,
 	cfg config.AppConfig,
 	rMsgJSONChan <-chan string,
-	plcApp *app.Application,
+	plcApp app.PLCWriter,
+	clk clock.Clock,
 ) {
 
 	// Parse trigger keys once
@@ -33,20 +35,24 @@ func Trigger(
 	for _, tk := range triggerKeys {
 		// Map of case keys to handler functions
 		caseHandlers := map[string]func(){
-			"time.duration":     func() { handleTimeDurationCase(tk, jsonPayloads, messages, cfg.Loop) },
-			"standard":          func() { handleStandardCase(tk, jsonPayloads, messages, cfg) },
-			"trigger":           func() { handleTriggerCase(tk, jsonPayloads, messages, cfg) },
-			"hold":              func() { handleHoldCase(session, jsonPayloads, messages, cfg, isAccRate) },
-			"special":           func() { handleSpecialCase(session, tk, jsonPayloads, messages, cfg) },
-			"holdfilling":       func() { handleHoldFillingCase(session, jsonPayloads, messages, cfg, rMsgJSONChan) },
-			"weight":            func() { handleWeight(session, jsonPayloads, messages, cfg, false, isAccRate, rMsgJSONChan) },
-			"holdfillingweight": func() { handleHoldFillingWeightCase(session, jsonPayloads, messages, cfg, rMsgJSONChan) },
-			"holdmcs":           func() { handleHoldMCSCase(session, jsonPayloads, messages, cfg, rMsgJSONChan) },
-			"vacuum":            func() { handleVacuumCase(session, jsonPayloads, cfg, rMsgJSONChan, plcApp) },
+			"time.duration": func() { handleTimeDurationCase(tk, jsonPayloads, cfg, plcApp, clk) },
+			"standard":      func() { handleStandardCase(tk, jsonPayloads, messages, cfg, plcApp, clk) },
+			"trigger":       func() { handleTriggerCase(tk, jsonPayloads, messages, cfg, plcApp, clk) },
+			"hold":          func() { handleHoldCase(session, jsonPayloads, messages, cfg, isAccRate, plcApp, clk) },
+			"special":       func() { handleSpecialCase(session, tk, jsonPayloads, messages, cfg, plcApp, clk) },
+			"holdfilling":   func() { handleHoldFillingCase(session, jsonPayloads, messages, cfg, rMsgJSONChan, plcApp, clk) },
+			"weight": func() {
+				handleWeight(session, jsonPayloads, messages, cfg, false, isAccRate, rMsgJSONChan, plcApp, clk)
+			},
+			"holdfillingweight": func() { handleHoldFillingWeightCase(session, jsonPayloads, messages, cfg, rMsgJSONChan, plcApp, clk) },
+			"holdmcs":           func() { handleHoldMCSCase(session, jsonPayloads, messages, cfg, rMsgJSONChan, plcApp, clk) },
+			"vacuum":            func() { handleVacuumCase(session, jsonPayloads, cfg, rMsgJSONChan, plcApp, clk) },
 		}
 		// Check if the current caseKey is in the map, and handle accordingly
 		if handler, exists := caseHandlers[tk.CaseKey]; exists {
 			handler()
+			// Abandon the cycle when it never completes, so its data won't leak into the next one
+			checkCycleTimeout(session, tk.CaseKey, cfg, clk)
 		}
 	}
 }
@@ -1,15 +1,19 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"log/slog"
+	"os"
 
 	"gopatch/config"
 	"gopatch/internal/app"
+	"gopatch/internal/clock"
+	"gopatch/internal/logging"
 	"gopatch/internal/session"
 	"gopatch/internal/utils"
 	"gopatch/model"
-	"log"
 	"strings"
 	"time"
 )
@@ -20,27 +24,32 @@ var stopProcessing = make(chan struct{})
 func ProcessMQTTData(
 	cfg config.AppConfig,
 	receivedMessagesJSONChan <-chan string,
-	plcApp *app.Application,
+	plcApp app.PLCWriter,
+	clk clock.Clock,
 ) {
 	// Create a persistent session once
 	// Use unique key per logical case
 	caseKey := cfg.Function + "_" + cfg.Trigger
-	session := session.GetOrCreateSession(caseKey)
+	session := session.GetOrCreateSession(caseKey, cfg.Channels)
 
 	// Create a map to store all JSON payloads
 	jsonPayloads := utils.NewSafeJsonPayloads()
 	for {
 		select {
-		case jsonString := <-receivedMessagesJSONChan:
+		case jsonString, ok := <-receivedMessagesJSONChan:
+			if !ok {
+				// Channel closed, e.g. the end of a replay
+				return
+			}
 			if jsonString == "" {
-				fmt.Println("JSON string is empty")
+				slog.Debug("Skipping empty MQTT batch", "session", caseKey)
 				continue
 			}
 
 			var messages []model.Message
 
 			if err := json.Unmarshal([]byte(jsonString), &messages); err != nil {
-				fmt.Printf("Error unmarshaling JSON: %v\n", err)
+				slog.Error("Failed to parse MQTT batch", "session", caseKey, "err", err)
 				// time.Sleep(time.Second)
 				continue
 			}
@@ -50,12 +59,21 @@ func ProcessMQTTData(
 				fieldNameLower := strings.ToLower(message.Address)
 				fieldValue := message.Value
 				jsonPayloads.Set(fieldNameLower, fieldValue)
+				latestPayloads.Set(fieldNameLower, fieldValue)
+			}
+
+			// Paused from the admin API, the batch only updates the latest values
+			if Paused() {
+				jsonPayloads.Clear()
+				return
 			}
 
 			// Start to collect data when trigger specify device
 			// collect the data for few seconds, process for further handling method.
 			// Change Payloads title or delete the extra devices and etc..
-			Trigger(session, jsonPayloads, messages, cfg, receivedMessagesJSONChan, plcApp)
+			batchMu.Lock()
+			Trigger(session, jsonPayloads, messages, cfg, receivedMessagesJSONChan, plcApp, clk)
+			batchMu.Unlock()
 			jsonPayloads.Clear()
 
 			return
@@ -74,7 +92,11 @@ func StopProcessing() {
 func drainChannel(ch <-chan string) {
 	for {
 		select {
-		case <-ch:
+		case _, ok := <-ch:
+			if !ok {
+				// Closed channel, nothing left to discard
+				return
+			}
 			// Discard the value
 		default:
 			// Exit when there's nothing left
@@ -83,48 +105,23 @@ func drainChannel(ch <-chan string) {
 	}
 }
 
-// prettyPrintJSONWithTime handles both map[string]interface{} and *SafeJsonPayloads types
-func prettyPrintJSONWithTime(data interface{}, duration time.Duration) {
-	// Handle nil data case
-	if data == nil {
-		log.Println("Error: Provided data is nil.")
-		return
-	}
-
-	// Determine if data is a map[string]interface{} or *SafeJsonPayloads
-	var formatted []byte
-	var err error
+// logRecord logs a record sent to the API with the time it took, pretty printed in color in dev mode.
+// data is a map[string]any or *SafeJsonPayloads.
+func logRecord(ctx context.Context, caseKey string, data any, elapsed time.Duration) {
+	var record map[string]any
 	switch v := data.(type) {
-	case map[string]interface{}:
-		// Handle normal map[string]interface{} directly
-		formatted, err = json.MarshalIndent(v, "", "  ")
+	case map[string]any:
+		record = v
 	case *utils.SafeJsonPayloads:
-		// Handle *SafeJsonPayloads, extracting the map from it
-		formatted, err = json.MarshalIndent(v.GetData(), "", "  ")
+		record = v.GetData()
 	default:
-		log.Println("Error: Unsupported data type.")
+		slog.ErrorContext(ctx, "Unsupported record type", "case", caseKey, "type", fmt.Sprintf("%T", data))
 		return
 	}
 
-	if err != nil {
-		fmt.Println("Error formatting JSON:", err)
+	if logging.Dev() {
+		logging.PrettyPrint(os.Stderr, record, elapsed)
 		return
 	}
-
-	// Define ANSI escape codes for colors
-	greenColor := "\x1b[32m" // Green color for time
-	pinkColor := "\x1b[35m"  // Pink color for JSON
-	resetColor := "\x1b[0m"  // Reset color to default
-
-	// Convert the time duration to seconds and format it
-	elapsedTime := fmt.Sprintf("%s%.2f s%s", greenColor, float64(duration.Seconds()), resetColor)
-
-	// Format the JSON data with pink color
-	jsonFormatted := fmt.Sprintf("%s%s%s", pinkColor, string(formatted), resetColor)
-
-	// Concatenate the time and JSON data into a single output string
-	output := fmt.Sprintf(">= %s %s", elapsedTime, jsonFormatted)
-
-	// Print the combined output
-	log.Println(output)
+	slog.InfoContext(ctx, "Record sent", "case", caseKey, "elapsed", elapsed, "record", record)
 }
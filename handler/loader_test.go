@@ -1,6 +1,10 @@
 package handler
 
 import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
 	"testing"
 	"time"
 
@@ -21,8 +25,12 @@ func (m *MockSafeJsonPayloads) GetData() map[string]interface{} {
 	return args.Get(0).(map[string]interface{})
 }
 
-func TestPrettyPrintJSONWithTime(t *testing.T) {
-	// Sample data for testing prettyPrintJSONWithTime
+func TestLogRecord(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+	var out bytes.Buffer
+	slog.SetDefault(slog.New(slog.NewTextHandler(&out, nil)))
+
+	// Sample data for testing logRecord
 	data := map[string]interface{}{
 		"device": "device_1",
 		"value":  "value_1",
@@ -30,12 +38,18 @@ func TestPrettyPrintJSONWithTime(t *testing.T) {
 
 	// Test the function with a map[string]interface{} type
 	startTime := time.Now()
-	prettyPrintJSONWithTime(data, time.Since(startTime))
+	logRecord(context.Background(), "holdfilling", data, time.Since(startTime))
+	if !strings.Contains(out.String(), "Record sent") || !strings.Contains(out.String(), "device_1") {
+		t.Errorf("Expected the record to be logged, got %q", out.String())
+	}
 
-	// Test with SafeJsonPayloads (mock)
+	// Test with SafeJsonPayloads (mock), not a supported record type
+	out.Reset()
 	mockJsonPayloads := new(MockSafeJsonPayloads)
 	mockJsonPayloads.On("GetData").Return(data)
 
-	// Test the function with SafeJsonPayloads type
-	prettyPrintJSONWithTime(mockJsonPayloads, time.Since(startTime))
+	logRecord(context.Background(), "holdfilling", mockJsonPayloads, time.Since(startTime))
+	if !strings.Contains(out.String(), "Unsupported record type") {
+		t.Errorf("Expected an unsupported type error, got %q", out.String())
+	}
 }
@@ -0,0 +1,108 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+
+	"gopatch/config"
+	"gopatch/internal/app"
+	"gopatch/internal/clock"
+	"gopatch/internal/cycle"
+	"gopatch/internal/outbox"
+	"gopatch/internal/sink"
+)
+
+// outboxQueue receives every record in place of its sink while set, see UseOutbox
+var outboxQueue atomic.Pointer[outbox.Queue]
+
+// storedTarget is the recordTarget of a record kept in the outbox or the dead-letter file
+type storedTarget struct {
+	APIUrl    string `json:"api_url,omitempty"`
+	Function  string `json:"function,omitempty"`
+	Routed    bool   `json:"routed,omitempty"`
+	Merge     bool   `json:"merge,omitempty"`
+	WriteBack bool   `json:"write_back,omitempty"` // Write the upsert response back to the PLC
+	Signal    bool   `json:"signal,omitempty"`     // Signal the PLC once written, see signalPLC
+}
+
+// storeTarget encodes the target of a record to keep it with the record
+func storeTarget(target recordTarget, plcApp app.PLCWriter) json.RawMessage {
+	stored, _ := json.Marshal(storedTarget{
+		APIUrl:    target.apiUrl,
+		Function:  target.function,
+		Routed:    target.routed,
+		Merge:     target.merge,
+		WriteBack: plcApp != nil,
+		Signal:    target.signal && plcApp != nil,
+	})
+	return stored
+}
+
+// UseOutbox queues every record in q instead of writing it to its sink, and delivers the queued
+// records in order in the background. Call stop to finish the delivery in progress and go back to direct writes.
+func UseOutbox(q *outbox.Queue, cfg config.AppConfig, plcApp app.PLCWriter, clk clock.Clock) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	worker := outbox.Worker{
+		Queue: q,
+		Deliver: func(ctx context.Context, e outbox.Entry) error {
+			return deliverStored(ctx, e.Case, e.Target, e.Data, cfg, plcApp)
+		},
+		Rejected: func(e outbox.Entry, err error) {
+			deadLetter(cycle.WithID(context.Background(), e.Cycle), e.Case, e.Data, e.Target, cfg, err)
+		},
+		BaseDelay: cfg.RetryBaseDelay,
+		MaxDelay:  cfg.RetryMaxDelay,
+		Clock:     clk,
+	}
+	go func() {
+		defer close(done)
+		worker.Run(ctx)
+	}()
+	outboxQueue.Store(q)
+
+	return func() {
+		outboxQueue.CompareAndSwap(q, nil)
+		cancel()
+		<-done
+	}
+}
+
+// queueRecord appends a record to the outbox. Reports false when no outbox is in use.
+func queueRecord(ctx context.Context, caseKey string, data map[string]any, target recordTarget, plcApp app.PLCWriter) (bool, error) {
+	q := outboxQueue.Load()
+	if q == nil {
+		return false, nil
+	}
+
+	return true, q.Append(outbox.Entry{Cycle: cycle.IDFrom(ctx), Case: caseKey, Target: storeTarget(target, plcApp), Data: data})
+}
+
+// deliverStored writes a record taken from the outbox or the dead-letter file to its sink, once.
+// The batch sink sends it at once, so the error tells whether it was written.
+func deliverStored(ctx context.Context, caseKey string, target json.RawMessage, data map[string]any, cfg config.AppConfig, plcApp app.PLCWriter) error {
+	var stored storedTarget
+	if len(target) > 0 {
+		if err := json.Unmarshal(target, &stored); err != nil {
+			return fmt.Errorf("invalid target of stored record: %w", err)
+		}
+	}
+	writeBack := plcApp
+	if !stored.WriteBack {
+		writeBack = nil
+	}
+
+	s, err := recordSink(caseKey, recordTarget{apiUrl: stored.APIUrl, function: stored.Function, routed: stored.Routed, merge: stored.Merge}, cfg, writeBack)
+	if err != nil {
+		return err
+	}
+	if err := s.Write(sink.WithoutBatching(ctx), sink.Record{Case: caseKey, Data: data}); err != nil {
+		return err
+	}
+	if stored.Signal {
+		signalPLC(ctx, cfg, plcApp)
+	}
+	return nil
+}
@@ -0,0 +1,225 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gopatch/config"
+	"gopatch/internal/clock"
+	"gopatch/internal/cycle"
+	"gopatch/internal/deadletter"
+	"gopatch/internal/outbox"
+	"gopatch/internal/writeback"
+	"gopatch/patch"
+)
+
+func TestWriteRecordThroughOutbox(t *testing.T) {
+	sink := &memorySink{status: http.StatusServiceUnavailable, body: []byte(`{}`)}
+	patch.SetTransport(sink)
+	t.Cleanup(func() { patch.SetTransport(http.DefaultTransport) })
+
+	q, err := outbox.Open(t.TempDir(), outbox.Options{}, clock.Real)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	cfg := config.AppConfig{APIUrl: "http://api.local/rest/v1/filling", Function: "POST", RetryBaseDelay: time.Millisecond}
+	stop := UseOutbox(q, cfg, nil, clock.Real)
+	defer stop()
+
+	// Queued even though the API is down
+	ctx := cycle.WithID(context.Background(), "c1")
+	if err := writeRecord(ctx, "hold", map[string]any{"ch1_weighing": 101.5}, recordTarget{}, cfg, nil, clock.Real); err != nil {
+		t.Fatalf("Expected the record queued, got %v", err)
+	}
+
+	// Delivered once the API is back
+	sink.mu.Lock()
+	sink.status = http.StatusCreated
+	sink.mu.Unlock()
+	deadline := time.Now().Add(5 * time.Second)
+	for q.Len() > 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if q.Len() != 0 {
+		t.Fatal("Record not delivered")
+	}
+
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	last := sink.requests[len(sink.requests)-1]
+	if last.Cycle != "c1" || last.Body["ch1_weighing"] != 101.5 {
+		t.Errorf("Expected the queued record with its cycle ID, got %+v", last)
+	}
+}
+
+func TestOutboxSignalsPLCOnDelivery(t *testing.T) {
+	sink := &memorySink{status: http.StatusServiceUnavailable, body: []byte(`{}`)}
+	patch.SetTransport(sink)
+	t.Cleanup(func() { patch.SetTransport(http.DefaultTransport) })
+
+	q, err := outbox.Open(t.TempDir(), outbox.Options{}, clock.Real)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	cfg := config.AppConfig{APIUrl: "http://api.local/rest/v1/vacuum", Function: "POST", RetryBaseDelay: time.Millisecond,
+		Plc: config.PlcConfig{PlcDevice: "M,600,1,1", PlcData: "1"}}
+	plc := &fakePLC{}
+	stop := UseOutbox(q, cfg, plc, clock.Real)
+	defer stop()
+
+	// Queued while the API is down, the PLC is not told yet
+	target := recordTarget{signal: true}
+	if err := writeRecord(context.Background(), "vacuum", map[string]any{"vacuum_start": 1}, target, cfg, plc, clock.Real); err != nil {
+		t.Fatalf("Expected the record queued, got %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	plc.mu.Lock()
+	if len(plc.writes) != 0 {
+		t.Errorf("Expected no PLC write before delivery, got %+v", plc.writes)
+	}
+	plc.mu.Unlock()
+
+	sink.mu.Lock()
+	sink.status = http.StatusCreated
+	sink.mu.Unlock()
+	deadline := time.Now().Add(5 * time.Second)
+	for q.Len() > 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	plc.mu.Lock()
+	defer plc.mu.Unlock()
+	if q.Len() != 0 || len(plc.writes) != 1 || plc.writes[0].Device != "M,600,1,1" {
+		t.Errorf("Expected the PLC signalled once delivered, got %+v", plc.writes)
+	}
+}
+
+func TestOutboxBypassesBatching(t *testing.T) {
+	sink := &memorySink{status: http.StatusCreated, body: []byte(`{}`)}
+	patch.SetTransport(sink)
+	t.Cleanup(func() { patch.SetTransport(http.DefaultTransport) })
+
+	q, err := outbox.Open(t.TempDir(), outbox.Options{}, clock.Real)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	cfg := config.AppConfig{APIUrl: "http://api.local/rest/v1/filling", Sinks: map[string][]string{"": {"batch"}},
+		BatchMaxItems: 100, BatchMaxWait: time.Hour}
+	stop := UseOutbox(q, cfg, nil, clock.Real)
+	defer stop()
+
+	if err := writeRecord(context.Background(), "hold", map[string]any{"ch1_weighing": 101.5}, recordTarget{}, cfg, nil, clock.Real); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for q.Len() > 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// Acknowledged only once sent, not when a batch took it
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if q.Len() != 0 || len(sink.requests) != 1 || sink.requests[0].Body["ch1_weighing"] != 101.5 {
+		t.Errorf("Expected the record sent before it left the outbox, got %+v", sink.requests)
+	}
+}
+
+func TestRejectedRecordDeadLettered(t *testing.T) {
+	sink := &memorySink{status: http.StatusBadRequest, body: []byte(`{"code":"PGRST204","message":"Could not find the 'ch1_weighing' column"}`)}
+	patch.SetTransport(sink)
+	t.Cleanup(func() { patch.SetTransport(http.DefaultTransport) })
+
+	path := filepath.Join(t.TempDir(), "deadletter.jsonl")
+	cfg := config.AppConfig{APIUrl: "http://api.local/rest/v1/filling", Function: "POST", RetryMaxAttempts: 3, DeadLetterFile: path}
+
+	ctx := cycle.WithID(context.Background(), "c1")
+	if err := writeRecord(ctx, "hold", map[string]any{"ch1_weighing": 101.5}, recordTarget{}, cfg, nil, clock.Real); err == nil {
+		t.Fatal("Expected the rejection")
+	}
+
+	entries, err := deadletter.Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("Expected 1 dead letter, got %d", len(entries))
+	}
+	e := entries[0]
+	if e.Case != "hold" || e.Cycle != "c1" || e.Status != http.StatusBadRequest || !strings.Contains(e.Response, "PGRST204") {
+		t.Errorf("Unexpected dead letter %+v", e)
+	}
+
+	// Resent to the same endpoint once the table is fixed
+	sink.mu.Lock()
+	sink.status = http.StatusCreated
+	sink.mu.Unlock()
+	if err := Resend(context.Background(), e, cfg, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if len(sink.requests) != 2 {
+		t.Fatalf("Expected the rejection not retried and one resend, got %d requests", len(sink.requests))
+	}
+	last := sink.requests[1]
+	if last.Method != "POST" || last.Cycle != "c1" || last.Body["ch1_weighing"] != 101.5 {
+		t.Errorf("Expected the record resent, got %+v", last)
+	}
+}
+
+func TestResendWriteBack(t *testing.T) {
+	sink := &memorySink{status: http.StatusBadRequest, body: []byte(`{"message":"Could not find the 'vacuum_start' column"}`)}
+	patch.SetTransport(sink)
+	t.Cleanup(func() { patch.SetTransport(http.DefaultTransport) })
+
+	m, _ := writeback.Parse("judgement.pass=M,700,1,1:int")
+	path := filepath.Join(t.TempDir(), "deadletter.jsonl")
+	cfg := config.AppConfig{APIUrl: "http://api.local/rest/v1/vacuum", Sinks: map[string][]string{"": {"upsert"}},
+		WriteBack: m, RetryMaxAttempts: 1, DeadLetterFile: path}
+	plc := &fakePLC{}
+	if err := writeRecord(context.Background(), "vacuum", map[string]any{"vacuum_start": 1}, recordTarget{}, cfg, plc, clock.Real); err == nil {
+		t.Fatal("Expected the rejection")
+	}
+	entries, err := deadletter.Read(path)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("Expected 1 dead letter, got %v %v", entries, err)
+	}
+	e := entries[0]
+	if !NeedsPLC(e, cfg) {
+		t.Fatal("Expected the write-back record to need the PLC")
+	}
+
+	sink.mu.Lock()
+	sink.status, sink.body = http.StatusCreated, []byte(`[{"judgement": {"pass": true}}]`)
+	sink.mu.Unlock()
+
+	// Refused without the PLC, the write-back would be lost
+	if err := Resend(context.Background(), e, cfg, nil); err == nil {
+		t.Fatal("Expected the resend refused without the PLC")
+	}
+	if err := Resend(context.Background(), e, cfg, plc); err != nil {
+		t.Fatal(err)
+	}
+
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if len(sink.requests) != 2 {
+		t.Errorf("Expected the rejection and one resend, got %d requests", len(sink.requests))
+	}
+	plc.mu.Lock()
+	defer plc.mu.Unlock()
+	if len(plc.writes) != 1 || plc.writes[0].Device != "M,700,1,1" || plc.writes[0].Value != 1 {
+		t.Errorf("Expected the response written back to M,700,1,1, got %+v", plc.writes)
+	}
+}
@@ -2,34 +2,114 @@ package handler
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
 	"gopatch/config"
 	"gopatch/internal/app"
+	"gopatch/internal/clock"
+	"gopatch/internal/metrics"
 	"gopatch/internal/session"
-	"gopatch/patch"
-	"log"
-	"time"
+	"gopatch/internal/sink"
+	"gopatch/internal/validate"
+	"log/slog"
+	"strings"
 )
 
-func processPatch(session *session.Session, keys []string, cfg config.AppConfig, after func(), rMsgJSONChan <-chan string, plcApp *app.Application) {
-	fmt.Println("All weight triggers are now inactive. Processing the patch.")
+// recordTarget is the endpoint a checked record is sent to
+type recordTarget struct {
+	apiUrl   string
+	function string
+	routed   bool // Sent to the RULES_<CASE>_ROUTE_API_URL endpoint instead of the sinks of the case
+	merge    bool // Merged into the row of its cycle at apiUrl instead of the sinks of the case (MERGE_CHANNELS)
+	signal   bool // Signal the PLC once the record is written, see signalPLC
+}
 
-	parts := []map[string]any{}
-	for _, key := range keys {
-		parts = append(parts, session.ProcessedPayloadsMap[key])
+// recordSink returns the sink a record of the case goes to
+func recordSink(caseKey string, target recordTarget, cfg config.AppConfig, plcApp app.PLCWriter) (sink.Sink, error) {
+	switch {
+	case target.routed:
+		return sink.REST{URL: target.apiUrl, Method: target.function, Key: cfg.ServiceRoleKey, Auth: sink.AuthFor(cfg.EndpointFor(caseKey, ""))}, nil
+	case target.merge:
+		endpoint := cfg.EndpointFor(caseKey, "")
+		endpoint.APIUrl = target.apiUrl
+		return sink.Merged(endpoint, plcApp), nil
+	default:
+		return sink.For(caseKey, cfg, plcApp)
 	}
-	data := mergeNonEmptyMaps(parts...)
+}
+
+// writeRecord writes a record of the case to its sink. Retryable failures are retried
+// with backoff (RETRY_*); the error returned is final, the record was not written
+// and went to the dead-letter file.
+func writeRecord(ctx context.Context, caseKey string, data map[string]any, target recordTarget, cfg config.AppConfig,
+	plcApp app.PLCWriter, clk clock.Clock) error {
 
-	// Count top-level nil values
-	nullCount := 0
-	for _, value := range data {
-		if value == nil {
-			nullCount++
+	// With an outbox the record only has to reach the disk, the outbox worker delivers it
+	if queued, err := queueRecord(ctx, caseKey, data, target, plcApp); queued {
+		if err == nil {
+			return nil
 		}
+		slog.ErrorContext(ctx, "Failed to queue record, sending it directly", "case", caseKey, "err", err)
+	}
+
+	s, err := recordSink(caseKey, target, cfg, plcApp)
+	if err == nil {
+		retry := sink.Retry{Sink: s, MaxAttempts: cfg.RetryMaxAttempts, BaseDelay: cfg.RetryBaseDelay, MaxDelay: cfg.RetryMaxDelay, Clock: clk}
+		err = retry.Write(ctx, sink.Record{Case: caseKey, Data: data})
 	}
-	if nullCount > 3 {
-		fmt.Println("Aborting patch: more than 3 null values in data")
+	if err != nil {
+		metrics.SinkFailures.WithLabelValues(caseKey).Inc()
+		deadLetter(ctx, caseKey, data, storeTarget(target, plcApp), cfg, err)
+		return err
+	}
+	if target.signal {
+		signalPLC(ctx, cfg, plcApp)
+	}
+	return nil
+}
+
+// checkRecord validates the record against the completeness rules of the case.
+// Returns false when the record must be dropped; a flagged or routed record
+// gets the failure reason in REASON_FIELD and status "invalid".
+func checkRecord(caseKey string, data map[string]any, cfg config.AppConfig) (recordTarget, bool) {
+	endpoint := cfg.EndpointFor(caseKey, "")
+	target := recordTarget{apiUrl: endpoint.APIUrl, function: endpoint.Function}
+
+	rules := cfg.RulesFor(caseKey)
+	reasons := rules.Validate(data)
+	if len(reasons) == 0 {
+		return target, true
+	}
+	reason := strings.Join(reasons, "; ")
+
+	switch rules.OnInvalid {
+	case validate.ActionFlag:
+		slog.Warn("Record incomplete, sending flagged", "case", caseKey, "reason", reason)
+	case validate.ActionRoute:
+		if rules.RouteAPIUrl == "" {
+			slog.Warn("Dropping incomplete record, no route endpoint configured", "case", caseKey, "reason", reason)
+			return target, false
+		}
+		slog.Warn("Record incomplete, routing", "case", caseKey, "route", rules.RouteAPIUrl, "reason", reason)
+		target = recordTarget{apiUrl: rules.RouteAPIUrl, function: "POST", routed: true}
+	default:
+		slog.Warn("Dropping incomplete record", "case", caseKey, "reason", reason)
+		return target, false
+	}
+
+	data[cfg.ReasonField] = reason
+	data[cfg.StatusField] = "invalid"
+	return target, true
+}
+
+// processPatch sends the record merged from the session keys and resets the session for the next cycle.
+func processPatch(session *session.Session, caseKey string, keys []string, cfg config.AppConfig, after func(), rMsgJSONChan <-chan string,
+	plcApp app.PLCWriter, clk clock.Clock) {
+	data := session.Merge(keys...)
+
+	ctx := stampSessionCycle(session, caseKey, data, cfg, clk)
+	slog.DebugContext(ctx, "Cycle complete, sending record", "case", caseKey)
+	target, ok := checkRecord(caseKey, data, cfg)
+	if !ok {
+		endCycle(session, caseKey, cycleInvalid)
 		resetWeightTriggers(session)
 		if after != nil {
 			after()
@@ -37,33 +117,23 @@ func processPatch(session *session.Session, keys []string, cfg config.AppConfig,
 		drainChannel(rMsgJSONChan)
 		return
 	}
+	// The PLC is told once the vacuum check is written, directly or by the outbox worker
+	target.signal = caseKey == "vacuum"
 
-	startTime := time.Now()
-	jsonData, err := json.Marshal(data)
-	if err != nil {
-		fmt.Println("Error marshaling JSON:", err)
-		return
-	}
-
-	if cfg.InsertMode == "upsert" {
-		_, err := patch.SendUpsertRequest(cfg.APIUrl, cfg.ServiceRoleKey, jsonData, cfg, plcApp)
-		if err != nil {
-			log.Fatal("Error sending upsert request:", err)
-		}
+	// A record that can't be written is dropped, the session still moves on to the next cycle
+	outcome := cycleCompleted
+	startTime := clk.Now()
+	if err := writeRecord(ctx, caseKey, data, target, cfg, plcApp, clk); err != nil {
+		slog.ErrorContext(ctx, "Failed to send record", "case", caseKey, "err", err)
+		outcome = cycleFailed
 	} else {
-		_, err := patch.SendPatchRequest(cfg.APIUrl, cfg.ServiceRoleKey, jsonData, cfg.Function)
-		if err != nil {
-			log.Fatal("Error sending patch request:", err)
-		}
+		logRecord(ctx, caseKey, data, clk.Since(startTime))
 	}
 
-	prettyPrintJSONWithTime(data, time.Since(startTime))
-
-	for key := range session.ProcessedPayloadsMap {
-		delete(session.ProcessedPayloadsMap, key)
-	}
+	session.DeletePayloads()
 
 	// Always reset weight triggers
+	endCycle(session, caseKey, outcome)
 	resetWeightTriggers(session)
 
 	// Call the extra cleanup if provided
@@ -72,21 +142,25 @@ func processPatch(session *session.Session, keys []string, cfg config.AppConfig,
 	}
 
 	drainChannel(rMsgJSONChan)
-
-	if plcApp != nil {
-		err := plcApp.WritePLC(context.Background(), cfg.Plc.PlcDevice, cfg.Plc.PlcData)
-		if err != nil {
-			fmt.Println("PLC write failed:", err)
-		}
-	}
-
 }
 
+// channelState names session.ChannelState where a session parameter shadows the package
+type channelState = session.ChannelState
+
 func shouldPatch(caseID string, ready bool, session *session.Session) bool {
 	if caseID == "case7" || caseID == "case8" {
 		// Case 7 & Case 8: Wait for all channels to deactivate after being active
-		return !session.WeightTriggerCh1 && !session.WeightTriggerCh2 && !session.WeightTriggerCh3 &&
-			session.PrevWeightTriggerCh1 && session.PrevWeightTriggerCh2 && session.PrevWeightTriggerCh3 && ready
+		channels := session.Channels()
+		if len(channels) == 0 {
+			return false
+		}
+		for _, channel := range channels {
+			state := session.Channel(channel)
+			if state.WeightTrigger || !state.PrevWeightTrigger {
+				return false
+			}
+		}
+		return ready
 	}
 	// Default: don't patch
 	return false
@@ -94,12 +168,11 @@ func shouldPatch(caseID string, ready bool, session *session.Session) bool {
 
 // Reset previous triggers to avoid reprocessing
 func resetWeightTriggers(session *session.Session) {
-	session.AllSuccessZero = false
-	session.IsProcessing = false
-	session.PrevWeightTriggerCh1 = false
-	session.PrevWeightTriggerCh2 = false
-	session.PrevWeightTriggerCh3 = false
-	*session.PrevWeightValueCh1 = 0
-	*session.PrevWeightValueCh2 = 0
-	*session.PrevWeightValueCh3 = 0
+	session.SetAllSuccessZero(false)
+	session.SetProcessing(false)
+	session.UpdateChannels(func(_ string, state *channelState) {
+		state.PrevWeightTrigger = false
+		state.Emitted = false
+		state.PrevWeightValue = 0
+	})
 }
@@ -0,0 +1,82 @@
+package handler
+
+import (
+	"testing"
+
+	"gopatch/config"
+	"gopatch/internal/session"
+	"gopatch/internal/validate"
+)
+
+func TestShouldPatchWaitsForEveryChannel(t *testing.T) {
+	channels := []string{"ch1", "ch2", "ch3", "ch4", "ch5", "ch6"}
+	s := session.NewSession(channels)
+
+	for _, channel := range channels[:5] {
+		s.UpdateChannel(channel, func(state *session.ChannelState) { state.PrevWeightTrigger = true })
+	}
+	if shouldPatch("case7", true, s) {
+		t.Fatal("Expected no patch while ch6 has not been weighed")
+	}
+
+	s.UpdateChannel("ch6", func(state *session.ChannelState) { state.PrevWeightTrigger = true })
+	if !shouldPatch("case7", true, s) {
+		t.Fatal("Expected patch once every channel has been weighed")
+	}
+
+	s.UpdateChannel("ch4", func(state *session.ChannelState) { state.WeightTrigger = true })
+	if shouldPatch("case8", true, s) {
+		t.Fatal("Expected no patch while ch4 is still weighing")
+	}
+}
+
+func TestResetWeightTriggers(t *testing.T) {
+	s := session.NewSession([]string{"head1", "head2"})
+	s.SetProcessing(true)
+	s.UpdateChannel("head1", func(state *session.ChannelState) { state.PrevWeightTrigger = true })
+	s.UpdateChannel("head2", func(state *session.ChannelState) { state.PrevWeightValue = 12.5 })
+
+	resetWeightTriggers(s)
+
+	if s.IsProcessing() {
+		t.Error("Expected IsProcessing to be reset")
+	}
+	for _, channel := range s.Channels() {
+		state := s.Channel(channel)
+		if state.PrevWeightTrigger || state.PrevWeightValue != 0 {
+			t.Errorf("Expected %s to be reset, got %+v", channel, state)
+		}
+	}
+}
+
+func TestCheckRecord(t *testing.T) {
+	cfg := config.AppConfig{
+		APIUrl:      "http://api/rest/v1/filling",
+		Function:    "PATCH",
+		StatusField: "status",
+		ReasonField: "validation_error",
+		RecordRules: map[string]validate.Rules{
+			"holdfilling": {Required: []string{"ch1_fill"}, MaxNulls: -1, OnInvalid: validate.ActionFlag},
+			"holdmcs":     {Required: []string{"ink_lot"}, MaxNulls: -1, OnInvalid: validate.ActionRoute, RouteAPIUrl: "http://api/rest/v1/rejected"},
+		},
+	}
+
+	// Default rules drop a record with more than 3 nulls
+	if _, ok := checkRecord("weight", map[string]any{"a": nil, "b": nil, "c": nil, "d": nil}, cfg); ok {
+		t.Error("Expected record to be dropped")
+	}
+
+	flagged := map[string]any{"ch2_fill": 1}
+	target, ok := checkRecord("holdfilling", flagged, cfg)
+	if !ok || target.apiUrl != cfg.APIUrl || target.routed {
+		t.Errorf("Expected flagged record to the normal endpoint, got %+v", target)
+	}
+	if flagged["status"] != "invalid" || flagged["validation_error"] != "missing required field ch1_fill" {
+		t.Errorf("Expected failure reason in record, got %v", flagged)
+	}
+
+	target, ok = checkRecord("holdmcs", map[string]any{}, cfg)
+	if !ok || !target.routed || target.apiUrl != "http://api/rest/v1/rejected" || target.function != "POST" {
+		t.Errorf("Expected record routed to the rejected endpoint, got %+v", target)
+	}
+}
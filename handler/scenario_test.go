@@ -0,0 +1,230 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gopatch/config"
+	"gopatch/internal/clock"
+	"gopatch/internal/cycle"
+	"gopatch/internal/session"
+	"gopatch/patch"
+)
+
+// Rewrite the golden files from the current output: go test ./handler -run TestScenarios -update
+var update = flag.Bool("update", false, "update the golden files of the scenario tests")
+
+// scenario is a test case read from testdata/scenarios/<name>.json
+type scenario struct {
+	Description  string            `json:"description"`
+	Env          map[string]string `json:"env"`           // Environment of the case, loaded with config.Load
+	IgnoreFields []string          `json:"ignore_fields"` // Record fields that differ per run
+	Response     *struct {
+		Status int             `json:"status"`
+		Body   json.RawMessage `json:"body"`
+	} `json:"response"` // Answer of the in-memory sink, default 201 []
+	Steps []struct {
+		AfterMs  int             `json:"after_ms"` // Advance the fake clock before sending the batch
+		Messages json.RawMessage `json:"messages"` // Batch as flushed by the MQTT client
+	} `json:"steps"`
+}
+
+// golden is the expected output of a scenario, testdata/scenarios/<name>.golden.json
+type golden struct {
+	Requests []sinkRequest `json:"requests"`
+	PLC      []plcWrite    `json:"plc,omitempty"`
+}
+
+type sinkRequest struct {
+	Method string         `json:"method"`
+	URL    string         `json:"url"`
+	Prefer string         `json:"prefer,omitempty"`
+	Cycle  string         `json:"cycle,omitempty"` // Correlation ID header
+	Body   map[string]any `json:"body"`
+}
+
+type plcWrite struct {
+	Device string `json:"device"`
+	Value  any    `json:"value"`
+	Cycle  string `json:"cycle,omitempty"`
+}
+
+// memorySink records every request instead of sending it
+type memorySink struct {
+	mu       sync.Mutex
+	status   int
+	body     []byte
+	requests []sinkRequest
+}
+
+func (m *memorySink) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, _ := io.ReadAll(req.Body)
+	var record map[string]any
+	if err := json.Unmarshal(body, &record); err != nil {
+		return nil, fmt.Errorf("sink received invalid JSON %s: %w", body, err)
+	}
+
+	m.mu.Lock()
+	m.requests = append(m.requests, sinkRequest{
+		Method: req.Method,
+		URL:    req.URL.String(),
+		Prefer: req.Header.Get("Prefer"),
+		Cycle:  req.Header.Get(patch.CorrelationHeader),
+		Body:   record,
+	})
+	status, respBody := m.status, m.body
+	m.mu.Unlock()
+
+	return &http.Response{
+		StatusCode: status,
+		Header:     make(http.Header),
+		Body:       io.NopCloser(bytes.NewReader(respBody)),
+		Request:    req,
+	}, nil
+}
+
+// fakePLC records every write instead of sending it
+type fakePLC struct {
+	mu     sync.Mutex
+	writes []plcWrite
+}
+
+func (f *fakePLC) WritePLC(ctx context.Context, deviceStr string, value any) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.writes = append(f.writes, plcWrite{Device: deviceStr, Value: value, Cycle: cycle.IDFrom(ctx)})
+	return nil
+}
+
+func TestScenarios(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "scenarios", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, file := range files {
+		if strings.HasSuffix(file, ".golden.json") {
+			continue
+		}
+		name := strings.TrimSuffix(filepath.Base(file), ".json")
+		t.Run(name, func(t *testing.T) {
+			runScenario(t, file, strings.TrimSuffix(file, ".json")+".golden.json")
+		})
+	}
+}
+
+func runScenario(t *testing.T, file, goldenFile string) {
+	raw, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sc scenario
+	if err := json.Unmarshal(raw, &sc); err != nil {
+		t.Fatalf("Invalid scenario %s: %v", file, err)
+	}
+
+	for key, value := range sc.Env {
+		t.Setenv(key, value)
+	}
+	config.Load()
+	cfg := config.GetAppConfig()
+
+	// Start from a clean state, every scenario runs in the same process
+	session.ClearSession(cfg.Function + "_" + cfg.Trigger)
+	deviceDurationMap = make(map[string]*durationState)
+	t.Cleanup(func() { session.ClearSession(cfg.Function + "_" + cfg.Trigger) })
+
+	sink := &memorySink{status: http.StatusCreated, body: []byte("[]")}
+	if sc.Response != nil {
+		sink.status, sink.body = sc.Response.Status, sc.Response.Body
+	}
+	patch.SetTransport(sink)
+	t.Cleanup(func() { patch.SetTransport(http.DefaultTransport) })
+	plc := &fakePLC{}
+	clk := clock.NewFake(time.Date(2025, 1, 1, 8, 0, 0, 0, time.UTC))
+
+	receivedMessagesJSONChan := make(chan string, 1)
+	for _, step := range sc.Steps {
+		clk.Advance(time.Duration(step.AfterMs) * time.Millisecond)
+		receivedMessagesJSONChan <- string(step.Messages)
+		ProcessMQTTData(cfg, receivedMessagesJSONChan, plc, clk)
+	}
+
+	got := golden{Requests: sink.requests, PLC: plc.writes}
+
+	// Cycle IDs are random, name them in order of appearance so shared IDs still show
+	cycles := map[string]string{}
+	cycleName := func(id string) string {
+		if id == "" {
+			return ""
+		}
+		if _, ok := cycles[id]; !ok {
+			cycles[id] = fmt.Sprintf("<cycle %d>", len(cycles)+1)
+		}
+		return cycles[id]
+	}
+	for i := range got.Requests {
+		req := &got.Requests[i]
+		req.Cycle = cycleName(req.Cycle)
+		if id, ok := req.Body[cfg.CycleIDField].(string); ok {
+			req.Body[cfg.CycleIDField] = cycleName(id)
+		}
+	}
+	for i := range got.PLC {
+		got.PLC[i].Cycle = cycleName(got.PLC[i].Cycle)
+	}
+
+	for _, req := range got.Requests {
+		for _, field := range sc.IgnoreFields {
+			if _, ok := req.Body[field]; ok {
+				req.Body[field] = "<ignored>"
+			}
+		}
+	}
+
+	if *update {
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(got); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(goldenFile, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	raw, err = os.ReadFile(goldenFile)
+	if err != nil {
+		t.Fatalf("Missing golden file, run with -update: %v", err)
+	}
+	var want golden
+	if err := json.Unmarshal(raw, &want); err != nil {
+		t.Fatalf("Invalid golden file %s: %v", goldenFile, err)
+	}
+
+	// Compare through JSON so numbers and nil slices match the golden file
+	var normalized golden
+	data, _ := json.Marshal(got)
+	_ = json.Unmarshal(data, &normalized)
+
+	if !reflect.DeepEqual(normalized, want) {
+		gotJSON, _ := json.MarshalIndent(normalized, "", "  ")
+		wantJSON, _ := json.MarshalIndent(want, "", "  ")
+		t.Errorf("%s\ngot:\n%s\nwant:\n%s", sc.Description, gotJSON, wantJSON)
+	}
+}
@@ -0,0 +1,101 @@
+package handler
+
+import (
+	"gopatch/config"
+	"gopatch/internal/clock"
+	"gopatch/internal/cycle"
+	"gopatch/internal/session"
+	"log/slog"
+	"time"
+)
+
+// Case keys that keep a session open across batches and can be abandoned mid-cycle
+var cycleCases = map[string]bool{
+	"hold":              true,
+	"special":           true,
+	"holdfilling":       true,
+	"weight":            true,
+	"holdfillingweight": true,
+	"holdmcs":           true,
+	"vacuum":            true,
+}
+
+// checkCycleTimeout tracks the cycle of a hold case, and abandons it once it
+// has been open longer than the configured CYCLE_TIMEOUT of the case.
+// Reports whether the cycle was abandoned.
+func checkCycleTimeout(session *session.Session, caseKey string, cfg config.AppConfig, clk clock.Clock) bool {
+	if !trackCycle(session, caseKey, clk) {
+		return false
+	}
+
+	timeout := cfg.CycleTimeoutFor(caseKey)
+	if timeout <= 0 {
+		return false
+	}
+	id, startedAt := session.Cycle()
+	elapsed := clk.Since(startedAt)
+	if elapsed < timeout {
+		return false
+	}
+
+	slog.WarnContext(cycle.WithID(parentContext(), id), "Cycle timed out, sending partial record",
+		"case", caseKey, "elapsed", elapsed.Round(time.Second))
+	abandonCycle(session, caseKey, cfg, clk)
+	return true
+}
+
+// cycleActive reports whether the session is in the middle of a cycle
+func cycleActive(session *session.Session) bool {
+	if session.IsProcessing() || session.PrevSealing() == 1 {
+		return true
+	}
+	// A channel already emitted keeps the cycle open until the other channels weighed too
+	for _, channel := range session.Channels() {
+		if state := session.Channel(channel); state.WeightTrigger || state.PrevWeightTrigger || state.Emitted {
+			return true
+		}
+	}
+	return false
+}
+
+// abandonCycle sends whatever the session collected so far flagged with status "timeout",
+// to TIMEOUT_API_URL when set or the sinks of the case otherwise, then resets the session.
+func abandonCycle(session *session.Session, caseKey string, cfg config.AppConfig, clk clock.Clock) {
+	target := recordTarget{}
+	if cfg.TimeoutAPIUrl != "" {
+		target = recordTarget{apiUrl: cfg.TimeoutAPIUrl, function: "POST", routed: true}
+	}
+	sendPartialRecord(session, caseKey, cycleTimeout, target, cfg, clk)
+	resetSession(session, caseKey, cycleTimeout)
+}
+
+// sendPartialRecord sends whatever the session collected so far with the given status.
+// Reports false when the session was empty and nothing was sent, a failed send is logged and returned.
+func sendPartialRecord(session *session.Session, caseKey, status string, target recordTarget, cfg config.AppConfig, clk clock.Clock) (bool, error) {
+	data := session.Merge(session.PayloadKeys()...)
+	if len(data) == 0 {
+		return false, nil
+	}
+
+	data[cfg.StatusField] = status
+	ctx := stampSessionCycle(session, caseKey, data, cfg, clk)
+
+	startTime := clk.Now()
+	if err := writeRecord(ctx, caseKey, data, target, cfg, nil, clk); err != nil {
+		slog.ErrorContext(ctx, "Failed to send partial record", "case", caseKey, "status", status, "err", err)
+		return true, err
+	}
+	logRecord(ctx, caseKey, data, clk.Since(startTime))
+	return true, nil
+}
+
+// resetSession clears every collected payload and flag, ready for the next cycle
+func resetSession(session *session.Session, caseKey, outcome string) {
+	session.ClearPayloads()
+	session.SetPrevSealing(0)
+	endCycle(session, caseKey, outcome)
+	resetWeightTriggers(session)
+	session.UpdateChannels(func(_ string, state *channelState) {
+		state.WeightTrigger = false
+	})
+}
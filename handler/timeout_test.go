@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gopatch/config"
+	"gopatch/internal/clock"
+	"gopatch/internal/session"
+)
+
+func TestCheckCycleTimeout(t *testing.T) {
+	var record map[string]any
+	var method string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method = r.Method
+		body, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(body, &record)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	cfg := config.AppConfig{
+		APIUrl:        "http://unused.invalid",
+		Function:      "PATCH",
+		TimeoutAPIUrl: server.URL,
+		StatusField:   "status",
+		CycleTimeouts: map[string]time.Duration{"holdfilling": 10 * time.Millisecond},
+	}
+
+	clk := clock.NewFake(time.Date(2025, 1, 1, 8, 0, 0, 0, time.UTC))
+	s := session.NewSession([]string{"ch1", "ch2"})
+	s.SetProcessing(true)
+	s.SetField("ch1", "ch1_fill", 1)
+
+	if checkCycleTimeout(s, "holdfilling", cfg, clk) {
+		t.Fatal("Expected first check to only start the cycle clock")
+	}
+	if _, startedAt := s.Cycle(); startedAt.IsZero() {
+		t.Fatal("Expected the cycle start to be set")
+	}
+	if checkCycleTimeout(s, "trigger", cfg, clk) {
+		t.Fatal("Expected cases without a session cycle to be ignored")
+	}
+
+	clk.Advance(20 * time.Millisecond)
+	if !checkCycleTimeout(s, "holdfilling", cfg, clk) {
+		t.Fatal("Expected cycle to time out")
+	}
+
+	if method != http.MethodPost {
+		t.Errorf("Expected POST to the dead-letter endpoint, got %s", method)
+	}
+	if record["status"] != "timeout" || record["ch1_fill"] != float64(1) {
+		t.Errorf("Unexpected partial record: %v", record)
+	}
+	if _, startedAt := s.Cycle(); s.IsProcessing() || !startedAt.IsZero() || len(s.Payload("ch1")) != 0 {
+		t.Errorf("Expected session to be reset, got %+v", s)
+	}
+}
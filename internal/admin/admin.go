@@ -0,0 +1,148 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"gopatch/config"
+	"gopatch/handler"
+	"gopatch/internal/clock"
+	"gopatch/internal/session"
+)
+
+// Server is the admin HTTP API to inspect and control the live sessions:
+//
+//	GET  /sessions                   every session with its flags, channel states and payloads
+//	GET  /sessions/{caseKey}         one session
+//	POST /sessions/{caseKey}/emit    send what the session collected with status "forced", then reset it
+//	POST /sessions/{caseKey}/reset   drop what the session collected without sending it
+//	GET  /payloads                   latest value of every address seen
+//	GET  /processing                 whether processing is paused
+//	POST /processing/pause           stop running batches through the handlers
+//	POST /processing/resume          run batches through the handlers again
+//
+// Every request needs the header "Authorization: Bearer <token>".
+type Server struct {
+	token string
+	cfg   config.AppConfig
+	clk   clock.Clock
+	mux   *http.ServeMux
+}
+
+// New creates the admin API, force-emitted records are sent with cfg
+func New(token string, cfg config.AppConfig, clk clock.Clock) *Server {
+	s := &Server{token: token, cfg: cfg, clk: clk, mux: http.NewServeMux()}
+	s.mux.HandleFunc("GET /sessions", s.listSessions)
+	s.mux.HandleFunc("GET /sessions/{caseKey}", s.getSession)
+	s.mux.HandleFunc("POST /sessions/{caseKey}/emit", s.emitSession)
+	s.mux.HandleFunc("POST /sessions/{caseKey}/reset", s.resetSession)
+	s.mux.HandleFunc("GET /payloads", s.latestPayloads)
+	s.mux.HandleFunc("GET /processing", s.processing)
+	s.mux.HandleFunc("POST /processing/pause", s.pause)
+	s.mux.HandleFunc("POST /processing/resume", s.resume)
+	return s
+}
+
+// Start serves the admin API on addr in the background, it refuses to run without a token
+func Start(addr, token string, cfg config.AppConfig, clk clock.Clock) (*http.Server, error) {
+	if token == "" {
+		return nil, errors.New("ADMIN_TOKEN is required to enable the admin API")
+	}
+	srv := &http.Server{Addr: addr, Handler: New(token, cfg, clk)}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Admin API stopped", "err", err)
+		}
+	}()
+	return srv, nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+		writeError(w, http.StatusUnauthorized, errors.New("invalid admin token"))
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) listSessions(w http.ResponseWriter, r *http.Request) {
+	sessions := make(map[string]*session.Session)
+	for _, caseKey := range session.CaseKeys() {
+		if sess, ok := session.GetSession(caseKey); ok {
+			sessions[caseKey] = sess
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"paused": handler.Paused(), "sessions": sessions})
+}
+
+func (s *Server) getSession(w http.ResponseWriter, r *http.Request) {
+	sess, ok := session.GetSession(r.PathValue("caseKey"))
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no session %q", r.PathValue("caseKey")))
+		return
+	}
+	writeJSON(w, http.StatusOK, sess)
+}
+
+func (s *Server) emitSession(w http.ResponseWriter, r *http.Request) {
+	caseKey := r.PathValue("caseKey")
+	if _, ok := session.GetSession(caseKey); !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no session %q", caseKey))
+		return
+	}
+	sent, err := handler.ForceEmit(caseKey, s.cfg, s.clk)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	slog.Info("Admin force-emitted session", "session", caseKey, "sent", sent)
+	writeJSON(w, http.StatusOK, map[string]any{"sent": sent})
+}
+
+func (s *Server) resetSession(w http.ResponseWriter, r *http.Request) {
+	caseKey := r.PathValue("caseKey")
+	if err := handler.ResetSession(caseKey); err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	slog.Info("Admin reset session", "session", caseKey)
+	writeJSON(w, http.StatusOK, map[string]any{"reset": true})
+}
+
+func (s *Server) latestPayloads(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, handler.LatestPayloads())
+}
+
+func (s *Server) processing(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"paused": handler.Paused()})
+}
+
+func (s *Server) pause(w http.ResponseWriter, r *http.Request) {
+	handler.Pause()
+	slog.Info("Admin paused processing")
+	s.processing(w, r)
+}
+
+func (s *Server) resume(w http.ResponseWriter, r *http.Request) {
+	handler.Resume()
+	slog.Info("Admin resumed processing")
+	s.processing(w, r)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("Failed to write admin response", "err", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,128 @@
+package admin
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gopatch/config"
+	"gopatch/handler"
+	"gopatch/internal/clock"
+	"gopatch/internal/session"
+)
+
+const caseKey = "PATCH_d800,holdfilling"
+
+func do(t *testing.T, srv *Server, method, path, token string) (int, map[string]any) {
+	t.Helper()
+	req := httptest.NewRequest(method, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+
+	var body map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("%s %s: invalid JSON %q", method, path, rec.Body.String())
+	}
+	return rec.Code, body
+}
+
+func TestRequiresToken(t *testing.T) {
+	srv := New("secret", config.AppConfig{}, clock.Real)
+	for _, token := range []string{"", "wrong"} {
+		if code, _ := do(t, srv, http.MethodGet, "/sessions", token); code != http.StatusUnauthorized {
+			t.Errorf("Expected 401 with token %q, got %d", token, code)
+		}
+	}
+	if code, _ := do(t, srv, http.MethodGet, "/sessions", "secret"); code != http.StatusOK {
+		t.Errorf("Expected 200 with the token, got %d", code)
+	}
+	if _, err := Start(":0", "", config.AppConfig{}, clock.Real); err == nil {
+		t.Error("Expected the admin API to refuse to start without a token")
+	}
+}
+
+func TestSessions(t *testing.T) {
+	t.Cleanup(func() { session.ClearSession(caseKey) })
+	s := session.GetOrCreateSession(caseKey, []string{"ch1"})
+	s.SetProcessing(true)
+	s.SetField("ch1", "ch1_fill", 1.5)
+
+	srv := New("secret", config.AppConfig{}, clock.Real)
+	code, body := do(t, srv, http.MethodGet, "/sessions", "secret")
+	sessions, _ := body["sessions"].(map[string]any)
+	state, _ := sessions[caseKey].(map[string]any)
+	if code != http.StatusOK || state["is_processing"] != true {
+		t.Fatalf("Expected the session in the list, got %d %v", code, body)
+	}
+
+	code, body = do(t, srv, http.MethodGet, "/sessions/"+caseKey, "secret")
+	payloads, _ := body["processed_payloads"].(map[string]any)
+	ch1, _ := payloads["ch1"].(map[string]any)
+	if code != http.StatusOK || ch1["ch1_fill"] != 1.5 {
+		t.Errorf("Expected the ch1 payload, got %d %v", code, body)
+	}
+
+	if code, _ := do(t, srv, http.MethodGet, "/sessions/unknown", "secret"); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown session, got %d", code)
+	}
+
+	if code, _ := do(t, srv, http.MethodPost, "/sessions/"+caseKey+"/reset", "secret"); code != http.StatusOK {
+		t.Fatalf("Expected reset to succeed, got %d", code)
+	}
+	if s.IsProcessing() || len(s.Payload("ch1")) != 0 {
+		t.Errorf("Expected the session to be reset")
+	}
+}
+
+func TestForceEmit(t *testing.T) {
+	var record map[string]any
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(body, &record)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer api.Close()
+
+	t.Cleanup(func() { session.ClearSession(caseKey) })
+	s := session.GetOrCreateSession(caseKey, []string{"ch1"})
+	s.SetProcessing(true)
+	s.SetField("ch1", "ch1_fill", 1.5)
+
+	cfg := config.AppConfig{APIUrl: api.URL, Function: "POST", StatusField: "status", CycleIDField: "cycle_id"}
+	srv := New("secret", cfg, clock.NewFake(time.Date(2025, 1, 1, 8, 0, 0, 0, time.UTC)))
+	code, body := do(t, srv, http.MethodPost, "/sessions/"+caseKey+"/emit", "secret")
+	if code != http.StatusOK || body["sent"] != true {
+		t.Fatalf("Expected the record to be sent, got %d %v", code, body)
+	}
+	if record["status"] != "forced" || record["ch1_fill"] != 1.5 || record["cycle_id"] == "" {
+		t.Errorf("Unexpected forced record %v", record)
+	}
+	if s.IsProcessing() || len(s.Payload("ch1")) != 0 {
+		t.Errorf("Expected the session to be reset after the emit")
+	}
+
+	if _, body := do(t, srv, http.MethodPost, "/sessions/"+caseKey+"/emit", "secret"); body["sent"] != false {
+		t.Errorf("Expected nothing to send from an empty session, got %v", body)
+	}
+}
+
+func TestPauseResume(t *testing.T) {
+	t.Cleanup(handler.Resume)
+	srv := New("secret", config.AppConfig{}, clock.Real)
+
+	if _, body := do(t, srv, http.MethodPost, "/processing/pause", "secret"); body["paused"] != true || !handler.Paused() {
+		t.Fatalf("Expected processing to be paused, got %v", body)
+	}
+	if _, body := do(t, srv, http.MethodPost, "/processing/resume", "secret"); body["paused"] != false || handler.Paused() {
+		t.Fatalf("Expected processing to be resumed, got %v", body)
+	}
+	if code, _ := do(t, srv, http.MethodGet, "/payloads", "secret"); code != http.StatusOK {
+		t.Errorf("Expected the latest payloads, got %d", code)
+	}
+}
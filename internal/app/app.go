@@ -4,39 +4,66 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"log/slog"
 	"strconv"
 	"strings"
+	"time"
 
 	"gopatch/config"
+	"gopatch/internal/cycle"
+	"gopatch/internal/dryrun"
+	"gopatch/internal/metrics"
 
 	MCP "github.com/mochigome-git/msp-go/pkg/mcp"
 	PLC "github.com/mochigome-git/msp-go/pkg/plc"
 	PLC_Utils "github.com/mochigome-git/msp-go/pkg/utils"
 )
 
+// PLCWriter writes a value to a PLC device given as "Type,Number,ProcessNumber,Registers";
+// implemented by Application, and by fakes in tests
+type PLCWriter interface {
+	WritePLC(ctx context.Context, deviceStr string, value any) error
+}
+
 // Application is the main application for interacting with the PLC
 type Application struct {
 	cfg    config.PlcConfig
-	logger *log.Logger
+	logger *slog.Logger
+	plcLog *log.Logger // For the msp-go client, which takes a standard logger
 	client MCP.Client
 	fx     bool
+	dryRun *dryrun.Logger // Log PLC frames instead of writing them when set
 }
 
 // NewApplication initializes the PLC client and creates a new Application instance
-func NewApplication(cfg config.PlcConfig, logger *log.Logger) (*Application, error) {
+func NewApplication(cfg config.PlcConfig, logger *slog.Logger) (*Application, error) {
 	// Init PLC connection
 	if err := PLC.InitMSPClient(cfg.PlcHost, cfg.PlcPort); err != nil {
 		return nil, fmt.Errorf("init PLC failed: %w", err)
 	}
-	logger.Printf("Start communicating with PLC at %s", cfg.PlcHost)
+	logger.Info("Start communicating with PLC", "host", cfg.PlcHost, "port", cfg.PlcPort)
 
 	return &Application{
 		cfg:    cfg,
 		logger: logger,
+		plcLog: slog.NewLogLogger(logger.Handler(), slog.LevelInfo),
 		//fx:     cfg.Fx, // Use Fx from config if needed
 	}, nil
 }
 
+// NewDryRunApplication creates an Application that logs every PLC frame to dryRun
+// instead of connecting to and writing the PLC
+func NewDryRunApplication(cfg config.PlcConfig, logger *slog.Logger, dryRun *dryrun.Logger) *Application {
+	logger.Info("Dry-run: PLC writes are logged only", "host", cfg.PlcHost)
+
+	return &Application{
+		cfg:    cfg,
+		logger: logger,
+		plcLog: slog.NewLogLogger(logger.Handler(), slog.LevelInfo),
+		dryRun: dryRun,
+	}
+}
+
 // Close cleanly disconnects from the PLC
 func (a *Application) Close() error {
 	if a.client == nil {
@@ -45,7 +72,7 @@ func (a *Application) Close() error {
 	if err := a.client.Close(); err != nil {
 		return fmt.Errorf("failed to close PLC connection: %w", err)
 	}
-	a.logger.Println("PLC connection closed")
+	a.logger.Info("PLC connection closed")
 	return nil
 }
 
@@ -55,8 +82,17 @@ func (a *Application) writeDataWithContext(ctx context.Context, device PLC_Utils
 	case <-ctx.Done():
 		return ctx.Err()
 	default:
+		if a.dryRun != nil {
+			a.dryRun.Log(dryrun.Entry{
+				Kind:   "plc",
+				Cycle:  cycle.IDFrom(ctx),
+				Device: device.DeviceType + device.DeviceNumber,
+				Frame:  fmt.Sprintf("% X", data),
+			})
+			return nil
+		}
 		// Call your WriteData method directly
-		return PLC.BatchWrite(device.DeviceType, device.DeviceNumber, data, device.NumberRegisters, a.logger)
+		return PLC.BatchWrite(device.DeviceType, device.DeviceNumber, data, device.NumberRegisters, a.plcLog)
 	}
 }
 
@@ -92,9 +128,12 @@ func (a *Application) WritePLC(ctx context.Context, deviceStr string, value any)
 			NumberRegisters: uint16(numberRegisters),
 		}
 
-		a.logger.Printf("Writing to %s%s: % X", deviceType, deviceNumber, data)
+		a.logger.DebugContext(ctx, "Writing to PLC", "device", deviceType+deviceNumber, "frame", fmt.Sprintf("% X", data))
 
-		if err := a.writeDataWithContext(ctx, device, data); err != nil {
+		startTime := time.Now()
+		err = a.writeDataWithContext(ctx, device, data)
+		metrics.ObservePLCWrite(time.Since(startTime), err)
+		if err != nil {
 			return fmt.Errorf("failed to write PLC data: %w", err)
 		}
 		return nil
@@ -0,0 +1,155 @@
+// Package auth authorizes the API requests of an endpoint: a static key, basic auth, a custom
+// header, an OAuth2 client credentials token or a self-signed JWT, see config.AuthProfile
+package auth
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gopatch/config"
+	"gopatch/internal/clock"
+	"gopatch/patch"
+)
+
+// client sends the token requests, see SetClient
+var client atomic.Pointer[http.Client]
+
+func init() {
+	client.Store(&http.Client{Timeout: 30 * time.Second})
+}
+
+// SetClient replaces the client of the token requests, e.g. with the API client so its proxy and mTLS apply
+func SetClient(c *http.Client) {
+	client.Store(c)
+}
+
+// SetTransport replaces the transport of the token requests, keeping the client timeout;
+// used to stub the token endpoint, e.g. in dry-run
+func SetTransport(rt http.RoundTripper) {
+	c := *client.Load()
+	c.Transport = rt
+	client.Store(&c)
+}
+
+var (
+	providersMu sync.Mutex
+	providers   = map[config.AuthProfile]patch.Authorizer{}
+)
+
+// For returns the provider of an auth profile, shared by every endpoint with the same profile
+// so a token is fetched once for all of them
+func For(p config.AuthProfile) (patch.Authorizer, error) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	if a, ok := providers[p]; ok {
+		return a, nil
+	}
+
+	var a patch.Authorizer
+	switch p.Type {
+	case config.AuthKey:
+		a = ServiceKey{Key: p.Key}
+	case config.AuthBasic:
+		a = Basic{User: p.User, Password: p.Password}
+	case config.AuthHeader:
+		name, value, ok := strings.Cut(p.Header, ":")
+		if name = strings.TrimSpace(name); !ok || name == "" {
+			return nil, fmt.Errorf("invalid auth header %q, expected Name: value", p.Header)
+		}
+		a = Header{Name: name, Value: strings.TrimSpace(value)}
+	case config.AuthOAuth2:
+		if p.TokenURL == "" || p.ClientID == "" {
+			return nil, fmt.Errorf("oauth2 auth needs a token URL and a client ID")
+		}
+		a = &OAuth2{TokenURL: p.TokenURL, ClientID: p.ClientID, ClientSecret: p.ClientSecret, Scopes: p.Scopes,
+			Audience: p.Audience, RefreshBefore: p.RefreshBefore, Clock: clock.Real}
+	case config.AuthJWT:
+		j, err := NewJWT(p.Algorithm, p.SigningKey)
+		if err != nil {
+			return nil, err
+		}
+		j.Issuer, j.Subject, j.Audience = p.Issuer, p.Subject, p.Audience
+		j.TTL, j.RefreshBefore, j.Clock = p.TTL, p.RefreshBefore, clock.Real
+		a = j
+	default:
+		return nil, fmt.Errorf("unknown auth type %q, expected key, basic, header, oauth2 or jwt", p.Type)
+	}
+	providers[p] = a
+	return a, nil
+}
+
+// ServiceKey sends Key as apikey and bearer token, like SERVICE_ROLE_KEY
+type ServiceKey struct {
+	Key string
+}
+
+func (k ServiceKey) Authorize(_ context.Context, req *http.Request) error {
+	req.Header.Set("apikey", k.Key)
+	req.Header.Set("Authorization", "Bearer "+k.Key)
+	return nil
+}
+
+// Basic sends HTTP basic auth
+type Basic struct {
+	User     string
+	Password string
+}
+
+func (b Basic) Authorize(_ context.Context, req *http.Request) error {
+	req.SetBasicAuth(b.User, b.Password)
+	return nil
+}
+
+// Header sends a fixed header, e.g. X-API-Key
+type Header struct {
+	Name  string
+	Value string
+}
+
+func (h Header) Authorize(_ context.Context, req *http.Request) error {
+	req.Header.Set(h.Name, h.Value)
+	return nil
+}
+
+// token is a bearer token renewed before it expires
+type token struct {
+	mu        sync.Mutex
+	value     string
+	expires   time.Time // Zero when it doesn't expire
+	refreshAt time.Time
+}
+
+// get returns the token, renewing it with fetch once it is due: refreshBefore its expiry, or halfway
+// through a shorter lifetime. A failed renewal keeps the token while it is still valid.
+func (t *token) get(ctx context.Context, clk clock.Clock, refreshBefore time.Duration,
+	fetch func(ctx context.Context, now time.Time) (string, time.Time, error)) (string, error) {
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := clk.Now()
+	if t.value != "" && (t.expires.IsZero() || now.Before(t.refreshAt)) {
+		return t.value, nil
+	}
+
+	value, expires, err := fetch(ctx, now)
+	if err != nil {
+		if t.value != "" && now.Before(t.expires) {
+			slog.WarnContext(ctx, "Failed to renew the API token, using the current one", "expires", t.expires, "err", err)
+			return t.value, nil
+		}
+		return "", err
+	}
+
+	t.value, t.expires, t.refreshAt = value, expires, expires
+	if !expires.IsZero() {
+		t.refreshAt = expires.Add(-min(refreshBefore, expires.Sub(now)/2))
+	}
+	return value, nil
+}
@@ -0,0 +1,219 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gopatch/config"
+	"gopatch/internal/clock"
+	"gopatch/patch"
+)
+
+func newClock() *clock.Fake {
+	return clock.NewFake(time.Date(2025, 1, 1, 8, 0, 0, 0, time.UTC))
+}
+
+// bearer authorizes a request and returns its bearer token
+func bearer(t *testing.T, a patch.Authorizer) string {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodPost, "http://api.local", nil)
+	if err := a.Authorize(context.Background(), req); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		t.Fatalf("Expected a bearer token, got %v", req.Header)
+	}
+	return token
+}
+
+func TestOAuth2Refresh(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		fetches int
+		failing bool
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		r.ParseForm()
+		if r.Form.Get("grant_type") != "client_credentials" || r.Form.Get("client_id") != "gopatch" ||
+			r.Form.Get("client_secret") != "secret" || r.Form.Get("scope") != "records.write" {
+			t.Errorf("Unexpected token request %v", r.Form)
+		}
+		if failing {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fetches++
+		json.NewEncoder(w).Encode(map[string]any{"access_token": "token-" + string(rune('0'+fetches)), "expires_in": 120})
+	}))
+	defer server.Close()
+
+	clk := newClock()
+	o := &OAuth2{TokenURL: server.URL, ClientID: "gopatch", ClientSecret: "secret", Scopes: "records.write",
+		RefreshBefore: time.Minute, Clock: clk}
+
+	if got := bearer(t, o); got != "token-1" {
+		t.Errorf("Expected token-1, got %s", got)
+	}
+	clk.Advance(50 * time.Second)
+	if got := bearer(t, o); got != "token-1" {
+		t.Errorf("Expected token-1 reused, got %s", got)
+	}
+
+	// Renewed a minute before it expires, not once it failed
+	clk.Advance(20 * time.Second)
+	if got := bearer(t, o); got != "token-2" {
+		t.Errorf("Expected token-2 before the expiry, got %s", got)
+	}
+
+	// A failed renewal keeps the token while it is valid
+	mu.Lock()
+	failing = true
+	mu.Unlock()
+	clk.Advance(90 * time.Second)
+	if got := bearer(t, o); got != "token-2" {
+		t.Errorf("Expected token-2 kept, got %s", got)
+	}
+	clk.Advance(time.Minute)
+	req, _ := http.NewRequest(http.MethodPost, "http://api.local", nil)
+	err := o.Authorize(context.Background(), req)
+	var statusErr *patch.StatusError
+	if !errors.As(err, &statusErr) || !statusErr.Temporary() {
+		t.Errorf("Expected a temporary status error once expired, got %v", err)
+	}
+}
+
+// claims decodes the claims of a JWT and returns its signing input and signature
+func claims(t *testing.T, token string) (map[string]any, string, []byte) {
+	t.Helper()
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("Expected a JWT, got %s", token)
+	}
+	payload, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	var c map[string]any
+	if err := json.Unmarshal(payload, &c); err != nil {
+		t.Fatal(err)
+	}
+	signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+	return c, parts[0] + "." + parts[1], signature
+}
+
+func TestJWT(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	rsaDER, _ := x509.MarshalPKCS8PrivateKey(rsaKey)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ecDER, _ := x509.MarshalECPrivateKey(ecKey)
+
+	tests := []struct {
+		algorithm string
+		key       string
+		verify    func(input string, signature []byte) bool
+	}{
+		{"HS256", "secret", func(input string, signature []byte) bool {
+			mac := hmac.New(sha256.New, []byte("secret"))
+			mac.Write([]byte(input))
+			return hmac.Equal(mac.Sum(nil), signature)
+		}},
+		{"RS256", string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: rsaDER})), func(input string, signature []byte) bool {
+			digest := sha256.Sum256([]byte(input))
+			return rsa.VerifyPKCS1v15(&rsaKey.PublicKey, crypto.SHA256, digest[:], signature) == nil
+		}},
+		{"ES256", string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: ecDER})), func(input string, signature []byte) bool {
+			digest := sha256.Sum256([]byte(input))
+			r, s := new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])
+			return len(signature) == 64 && ecdsa.Verify(&ecKey.PublicKey, digest[:], r, s)
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.algorithm, func(t *testing.T) {
+			j, err := NewJWT(tt.algorithm, tt.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			clk := newClock()
+			j.Issuer, j.Audience, j.TTL, j.RefreshBefore, j.Clock = "gopatch", "records", 5*time.Minute, time.Minute, clk
+
+			token := bearer(t, j)
+			c, input, signature := claims(t, token)
+			if !tt.verify(input, signature) {
+				t.Error("Invalid signature")
+			}
+			if c["iss"] != "gopatch" || c["aud"] != "records" || c["exp"].(float64)-c["iat"].(float64) != 300 {
+				t.Errorf("Unexpected claims %v", c)
+			}
+
+			clk.Advance(3 * time.Minute)
+			if bearer(t, j) != token {
+				t.Error("Expected the token reused")
+			}
+			clk.Advance(90 * time.Second)
+			if bearer(t, j) == token {
+				t.Error("Expected a new token a minute before the expiry")
+			}
+		})
+	}
+
+	if _, err := NewJWT("RS256", "not a key"); err == nil {
+		t.Error("Expected an error for a key that isn't PEM")
+	}
+	if _, err := NewJWT("ES256", tests[1].key); err == nil {
+		t.Error("Expected an error for an RSA key with ES256")
+	}
+}
+
+func TestFor(t *testing.T) {
+	tests := []struct {
+		profile config.AuthProfile
+		header  string
+		want    string
+	}{
+		{config.AuthProfile{Type: config.AuthKey, Key: "key"}, "apikey", "key"},
+		{config.AuthProfile{Type: config.AuthBasic, User: "line1", Password: "pw"}, "Authorization", "Basic bGluZTE6cHc="},
+		{config.AuthProfile{Type: config.AuthHeader, Header: "X-API-Key: partner-key"}, "X-API-Key", "partner-key"},
+	}
+	for _, tt := range tests {
+		a, err := For(tt.profile)
+		if err != nil {
+			t.Fatalf("Unexpected error for %+v: %v", tt.profile, err)
+		}
+		req, _ := http.NewRequest(http.MethodPost, "http://api.local", nil)
+		a.Authorize(context.Background(), req)
+		if got := req.Header.Get(tt.header); got != tt.want {
+			t.Errorf("Expected %s %q for %s, got %q", tt.header, tt.want, tt.profile.Type, got)
+		}
+	}
+
+	p := config.AuthProfile{Type: config.AuthOAuth2, TokenURL: "https://login.local/token", ClientID: "gopatch"}
+	a, _ := For(p)
+	if b, _ := For(p); a != b {
+		t.Error("Expected one provider per profile, so the token is shared")
+	}
+
+	for _, p := range []config.AuthProfile{{Type: "kerberos"}, {Type: config.AuthHeader, Header: "X-API-Key"}, {Type: config.AuthOAuth2}} {
+		if _, err := For(p); err == nil {
+			t.Errorf("Expected an error for %+v", p)
+		}
+	}
+}
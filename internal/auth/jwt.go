@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"time"
+
+	"gopatch/internal/clock"
+)
+
+// JWT sends a bearer JWT signed with a local key, lasting TTL and signed again RefreshBefore it expires
+type JWT struct {
+	Algorithm     string // HS256, RS256 or ES256
+	Issuer        string // Optional claims
+	Subject       string
+	Audience      string
+	TTL           time.Duration
+	RefreshBefore time.Duration
+	Clock         clock.Clock
+
+	key   any // []byte for HS256, *rsa.PrivateKey for RS256, *ecdsa.PrivateKey for ES256
+	token token
+}
+
+// NewJWT returns a JWT signing with key: the secret for HS256, a PEM private key
+// (PKCS#8, PKCS#1 or SEC 1) for RS256 and ES256
+func NewJWT(algorithm, key string) (*JWT, error) {
+	j := &JWT{Algorithm: algorithm}
+	if algorithm == "HS256" {
+		if key == "" {
+			return nil, fmt.Errorf("jwt auth needs a signing key")
+		}
+		j.key = []byte(key)
+		return j, nil
+	}
+
+	block, _ := pem.Decode([]byte(key))
+	if block == nil {
+		return nil, fmt.Errorf("jwt signing key for %s is not PEM", algorithm)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		if parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+			if parsed, err = x509.ParseECPrivateKey(block.Bytes); err != nil {
+				return nil, fmt.Errorf("invalid jwt signing key: %w", err)
+			}
+		}
+	}
+
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		if algorithm != "RS256" {
+			return nil, fmt.Errorf("jwt signing key is RSA, expected algorithm RS256, got %q", algorithm)
+		}
+		j.key = k
+	case *ecdsa.PrivateKey:
+		if algorithm != "ES256" || k.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("jwt signing key is ECDSA, expected algorithm ES256 with a P-256 key, got %q", algorithm)
+		}
+		j.key = k
+	default:
+		return nil, fmt.Errorf("unsupported jwt signing key %T", parsed)
+	}
+	return j, nil
+}
+
+func (j *JWT) Authorize(ctx context.Context, req *http.Request) error {
+	t, err := j.token.get(ctx, j.Clock, j.RefreshBefore, j.sign)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+t)
+	return nil
+}
+
+// sign returns a new token issued at now
+func (j *JWT) sign(_ context.Context, now time.Time) (string, time.Time, error) {
+	expires := now.Add(j.TTL)
+	header, _ := json.Marshal(map[string]string{"alg": j.Algorithm, "typ": "JWT"})
+	claims, _ := json.Marshal(struct {
+		Issuer    string `json:"iss,omitempty"`
+		Subject   string `json:"sub,omitempty"`
+		Audience  string `json:"aud,omitempty"`
+		IssuedAt  int64  `json:"iat"`
+		ExpiresAt int64  `json:"exp"`
+	}{j.Issuer, j.Subject, j.Audience, now.Unix(), expires.Unix()})
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signingInput))
+
+	var signature []byte
+	switch k := j.key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signingInput))
+		signature = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		var err error
+		if signature, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:]); err != nil {
+			return "", time.Time{}, fmt.Errorf("failed to sign jwt: %w", err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		if err != nil {
+			return "", time.Time{}, fmt.Errorf("failed to sign jwt: %w", err)
+		}
+		// JWS wants r and s as fixed 32-byte big-endian values, not ASN.1
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), expires, nil
+}
@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"gopatch/internal/clock"
+	"gopatch/patch"
+)
+
+// OAuth2 sends the bearer token of the OAuth2 client credentials grant, fetched from TokenURL
+// and renewed RefreshBefore it expires
+type OAuth2 struct {
+	TokenURL      string
+	ClientID      string
+	ClientSecret  string
+	Scopes        string // Space separated, optional
+	Audience      string // Optional, for providers that scope tokens by audience
+	RefreshBefore time.Duration
+	Clock         clock.Clock
+
+	token token
+}
+
+func (o *OAuth2) Authorize(ctx context.Context, req *http.Request) error {
+	t, err := o.token.get(ctx, o.Clock, o.RefreshBefore, o.fetch)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+t)
+	return nil
+}
+
+// fetch requests a new token
+func (o *OAuth2) fetch(ctx context.Context, now time.Time) (string, time.Time, error) {
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {o.ClientID},
+		"client_secret": {o.ClientSecret},
+	}
+	if o.Scopes != "" {
+		form.Set("scope", o.Scopes)
+	}
+	if o.Audience != "" {
+		form.Set("audience", o.Audience)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to create token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Load().Do(req)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to request token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to read token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", time.Time{}, fmt.Errorf("token request failed: %w", &patch.StatusError{Code: resp.StatusCode, Body: string(body)})
+	}
+
+	var result struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to parse token response: %w", err)
+	}
+	if result.AccessToken == "" {
+		return "", time.Time{}, fmt.Errorf("token response without access_token")
+	}
+
+	// Counted from before the request, so the token is renewed early rather than late
+	var expires time.Time
+	if result.ExpiresIn > 0 {
+		expires = now.Add(time.Duration(result.ExpiresIn) * time.Second)
+	}
+	return result.AccessToken, expires, nil
+}
@@ -0,0 +1,46 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2025, 1, 1, 8, 0, 0, 0, time.UTC)
+	f := NewFake(start)
+
+	f.Sleep(10 * time.Minute)
+	if got := f.Since(start); got != 10*time.Minute {
+		t.Errorf("Expected 10m after Sleep, got %s", got)
+	}
+
+	f.Set(start)
+	if !f.Now().Equal(start.Add(10 * time.Minute)) {
+		t.Errorf("Expected Set not to go backwards, got %s", f.Now())
+	}
+
+	ticker := f.NewTicker(time.Second)
+	select {
+	case <-ticker.C():
+		t.Fatal("Unexpected tick before the period elapsed")
+	default:
+	}
+
+	f.Advance(1500 * time.Millisecond)
+	select {
+	case tick := <-ticker.C():
+		if !tick.Equal(f.Now()) {
+			t.Errorf("Expected tick at %s, got %s", f.Now(), tick)
+		}
+	default:
+		t.Fatal("Expected a tick after 1.5s")
+	}
+
+	ticker.Stop()
+	f.Advance(time.Minute)
+	select {
+	case <-ticker.C():
+		t.Fatal("Unexpected tick after Stop")
+	default:
+	}
+}
@@ -0,0 +1,30 @@
+package cycle
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+)
+
+// ctxKey carries the cycle ID in a context. The cycle ID ties a record to the PLC writes,
+// requests and log lines of the case cycle that produced it.
+type ctxKey struct{}
+
+// NewID returns a new unique cycle ID
+func NewID() string {
+	return uuid.New().String()
+}
+
+// WithID returns a copy of ctx carrying the cycle ID
+func WithID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, id)
+}
+
+// IDFrom returns the cycle ID carried by ctx, or "" when there is none
+func IDFrom(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(ctxKey{}).(string)
+	return id
+}
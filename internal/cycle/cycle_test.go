@@ -0,0 +1,23 @@
+package cycle
+
+import (
+	"context"
+	"testing"
+)
+
+func TestContextID(t *testing.T) {
+	ctx := context.Background()
+	if IDFrom(ctx) != "" {
+		t.Fatal("Expected no cycle ID in a plain context")
+	}
+
+	id := NewID()
+	if id == "" || id == NewID() {
+		t.Fatalf("Expected unique cycle IDs, got %q", id)
+	}
+
+	ctx = WithID(ctx, id)
+	if IDFrom(ctx) != id {
+		t.Errorf("Expected %q, got %q", id, IDFrom(ctx))
+	}
+}
@@ -0,0 +1,180 @@
+package deadletter
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Entry is a record that could not be written, with why
+type Entry struct {
+	ID       string          `json:"id"`
+	Time     time.Time       `json:"time"`
+	Cycle    string          `json:"cycle,omitempty"`
+	Case     string          `json:"case"`
+	Target   json.RawMessage `json:"target,omitempty"` // Where the record went, opaque to the file
+	Data     map[string]any  `json:"data"`
+	Error    string          `json:"error"`
+	Status   int             `json:"status,omitempty"`   // HTTP status of the rejection, 0 when there was no response
+	Response string          `json:"response,omitempty"` // Response body of the rejection
+}
+
+// Append adds an entry to the dead-letter file at path, giving it an ID and time when it has none
+func Append(path string, e Entry) error {
+	if e.ID == "" {
+		e.ID = uuid.NewString()[:8]
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to encode dead letter: %w", err)
+	}
+
+	file, err := openLocked(path)
+	if err != nil {
+		return err
+	}
+	defer closeLocked(file)
+
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write dead letter: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync dead letter: %w", err)
+	}
+	return nil
+}
+
+// openLocked opens path for appending and locks it. A rewrite may replace the file while
+// we wait for the lock, so open again until the locked file is the one at path.
+func openLocked(path string) (*os.File, error) {
+	for {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open dead-letter file: %w", err)
+		}
+		if err := lock(file); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to lock dead-letter file: %w", err)
+		}
+
+		locked, err1 := file.Stat()
+		current, err2 := os.Stat(path)
+		if err1 == nil && err2 == nil && os.SameFile(locked, current) {
+			return file, nil
+		}
+		closeLocked(file)
+	}
+}
+
+func closeLocked(file *os.File) {
+	unlock(file)
+	file.Close()
+}
+
+// Read loads every entry of the dead-letter file, a missing file has none
+func Read(path string) ([]Entry, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open dead-letter file: %w", err)
+	}
+	defer file.Close()
+	return readEntries(file)
+}
+
+func readEntries(file *os.File) ([]Entry, error) {
+	var entries []Entry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("invalid dead letter on line %d: %w", line, err)
+		}
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read dead-letter file: %w", err)
+	}
+	return entries, nil
+}
+
+// Update rewrites the dead-letter file with the entries returned by fn, holding the lock
+// so no entry appended meanwhile is lost
+func Update(path string, fn func([]Entry) ([]Entry, error)) error {
+	file, err := openLocked(path)
+	if err != nil {
+		return err
+	}
+	defer closeLocked(file)
+
+	reader, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open dead-letter file: %w", err)
+	}
+	entries, err := readEntries(reader)
+	reader.Close()
+	if err != nil {
+		return err
+	}
+
+	kept, err := fn(entries)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to rewrite dead-letter file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	writer := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(writer)
+	for _, e := range kept {
+		if err := enc.Encode(e); err != nil {
+			tmp.Close()
+			return fmt.Errorf("failed to rewrite dead-letter file: %w", err)
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to rewrite dead-letter file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync dead-letter file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to rewrite dead-letter file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace dead-letter file: %w", err)
+	}
+	return nil
+}
+
+// Match reports whether the entry is selected by one of ids, an ID or a prefix of it
+func Match(e Entry, ids []string) bool {
+	for _, id := range ids {
+		if id != "" && strings.HasPrefix(e.ID, id) {
+			return true
+		}
+	}
+	return false
+}
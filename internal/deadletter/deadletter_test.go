@@ -0,0 +1,75 @@
+package deadletter
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestAppendRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deadletter.jsonl")
+
+	entries, err := Read(path)
+	if err != nil || len(entries) != 0 {
+		t.Fatalf("Expected no entries in a missing file, got %v, %v", entries, err)
+	}
+
+	for _, caseKey := range []string{"hold", "degas"} {
+		e := Entry{Case: caseKey, Data: map[string]any{"ch1_weighing": 101.5}, Error: "status 400", Status: 400, Response: `{"code":"PGRST204"}`}
+		if err := Append(path, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err = Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %d", len(entries))
+	}
+	if entries[0].ID == "" || entries[0].ID == entries[1].ID || entries[0].Time.IsZero() {
+		t.Errorf("Expected unique IDs and a time, got %+v", entries)
+	}
+	if entries[1].Case != "degas" || entries[1].Status != 400 || entries[1].Data["ch1_weighing"] != 101.5 {
+		t.Errorf("Unexpected entry %+v", entries[1])
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deadletter.jsonl")
+	for _, id := range []string{"aaaa1111", "bbbb2222", "aaaa3333"} {
+		if err := Append(path, Entry{ID: id, Case: "hold"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := Update(path, func(entries []Entry) ([]Entry, error) {
+		var kept []Entry
+		for _, e := range entries {
+			if !Match(e, []string{"bbbb"}) {
+				kept = append(kept, e)
+			}
+		}
+		return kept, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Appending still works on the rewritten file
+	if err := Append(path, Entry{ID: "cccc4444", Case: "hold"}); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, e := range entries {
+		ids = append(ids, e.ID)
+	}
+	if len(ids) != 3 || ids[0] != "aaaa1111" || ids[1] != "aaaa3333" || ids[2] != "cccc4444" {
+		t.Errorf("Expected aaaa1111 aaaa3333 cccc4444, got %v", ids)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package deadletter
+
+import "os"
+
+// Without flock the dlq command should not run while the service is writing dead letters
+func lock(file *os.File) error { return nil }
+
+func unlock(file *os.File) {}
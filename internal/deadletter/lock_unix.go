@@ -0,0 +1,17 @@
+//go:build unix
+
+package deadletter
+
+import (
+	"os"
+	"syscall"
+)
+
+// lock takes an exclusive advisory lock on the file, so the service and the dlq command don't interleave
+func lock(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_EX)
+}
+
+func unlock(file *os.File) {
+	syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+}
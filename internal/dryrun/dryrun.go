@@ -0,0 +1,199 @@
+package dryrun
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"gopatch/internal/cycle"
+)
+
+// Redacted replaces secrets in logged headers and URLs
+const Redacted = "[REDACTED]"
+
+// Headers and query parameters holding secrets
+var secretHeaders = map[string]bool{"apikey": true, "authorization": true, "cookie": true, "proxy-authorization": true, "x-api-key": true}
+
+// Form fields of a token request holding secrets
+var secretFields = map[string]bool{"client_secret": true, "client_assertion": true, "password": true}
+
+// tokenResponse answers an OAuth2 token request, so the requests it authorizes are logged too
+const tokenResponse = `{"access_token":"dry-run","token_type":"Bearer","expires_in":3600}`
+
+// Entry is one outgoing request or PLC write skipped by dry-run
+type Entry struct {
+	Time    time.Time         `json:"time"`
+	Kind    string            `json:"kind"`            // "http" or "plc"
+	Cycle   string            `json:"cycle,omitempty"` // Cycle ID of the record that caused it
+	Method  string            `json:"method,omitempty"`
+	URL     string            `json:"url,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    string            `json:"body,omitempty"`
+	Device  string            `json:"device,omitempty"`
+	Frame   string            `json:"frame,omitempty"` // PLC data, hex encoded
+}
+
+// Logger logs skipped requests and optionally appends them to a JSONL file
+type Logger struct {
+	mu     sync.Mutex
+	logger *slog.Logger
+	file   *os.File
+}
+
+// New creates a dry-run logger logging to logger, and writing to path when not empty
+func New(logger *slog.Logger, path string) (*Logger, error) {
+	l := &Logger{logger: logger.With("dry_run", true)}
+	if path != "" {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open dry-run file %s: %w", path, err)
+		}
+		l.file = file
+	}
+	return l, nil
+}
+
+// Log records a skipped request
+func (l *Logger) Log(e Entry) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	switch e.Kind {
+	case "plc":
+		l.logger.Info("Skipped PLC write", "cycle", e.Cycle, "device", e.Device, "frame", e.Frame)
+	default:
+		l.logger.Info("Skipped request", "cycle", e.Cycle, "method", e.Method, "url", e.URL, "headers", e.Headers, "body", e.Body)
+	}
+
+	if l.file != nil {
+		if err := json.NewEncoder(l.file).Encode(e); err != nil {
+			l.logger.Error("Failed to write dry-run file", "err", err)
+		}
+	}
+}
+
+// Close closes the dry-run file
+func (l *Logger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	return l.file.Close()
+}
+
+// Transport logs every HTTP request instead of sending it, and answers 201 Created
+// with an empty JSON array so callers carry on as if the API accepted it.
+// An OAuth2 token request is answered with a placeholder token.
+type Transport struct {
+	Log *Logger
+}
+
+func (t Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		body, _ = io.ReadAll(req.Body)
+		req.Body.Close()
+	}
+
+	form, token := tokenForm(req, body)
+	if token {
+		body = []byte(redactForm(form))
+	}
+
+	headers := make(map[string]string, len(req.Header))
+	for name, values := range req.Header {
+		headers[name] = redactHeader(name, strings.Join(values, ", "))
+	}
+
+	t.Log.Log(Entry{
+		Kind:    "http",
+		Cycle:   cycle.IDFrom(req.Context()),
+		Method:  req.Method,
+		URL:     redactURL(req.URL),
+		Headers: headers,
+		Body:    string(body),
+	})
+
+	if token {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Status:     "200 OK",
+			Header:     http.Header{"Content-Type": {"application/json"}},
+			Body:       io.NopCloser(strings.NewReader(tokenResponse)),
+			Request:    req,
+		}, nil
+	}
+	return &http.Response{
+		StatusCode: http.StatusCreated,
+		Status:     "201 Created",
+		Header:     make(http.Header),
+		Body:       io.NopCloser(bytes.NewReader([]byte("[]"))),
+		Request:    req,
+	}, nil
+}
+
+// redactHeader hides the value of secret headers, keeping the auth scheme, e.g. "Bearer [REDACTED]"
+func redactHeader(name, value string) string {
+	if !secretHeaders[strings.ToLower(name)] {
+		return value
+	}
+	if scheme, _, found := strings.Cut(value, " "); found {
+		return scheme + " " + Redacted
+	}
+	return Redacted
+}
+
+// tokenForm returns the form of an OAuth2 token request, a form POST with a grant_type
+func tokenForm(req *http.Request, body []byte) (url.Values, bool) {
+	if req.Method != http.MethodPost || !strings.HasPrefix(req.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		return nil, false
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil || !form.Has("grant_type") {
+		return nil, false
+	}
+	return form, true
+}
+
+// redactForm hides the secret fields of a form
+func redactForm(form url.Values) string {
+	for name := range form {
+		if secretFields[strings.ToLower(name)] {
+			form.Set(name, Redacted)
+		}
+	}
+	return form.Encode()
+}
+
+// redactURL hides secret query parameters and user info
+func redactURL(u *url.URL) string {
+	redacted := *u
+	if redacted.User != nil {
+		redacted.User = url.User(Redacted)
+	}
+	query := redacted.Query()
+	changed := false
+	for name := range query {
+		if secretHeaders[strings.ToLower(name)] {
+			query.Set(name, Redacted)
+			changed = true
+		}
+	}
+	if changed {
+		redacted.RawQuery = query.Encode()
+	}
+	return redacted.String()
+}
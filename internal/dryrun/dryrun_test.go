@@ -0,0 +1,97 @@
+package dryrun
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTransportRedactsSecrets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dryrun.jsonl")
+	var out bytes.Buffer
+	logger, err := New(slog.New(slog.NewTextHandler(&out, nil)), path)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	req, _ := http.NewRequest("PATCH", "http://localhost/rest/v1/table?id=eq.1&apikey=secret", strings.NewReader(`{"a":1}`))
+	req.Header.Set("apikey", "service-role-key")
+	req.Header.Set("Authorization", "Bearer service-role-key")
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := Transport{Log: logger}.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusCreated || string(body) != "[]" {
+		t.Errorf("Unexpected response %d %s", resp.StatusCode, body)
+	}
+	logger.Log(Entry{Kind: "plc", Device: "D100", Frame: "01 00"})
+	logger.Close()
+
+	if strings.Contains(out.String(), "service-role-key") || strings.Contains(out.String(), "secret") {
+		t.Errorf("Secret leaked to output: %s", out.String())
+	}
+
+	file, _ := os.Open(path)
+	defer file.Close()
+	var entries []Entry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("Invalid entry: %v", err)
+		}
+		entries = append(entries, e)
+	}
+
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %d", len(entries))
+	}
+	e := entries[0]
+	if e.Method != "PATCH" || e.Body != `{"a":1}` || e.Headers["Authorization"] != "Bearer "+Redacted ||
+		e.Headers["Apikey"] != Redacted || e.Headers["Content-Type"] != "application/json" {
+		t.Errorf("Unexpected entry %+v", e)
+	}
+	if !strings.Contains(e.URL, "id=eq.1") || strings.Contains(e.URL, "apikey=secret") {
+		t.Errorf("Unexpected URL %s", e.URL)
+	}
+	if entries[1].Kind != "plc" || entries[1].Frame != "01 00" {
+		t.Errorf("Unexpected PLC entry %+v", entries[1])
+	}
+}
+
+func TestTransportAnswersTokenRequests(t *testing.T) {
+	var out bytes.Buffer
+	logger, err := New(slog.New(slog.NewTextHandler(&out, nil)), "")
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer logger.Close()
+
+	req, _ := http.NewRequest("POST", "https://auth.local/oauth/token",
+		strings.NewReader("grant_type=client_credentials&client_id=gopatch&client_secret=s3cr3t"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := Transport{Log: logger}.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip: %v", err)
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil || resp.StatusCode != http.StatusOK || token.AccessToken == "" {
+		t.Errorf("Expected a placeholder token, got %d %+v (%v)", resp.StatusCode, token, err)
+	}
+	if strings.Contains(out.String(), "s3cr3t") || !strings.Contains(out.String(), "client_id=gopatch") {
+		t.Errorf("Expected the request logged with the client secret redacted: %s", out.String())
+	}
+}
@@ -0,0 +1,88 @@
+package logging
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"gopatch/internal/cycle"
+)
+
+// dev turns on colored pretty printing of the sent records (LOG_DEV)
+var dev atomic.Bool
+
+// Setup makes the default slog logger write to out at level ("debug", "info", "warn", "error")
+// as "text" or "json", adding the cycle ID of the context to every line.
+func Setup(out io.Writer, level, format string, devMode bool) *slog.Logger {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		lvl = slog.LevelInfo
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	var handler slog.Handler
+	if strings.EqualFold(format, "json") {
+		handler = slog.NewJSONHandler(out, opts)
+	} else {
+		handler = slog.NewTextHandler(out, opts)
+	}
+
+	logger := slog.New(contextHandler{handler})
+	slog.SetDefault(logger)
+	dev.Store(devMode)
+	return logger
+}
+
+// Dev reports whether dev mode is on
+func Dev() bool {
+	return dev.Load()
+}
+
+// Fatal logs at error level and exits
+func Fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// contextHandler adds the cycle ID carried by the context of a log call, e.g. slog.InfoContext(ctx, ...)
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := cycle.IDFrom(ctx); id != "" {
+		r.AddAttrs(slog.String("cycle", id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// PrettyPrint writes the record as indented JSON in color with the time it took, for dev mode
+func PrettyPrint(out io.Writer, record map[string]any, elapsed time.Duration) {
+	formatted, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		slog.Error("Failed to format record", "err", err)
+		return
+	}
+
+	// Define ANSI escape codes for colors
+	greenColor := "\x1b[32m" // Green color for time
+	pinkColor := "\x1b[35m"  // Pink color for JSON
+	resetColor := "\x1b[0m"  // Reset color to default
+
+	fmt.Fprintf(out, "%s >= %s%.2f s%s %s%s%s\n", time.Now().Format("2006/01/02 15:04:05"),
+		greenColor, elapsed.Seconds(), resetColor, pinkColor, formatted, resetColor)
+}
@@ -0,0 +1,46 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"gopatch/internal/cycle"
+)
+
+func TestSetupAddsCycleID(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+
+	var out bytes.Buffer
+	logger := Setup(&out, "info", "json", false)
+
+	ctx := cycle.WithID(context.Background(), "c1")
+	logger.With("case", "holdfilling").InfoContext(ctx, "Record sent")
+
+	var line map[string]any
+	if err := json.Unmarshal(out.Bytes(), &line); err != nil {
+		t.Fatalf("Expected one JSON line, got %q: %v", out.String(), err)
+	}
+	if line["cycle"] != "c1" || line["case"] != "holdfilling" || line["msg"] != "Record sent" {
+		t.Errorf("Unexpected line %v", line)
+	}
+}
+
+func TestSetupLevel(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+
+	var out bytes.Buffer
+	Setup(&out, "warn", "text", false)
+
+	slog.Info("hidden")
+	slog.Warn("shown")
+	if strings.Contains(out.String(), "hidden") || !strings.Contains(out.String(), "shown") {
+		t.Errorf("Expected only the warning, got %q", out.String())
+	}
+	if strings.Contains(out.String(), "cycle=") {
+		t.Errorf("Expected no cycle without a context, got %q", out.String())
+	}
+}
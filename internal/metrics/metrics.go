@@ -0,0 +1,148 @@
+package metrics
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Registry holds every gopatch metric, plus the Go runtime and process collectors
+var Registry = prometheus.NewRegistry()
+
+var (
+	MessagesReceived = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gopatch_mqtt_messages_received_total",
+		Help: "MQTT messages received, by topic.",
+	}, []string{"topic"})
+	BatchesFlushed = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "gopatch_mqtt_batches_flushed_total",
+		Help: "MQTT batches handed to processing.",
+	})
+	BatchesDropped = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "gopatch_mqtt_batches_dropped_total",
+		Help: "MQTT batches dropped because the processing channel was full.",
+	})
+	MQTTConnected = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "gopatch_mqtt_connected",
+		Help: "1 while connected to the MQTT broker, 0 otherwise.",
+	})
+
+	CyclesStarted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gopatch_cycles_started_total",
+		Help: "Cycles started, by case.",
+	}, []string{"case"})
+	CyclesCompleted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gopatch_cycles_completed_total",
+		Help: "Cycles emitted as a record, by case.",
+	}, []string{"case"})
+	CyclesAborted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gopatch_cycles_aborted_total",
+		Help: "Cycles ended without a complete record, by case and reason (timeout, invalid, failed, forced, reset, idle).",
+	}, []string{"case", "reason"})
+
+	SinkRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "gopatch_sink_request_duration_seconds",
+		Help:    "Latency of the REST API requests, by method.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method"})
+	SinkRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gopatch_sink_requests_total",
+		Help: "REST API requests, by method and status code; code \"error\" when no response was received.",
+	}, []string{"method", "code"})
+	SinkRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gopatch_sink_retries_total",
+		Help: "Record writes repeated after a retryable failure, by case.",
+	}, []string{"case"})
+	SinkFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gopatch_sink_failures_total",
+		Help: "Records that could not be written, after the last attempt or a permanent error, by case.",
+	}, []string{"case"})
+
+	OutboxDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gopatch_outbox_dropped_total",
+		Help: "Queued records dropped before delivery, by reason (expired, full, rejected).",
+	}, []string{"reason"})
+
+	PLCWriteDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "gopatch_plc_write_duration_seconds",
+		Help:    "Latency of the PLC writes.",
+		Buckets: prometheus.DefBuckets,
+	})
+	PLCWriteFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "gopatch_plc_write_failures_total",
+		Help: "Failed PLC writes.",
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		MessagesReceived, BatchesFlushed, BatchesDropped, MQTTConnected,
+		CyclesStarted, CyclesCompleted, CyclesAborted,
+		SinkRequestDuration, SinkRequests, SinkRetries, SinkFailures,
+		OutboxDropped,
+		PLCWriteDuration, PLCWriteFailures,
+	)
+}
+
+// WatchChannelDepth exports the number of batches waiting in the processing channel
+func WatchChannelDepth(depth func() int) {
+	Registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "gopatch_mqtt_channel_depth",
+		Help: "MQTT batches waiting in the processing channel.",
+	}, func() float64 { return float64(depth()) }))
+}
+
+// WatchOutbox exports the number of records waiting in the outbox and its size on disk
+func WatchOutbox(depth func() int, bytes func() int64) {
+	Registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "gopatch_outbox_depth",
+		Help: "Records waiting in the outbox for delivery.",
+	}, func() float64 { return float64(depth()) }), prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "gopatch_outbox_bytes",
+		Help: "Size of the outbox segment files on disk.",
+	}, func() float64 { return float64(bytes()) }))
+}
+
+// ObserveSinkRequest records a REST API request, resp is nil when it failed without a response
+func ObserveSinkRequest(method string, resp *http.Response, elapsed time.Duration) {
+	code := "error"
+	if resp != nil {
+		code = strconv.Itoa(resp.StatusCode)
+	}
+	SinkRequestDuration.WithLabelValues(method).Observe(elapsed.Seconds())
+	SinkRequests.WithLabelValues(method, code).Inc()
+}
+
+// ObservePLCWrite records a PLC write
+func ObservePLCWrite(elapsed time.Duration, err error) {
+	PLCWriteDuration.Observe(elapsed.Seconds())
+	if err != nil {
+		PLCWriteFailures.Inc()
+	}
+}
+
+// Handler serves the metrics in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// Start serves /metrics on addr in the background
+func Start(addr string) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", Handler())
+	srv := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Metrics server stopped", "err", err)
+		}
+	}()
+	return srv
+}
@@ -0,0 +1,53 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestObserveSinkRequest(t *testing.T) {
+	ObserveSinkRequest(http.MethodPatch, &http.Response{StatusCode: http.StatusConflict}, 20*time.Millisecond)
+	ObserveSinkRequest(http.MethodPatch, nil, time.Second)
+
+	if got := testutil.ToFloat64(SinkRequests.WithLabelValues("PATCH", "409")); got != 1 {
+		t.Errorf("Expected one 409, got %v", got)
+	}
+	if got := testutil.ToFloat64(SinkRequests.WithLabelValues("PATCH", "error")); got != 1 {
+		t.Errorf("Expected one failed request, got %v", got)
+	}
+}
+
+func TestObservePLCWrite(t *testing.T) {
+	before := testutil.ToFloat64(PLCWriteFailures)
+	ObservePLCWrite(time.Millisecond, nil)
+	ObservePLCWrite(time.Millisecond, io.ErrUnexpectedEOF)
+	if got := testutil.ToFloat64(PLCWriteFailures) - before; got != 1 {
+		t.Errorf("Expected one failure, got %v", got)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	WatchChannelDepth(func() int { return 3 })
+	MessagesReceived.WithLabelValues("plc/line1").Inc()
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rec.Body.String()
+
+	for _, want := range []string{
+		`gopatch_mqtt_messages_received_total{topic="plc/line1"} 1`,
+		"gopatch_mqtt_channel_depth 3",
+		"gopatch_mqtt_connected 0",
+		"go_goroutines",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected %q in the metrics output", want)
+		}
+	}
+}
@@ -0,0 +1,362 @@
+package outbox
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopatch/internal/clock"
+	"gopatch/internal/metrics"
+)
+
+// Entry is one record waiting for delivery
+type Entry struct {
+	Seq    uint64          `json:"seq"`              // Position in the queue, assigned by Append
+	Time   time.Time       `json:"time"`             // When it was queued, assigned by Append
+	Cycle  string          `json:"cycle,omitempty"`  // Cycle ID of the record
+	Case   string          `json:"case"`             // Case key that produced the record
+	Target json.RawMessage `json:"target,omitempty"` // Where the record goes, opaque to the queue
+	Data   map[string]any  `json:"data"`
+}
+
+// Options bound what the queue keeps on disk
+type Options struct {
+	Retention   time.Duration // Drop entries queued longer ago than this, 0 keeps them until delivered
+	MaxBytes    int64         // Drop the oldest segments once the queue grows past this, 0 for no cap
+	SegmentSize int64         // Start a new segment file past this size, default 1 MB
+}
+
+const (
+	defaultSegmentSize = 1 << 20
+	cursorFile         = "cursor"   // Seq of the last delivered entry
+	segmentExt         = ".jsonl"   // Segment files are named after the seq of their first entry
+	dropExpired        = "expired"  // Reason label of an entry dropped past Retention
+	dropFull           = "full"     // Reason label of an entry dropped past MaxBytes
+	dropRejected       = "rejected" // Reason label of an entry that failed with a permanent error
+)
+
+// segment is an append-only file of entries, in seq order
+type segment struct {
+	path string
+	last uint64 // Seq of its last entry, 0 while empty
+	size int64
+}
+
+// Queue is a disk-backed FIFO of entries. Every entry is appended to the newest segment file and
+// synced before Append returns; the seq of the last delivered entry is kept in the cursor file,
+// and segments are deleted once all of their entries are delivered.
+// The pending entries are also kept in memory, bounded by MaxBytes.
+type Queue struct {
+	mu       sync.Mutex
+	dir      string
+	opts     Options
+	clk      clock.Clock
+	segments []*segment
+	file     *os.File // Newest segment, open for appending
+	pending  []Entry
+	nextSeq  uint64
+	acked    uint64
+	notify   chan struct{} // Signalled on Append
+}
+
+// Open loads the queue kept in dir, creating dir if needed
+func Open(dir string, opts Options, clk clock.Clock) (*Queue, error) {
+	if opts.SegmentSize <= 0 {
+		opts.SegmentSize = defaultSegmentSize
+	}
+	if opts.MaxBytes > 0 && opts.SegmentSize > opts.MaxBytes/4 {
+		// Keep several segments under the cap, so dropping the oldest doesn't empty the queue
+		opts.SegmentSize = max(opts.MaxBytes/4, 1)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create outbox: %w", err)
+	}
+
+	q := &Queue{dir: dir, opts: opts, clk: clk, nextSeq: 1, notify: make(chan struct{}, 1)}
+	if err := q.load(); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// load reads the cursor and every segment, keeping the entries not yet delivered
+func (q *Queue) load() error {
+	data, err := os.ReadFile(filepath.Join(q.dir, cursorFile))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to read outbox cursor: %w", err)
+	}
+	if len(data) > 0 {
+		if q.acked, err = strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64); err != nil {
+			return fmt.Errorf("invalid outbox cursor: %w", err)
+		}
+	}
+	q.nextSeq = q.acked + 1
+
+	paths, err := filepath.Glob(filepath.Join(q.dir, "*"+segmentExt))
+	if err != nil {
+		return err
+	}
+	sort.Strings(paths) // Zero-padded names sort in seq order
+
+	for _, path := range paths {
+		seg, entries, err := readSegment(path)
+		if err != nil {
+			return err
+		}
+		if seg.last <= q.acked {
+			os.Remove(path) // Delivered before the last shutdown, or empty
+			continue
+		}
+		q.segments = append(q.segments, seg)
+		for _, e := range entries {
+			if e.Seq > q.acked {
+				q.pending = append(q.pending, e)
+			}
+			if e.Seq >= q.nextSeq {
+				q.nextSeq = e.Seq + 1
+			}
+		}
+	}
+	return nil
+}
+
+// readSegment parses a segment file; a torn last line, left by a crash mid-append, is skipped
+func readSegment(path string) (*segment, []Entry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read outbox segment: %w", err)
+	}
+
+	seg := &segment{path: path, size: int64(len(data))}
+	var entries []Entry
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), len(data)+1)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue
+		}
+		entries = append(entries, e)
+		seg.last = e.Seq
+	}
+	return seg, entries, scanner.Err()
+}
+
+// Append queues an entry, it is on disk once Append returns
+func (q *Queue) Append(e Entry) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	e.Seq = q.nextSeq
+	e.Time = q.clk.Now()
+	line, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to encode outbox entry: %w", err)
+	}
+	line = append(line, '\n')
+
+	if err := q.openSegment(); err != nil {
+		return err
+	}
+	if _, err := q.file.Write(line); err != nil {
+		return fmt.Errorf("failed to append to outbox: %w", err)
+	}
+	if err := q.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync outbox: %w", err)
+	}
+
+	seg := q.segments[len(q.segments)-1]
+	seg.last = e.Seq
+	seg.size += int64(len(line))
+	q.nextSeq++
+	q.pending = append(q.pending, e)
+	q.enforceMaxBytes()
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// openSegment makes sure q.file is the newest segment and has room, callers hold q.mu
+func (q *Queue) openSegment() error {
+	if n := len(q.segments); n > 0 && q.segments[n-1].size >= q.opts.SegmentSize {
+		if q.file != nil {
+			q.file.Close()
+			q.file = nil
+		}
+		q.segments = append(q.segments, &segment{path: q.segmentPath(q.nextSeq)})
+	}
+	if len(q.segments) == 0 {
+		q.segments = append(q.segments, &segment{path: q.segmentPath(q.nextSeq)})
+	}
+	if q.file != nil {
+		return nil
+	}
+
+	f, err := os.OpenFile(q.segments[len(q.segments)-1].path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open outbox segment: %w", err)
+	}
+	q.file = f
+	return nil
+}
+
+func (q *Queue) segmentPath(firstSeq uint64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%020d%s", firstSeq, segmentExt))
+}
+
+// enforceMaxBytes drops the oldest segments, delivered or not, while the queue is over MaxBytes
+func (q *Queue) enforceMaxBytes() {
+	if q.opts.MaxBytes <= 0 {
+		return
+	}
+	for len(q.segments) > 1 && q.bytes() > q.opts.MaxBytes {
+		n := len(q.segments)
+		dropped(dropFull, q.dropThrough(q.segments[0].last))
+		if q.removeDelivered(); len(q.segments) == n {
+			return // Could not delete it, retried on the next Append
+		}
+	}
+}
+
+// Peek returns the oldest entry waiting for delivery, after dropping the ones past Retention
+func (q *Queue) Peek() (Entry, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.opts.Retention > 0 {
+		expired := 0
+		for expired < len(q.pending) && q.clk.Since(q.pending[expired].Time) > q.opts.Retention {
+			expired++
+		}
+		if expired > 0 {
+			dropped(dropExpired, q.dropThrough(q.pending[expired-1].Seq))
+			q.removeDelivered()
+		}
+	}
+
+	if len(q.pending) == 0 {
+		return Entry{}, false
+	}
+	return q.pending[0], true
+}
+
+// Ack marks every entry up to seq as delivered
+func (q *Queue) Ack(seq uint64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.dropThrough(seq)
+	q.removeDelivered()
+	return q.saveCursor()
+}
+
+// dropThrough moves the cursor past seq, callers hold q.mu
+func (q *Queue) dropThrough(seq uint64) int {
+	if seq <= q.acked {
+		return 0
+	}
+	q.acked = seq
+	n := 0
+	for n < len(q.pending) && q.pending[n].Seq <= seq {
+		n++
+	}
+	q.pending = q.pending[n:]
+	return n
+}
+
+// removeDelivered deletes the segments whose entries are all delivered, callers hold q.mu.
+// The cursor is saved first, so a crash never brings back a deleted segment's entries as pending.
+func (q *Queue) removeDelivered() {
+	n := 0
+	for n < len(q.segments) && q.segments[n].last != 0 && q.segments[n].last <= q.acked {
+		n++
+	}
+	if n == 0 {
+		return
+	}
+	if err := q.saveCursor(); err != nil {
+		return
+	}
+	for i, seg := range q.segments[:n] {
+		if i == len(q.segments)-1 && q.file != nil {
+			q.file.Close()
+			q.file = nil
+		}
+		os.Remove(seg.path)
+	}
+	q.segments = q.segments[n:]
+}
+
+// saveCursor writes the seq of the last delivered entry, callers hold q.mu
+func (q *Queue) saveCursor() error {
+	path := filepath.Join(q.dir, cursorFile)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strconv.FormatUint(q.acked, 10)), 0644); err != nil {
+		return fmt.Errorf("failed to save outbox cursor: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to save outbox cursor: %w", err)
+	}
+	return nil
+}
+
+// dropped counts and logs entries dropped before delivery
+func dropped(reason string, n int) {
+	if n == 0 {
+		return
+	}
+	metrics.OutboxDropped.WithLabelValues(reason).Add(float64(n))
+	slog.Warn("Dropped queued records", "reason", reason, "records", n)
+}
+
+// Len returns the number of entries waiting for delivery
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pending)
+}
+
+// Bytes returns the size of the segment files on disk
+func (q *Queue) Bytes() int64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.bytes()
+}
+
+func (q *Queue) bytes() int64 {
+	var total int64
+	for _, seg := range q.segments {
+		total += seg.size
+	}
+	return total
+}
+
+// Wait returns a channel signalled when an entry is appended
+func (q *Queue) Wait() <-chan struct{} {
+	return q.notify
+}
+
+// Close closes the newest segment file
+func (q *Queue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.file == nil {
+		return nil
+	}
+	err := q.file.Close()
+	q.file = nil
+	return err
+}
@@ -0,0 +1,175 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gopatch/internal/clock"
+	"gopatch/patch"
+)
+
+func newClock() *clock.Fake {
+	return clock.NewFake(time.Date(2025, 1, 1, 8, 0, 0, 0, time.UTC))
+}
+
+func TestQueueSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	clk := newClock()
+
+	q, err := Open(dir, Options{}, clk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"1", "2", "3"} {
+		if err := q.Append(Entry{Cycle: id, Case: "hold", Data: map[string]any{"id": id}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	e, _ := q.Peek()
+	if err := q.Ack(e.Seq); err != nil {
+		t.Fatal(err)
+	}
+	q.Close()
+
+	// A crash mid-append leaves a torn line behind
+	segments, _ := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	f, _ := os.OpenFile(segments[len(segments)-1], os.O_WRONLY|os.O_APPEND, 0644)
+	f.WriteString(`{"seq":4,"ca`)
+	f.Close()
+
+	q, err = Open(dir, Options{}, clk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if q.Len() != 2 {
+		t.Fatalf("Expected 2 queued records after restart, got %d", q.Len())
+	}
+	if e, _ := q.Peek(); e.Cycle != "2" || e.Data["id"] != "2" {
+		t.Errorf("Expected record 2 first, got %+v", e)
+	}
+
+	// New records continue after the last seq
+	q.Append(Entry{Cycle: "4"})
+	q.Ack(3)
+	if e, _ := q.Peek(); e.Cycle != "4" || e.Seq != 4 {
+		t.Errorf("Expected record 4 with seq 4, got %+v", e)
+	}
+	q.Ack(4)
+	if segments, _ := filepath.Glob(filepath.Join(dir, "*"+segmentExt)); len(segments) != 0 {
+		t.Errorf("Expected the delivered segments deleted, got %v", segments)
+	}
+}
+
+func TestQueueRetention(t *testing.T) {
+	clk := newClock()
+	q, err := Open(t.TempDir(), Options{Retention: time.Hour}, clk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	q.Append(Entry{Cycle: "old"})
+	clk.Advance(50 * time.Minute)
+	q.Append(Entry{Cycle: "new"})
+	clk.Advance(20 * time.Minute)
+
+	if e, ok := q.Peek(); !ok || e.Cycle != "new" {
+		t.Errorf("Expected the expired record dropped, got %+v", e)
+	}
+	if q.Len() != 1 {
+		t.Errorf("Expected 1 queued record, got %d", q.Len())
+	}
+}
+
+func TestQueueMaxBytes(t *testing.T) {
+	q, err := Open(t.TempDir(), Options{MaxBytes: 2000, SegmentSize: 200}, newClock())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	for i := 0; i < 100; i++ {
+		if err := q.Append(Entry{Case: "hold", Data: map[string]any{"value": strings.Repeat("x", 50)}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if q.Bytes() > 2000 {
+		t.Errorf("Expected at most 2000 bytes, got %d", q.Bytes())
+	}
+	if e, _ := q.Peek(); e.Seq <= 1 || q.Len() >= 100 {
+		t.Errorf("Expected the oldest records dropped, got %d queued from seq %d", q.Len(), e.Seq)
+	}
+}
+
+func TestWorker(t *testing.T) {
+	q, err := Open(t.TempDir(), Options{}, newClock())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	var (
+		mu        sync.Mutex
+		delivered []string
+		rejected  []string
+		failures  = 2
+		done      = make(chan struct{})
+	)
+	deliver := func(_ context.Context, e Entry) error {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case e.Cycle == "1" && failures > 0:
+			failures--
+			return &patch.StatusError{Code: http.StatusServiceUnavailable}
+		case e.Cycle == "2":
+			return errors.New("rejected")
+		}
+		delivered = append(delivered, e.Cycle)
+		if e.Cycle == "3" {
+			close(done)
+		}
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		Worker{Queue: q, Deliver: deliver, Clock: newClock(), Rejected: func(e Entry, _ error) {
+			mu.Lock()
+			defer mu.Unlock()
+			rejected = append(rejected, e.Cycle)
+		}}.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-stopped
+	}()
+
+	for _, id := range []string{"1", "2", "3"} {
+		q.Append(Entry{Cycle: id})
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Records not delivered")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if strings.Join(delivered, ",") != "1,3" {
+		t.Errorf("Expected 1 after its retries and 3, got %v", delivered)
+	}
+	if strings.Join(rejected, ",") != "2" {
+		t.Errorf("Expected 2 handed to Rejected, got %v", rejected)
+	}
+}
@@ -0,0 +1,82 @@
+package outbox
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"gopatch/internal/clock"
+	"gopatch/internal/cycle"
+	"gopatch/internal/sink"
+)
+
+// Worker delivers the entries of a queue in order. An entry failing with a retryable error
+// (sink.Retryable) is repeated with backoff until it is delivered or expires, holding back the
+// entries behind it; an entry failing with any other error is handed to Rejected and dropped.
+type Worker struct {
+	Queue     *Queue
+	Deliver   func(ctx context.Context, e Entry) error
+	Rejected  func(e Entry, err error) // Optional
+	BaseDelay time.Duration            // Delay before the first retry, doubled before every next one
+	MaxDelay  time.Duration            // Upper bound of the retry delay
+	Clock     clock.Clock
+}
+
+// Run delivers entries until ctx is done
+func (w Worker) Run(ctx context.Context) {
+	attempt := 0
+	for {
+		e, ok := w.Queue.Peek()
+		if !ok {
+			select {
+			case <-ctx.Done():
+				return
+			case <-w.Queue.Wait():
+			}
+			continue
+		}
+
+		entryCtx := cycle.WithID(ctx, e.Cycle)
+		err := w.Deliver(entryCtx, e)
+		if ctx.Err() != nil {
+			return // Shutting down, the entry stays queued
+		}
+		if err != nil && sink.Retryable(err) {
+			attempt++
+			delay := sink.Backoff(w.BaseDelay, w.MaxDelay, attempt)
+			slog.WarnContext(entryCtx, "Delivery failed, retrying", "case", e.Case, "seq", e.Seq, "attempt", attempt,
+				"delay", delay, "queued", w.Queue.Len(), "err", err)
+			if !w.sleep(ctx, delay) {
+				return
+			}
+			continue
+		}
+
+		attempt = 0
+		if err != nil {
+			slog.ErrorContext(entryCtx, "Delivery failed, dropping record", "case", e.Case, "seq", e.Seq, "err", err)
+			dropped(dropRejected, 1)
+			if w.Rejected != nil {
+				w.Rejected(e, err)
+			}
+		}
+		if err := w.Queue.Ack(e.Seq); err != nil {
+			slog.ErrorContext(entryCtx, "Failed to save outbox cursor", "err", err)
+		}
+	}
+}
+
+// sleep waits d, reports false when ctx is done first
+func (w Worker) sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	t := w.Clock.NewTicker(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C():
+		return true
+	}
+}
@@ -0,0 +1,88 @@
+package replay
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"gopatch/internal/clock"
+)
+
+// Batch is one recorded flush of MQTT messages
+type Batch struct {
+	Time     time.Time       `json:"time"`
+	Messages json.RawMessage `json:"messages"`
+}
+
+// Recorder appends every batch to a JSONL file
+type Recorder struct {
+	mu   sync.Mutex
+	file *os.File
+	enc  *json.Encoder
+}
+
+// NewRecorder opens (or creates) the recording file in append mode
+func NewRecorder(path string) (*Recorder, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open recording %s: %w", path, err)
+	}
+	return &Recorder{file: file, enc: json.NewEncoder(file)}, nil
+}
+
+// Record appends a batch, messages must be the JSON array sent to the handler
+func (r *Recorder) Record(t time.Time, messages []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.enc.Encode(Batch{Time: t, Messages: messages})
+}
+
+// Close closes the recording file
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.file.Close()
+}
+
+// Read loads every batch of a recording
+func Read(path string) ([]Batch, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open recording %s: %w", path, err)
+	}
+	defer file.Close()
+
+	var batches []Batch
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var batch Batch
+		if err := json.Unmarshal(scanner.Bytes(), &batch); err != nil {
+			return nil, fmt.Errorf("invalid batch at line %d: %w", line, err)
+		}
+		batches = append(batches, batch)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read recording %s: %w", path, err)
+	}
+	return batches, nil
+}
+
+// Play hands the batches to deliver one at a time, keeping their original spacing divided by speed,
+// speed <= 0 plays them back to back. A batch is delivered once the previous deliver returned.
+func Play(batches []Batch, speed float64, clk clock.Clock, deliver func(Batch)) {
+	for i, batch := range batches {
+		if i > 0 && speed > 0 {
+			if gap := batch.Time.Sub(batches[i-1].Time); gap > 0 {
+				clk.Sleep(time.Duration(float64(gap) / speed))
+			}
+		}
+		deliver(batch)
+	}
+}
@@ -0,0 +1,43 @@
+package replay
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gopatch/internal/clock"
+)
+
+func TestRecordAndPlay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "recording.jsonl")
+
+	recorder, err := NewRecorder(path)
+	if err != nil {
+		t.Fatalf("NewRecorder: %v", err)
+	}
+	start := time.Date(2025, 1, 1, 8, 0, 0, 0, time.UTC)
+	recorder.Record(start, []byte(`[{"address":"d800","value":7}]`))
+	recorder.Record(start.Add(time.Second), []byte(`[{"address":"d800","value":0}]`))
+	if err := recorder.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	batches, err := Read(path)
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if len(batches) != 2 || !batches[1].Time.Equal(start.Add(time.Second)) {
+		t.Fatalf("Unexpected batches %+v", batches)
+	}
+
+	clk := clock.NewFake(start)
+	var got []string
+	Play(batches, 20, clk, func(b Batch) { got = append(got, string(b.Messages)) }) // 1s gap at 20x
+	if elapsed := clk.Since(start); elapsed != 50*time.Millisecond {
+		t.Errorf("Expected the gap to be kept at 20x speed, took %s", elapsed)
+	}
+
+	if len(got) != 2 || got[0] != `[{"address":"d800","value":7}]` {
+		t.Errorf("Unexpected played batches %v", got)
+	}
+}
@@ -1,7 +1,10 @@
 package session
 
 import (
+	"encoding/json"
+	"sort"
 	"sync"
+	"time"
 )
 
 var (
@@ -9,51 +12,243 @@ var (
 	sessionMutex sync.Mutex // prevent race conditions if accessed concurrently
 )
 
+// ChannelState holds the weighing trigger state of a single filling channel (case 7 & case 8)
+type ChannelState struct {
+	WeightTrigger     bool
+	PrevWeightTrigger bool
+	PrevWeightValue   float64
+	Emitted           bool // Record of this channel already sent in the current cycle (EMIT_MODE=channel)
+}
+
+// Session is the state a hold case keeps across batches. Every field is guarded by mu,
+// the handlers and their weight trigger goroutines only go through the methods below.
 type Session struct {
-	Mutex                sync.Mutex // Sync to protect session.ProcessedPayloadsMap
-	PrevSealing          float64    // To store the trigger of condition judgement in case 4
-	IsProcessing         bool       // Flag to track if the process is active
-	AllSuccessZero       bool
-	WeightTriggerCh1     bool
-	WeightTriggerCh2     bool
-	WeightTriggerCh3     bool
-	PrevWeightTriggerCh1 bool
-	PrevWeightTriggerCh2 bool
-	PrevWeightTriggerCh3 bool
-	PrevWeightValueCh1   *float64
-	PrevWeightValueCh2   *float64
-	PrevWeightValueCh3   *float64
-	ProcessedPayloadsMap map[string]map[string]any
-	Prev                 map[string]any
-}
-
-func NewSession() *Session {
-	return &Session{
-		// Create a map to store processed payloads (ch1, ch2, ch3; _xx_jsonPayloads) for holdCase
-		ProcessedPayloadsMap: map[string]map[string]any{
-			"ch1_":       make(map[string]any),
-			"ch2_":       make(map[string]any),
-			"ch3_":       make(map[string]any),
-			"ch1":        make(map[string]any),
-			"ch2":        make(map[string]any),
-			"ch3":        make(map[string]any),
-			"vacuum":     make(map[string]any),
-			"degas":      make(map[string]any),
-			"do":         make(map[string]any),
-			"weightch1_": make(map[string]any),
-			"weightch2_": make(map[string]any),
-			"weightch3_": make(map[string]any),
-			"counter":    make(map[string]any),
+	mu             sync.Mutex
+	prevSealing    float64 // To store the trigger of condition judgement in case 4
+	isProcessing   bool    // Flag to track if the process is active
+	allSuccessZero bool
+	cycleStartedAt time.Time                // When the current cycle became active, zero while idle
+	cycleID        string                   // ID of the current cycle, shared by its records
+	channels       []string                 // Channel names in configured order, e.g. ch1, ch2, ch3
+	channelStates  map[string]*ChannelState // Weighing trigger state per channel
+	payloads       map[string]map[string]any
+}
+
+func NewSession(channels []string) *Session {
+	s := &Session{
+		// Create a map to store processed payloads (chN, chN_, weightchN_; _xx_jsonPayloads) for holdCase
+		payloads: map[string]map[string]any{
+			"vacuum":  make(map[string]any),
+			"degas":   make(map[string]any),
+			"do":      make(map[string]any),
+			"counter": make(map[string]any),
 		},
-		PrevWeightValueCh1: new(float64),
-		PrevWeightValueCh2: new(float64),
-		PrevWeightValueCh3: new(float64),
-		AllSuccessZero:     false,
+		channels:      append([]string(nil), channels...),
+		channelStates: make(map[string]*ChannelState, len(channels)),
+	}
+
+	for _, ch := range channels {
+		s.payloads[ch+"_"] = make(map[string]any)
+		s.payloads[ch] = make(map[string]any)
+		s.payloads["weight"+ch+"_"] = make(map[string]any)
+		s.channelStates[ch] = &ChannelState{}
+	}
+
+	return s
+}
+
+func (s *Session) IsProcessing() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.isProcessing
+}
+
+func (s *Session) SetProcessing(processing bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.isProcessing = processing
+}
+
+func (s *Session) PrevSealing() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.prevSealing
+}
+
+func (s *Session) SetPrevSealing(sealing float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prevSealing = sealing
+}
+
+func (s *Session) AllSuccessZero() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.allSuccessZero
+}
+
+func (s *Session) SetAllSuccessZero(zero bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.allSuccessZero = zero
+}
+
+// Cycle returns the ID and start time of the current cycle, empty while idle
+func (s *Session) Cycle() (string, time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cycleID, s.cycleStartedAt
+}
+
+// SetCycle replaces the current cycle, SetCycle("", time.Time{}) ends it
+func (s *Session) SetCycle(id string, startedAt time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cycleID, s.cycleStartedAt = id, startedAt
+}
+
+// StartCycle returns the current cycle, giving it an ID from newID and a start time of now if missing
+func (s *Session) StartCycle(newID func() string, now time.Time) (string, time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cycleID == "" {
+		s.cycleID = newID()
+	}
+	if s.cycleStartedAt.IsZero() {
+		s.cycleStartedAt = now
 	}
+	return s.cycleID, s.cycleStartedAt
+}
+
+// Channels returns the channel names in configured order
+func (s *Session) Channels() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.channels...)
+}
+
+// Channel returns a copy of the state of the named channel
+func (s *Session) Channel(name string) ChannelState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return *s.channel(name)
+}
+
+// UpdateChannel changes the state of the named channel, creating it if needed.
+// fn runs under the session lock and must not call other session methods.
+func (s *Session) UpdateChannel(name string, fn func(state *ChannelState)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(s.channel(name))
+}
+
+// UpdateChannels changes the state of every channel, under the session lock like UpdateChannel
+func (s *Session) UpdateChannels(fn func(name string, state *ChannelState)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name, state := range s.channelStates {
+		fn(name, state)
+	}
+}
+
+func (s *Session) channel(name string) *ChannelState {
+	if state, ok := s.channelStates[name]; ok {
+		return state
+	}
+	state := &ChannelState{}
+	s.channelStates[name] = state
+	return state
+}
+
+// Payload returns a copy of the payload stored under key, nil when there is none
+func (s *Session) Payload(key string) map[string]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	payload, ok := s.payloads[key]
+	if !ok {
+		return nil
+	}
+	return copyMap(payload)
+}
+
+// SetPayload replaces the payload stored under key
+func (s *Session) SetPayload(key string, payload map[string]any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.payloads[key] = copyMap(payload)
+}
+
+// SetField sets one field of the payload stored under key, creating the payload if needed
+func (s *Session) SetField(key, field string, value any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.payloads[key] == nil {
+		s.payloads[key] = make(map[string]any)
+	}
+	s.payloads[key][field] = value
+}
+
+// PayloadKeys returns the keys of every stored payload, sorted
+func (s *Session) PayloadKeys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0, len(s.payloads))
+	for key := range s.payloads {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Merge returns the payloads of keys merged into a new map, later keys win
+func (s *Session) Merge(keys ...string) map[string]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := make(map[string]any)
+	for _, key := range keys {
+		for field, value := range s.payloads[key] {
+			result[field] = value
+		}
+	}
+	return result
+}
+
+// ClearPayloads empties the payloads of keys, or every payload without keys
+func (s *Session) ClearPayloads(keys ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(keys) == 0 {
+		for key := range s.payloads {
+			keys = append(keys, key)
+		}
+	}
+	for _, key := range keys {
+		s.payloads[key] = make(map[string]any)
+	}
+}
+
+// DeletePayloads removes every payload, a key is created again when next stored
+func (s *Session) DeletePayloads() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key := range s.payloads {
+		delete(s.payloads, key)
+	}
+}
+
+func copyMap(original map[string]any) map[string]any {
+	if original == nil {
+		return nil
+	}
+	copied := make(map[string]any, len(original))
+	for k, v := range original {
+		copied[k] = v
+	}
+	return copied
 }
 
 // GetOrCreateSession ensures a session exists for a caseKey
-func GetOrCreateSession(caseKey string) *Session {
+func GetOrCreateSession(caseKey string, channels []string) *Session {
 	sessionMutex.Lock()
 	defer sessionMutex.Unlock()
 
@@ -61,11 +256,36 @@ func GetOrCreateSession(caseKey string) *Session {
 		return s
 	}
 
-	newSession := NewSession()
+	newSession := NewSession(channels)
 	sessionStore[caseKey] = newSession
 	return newSession
 }
 
+// GetSession returns the session of a caseKey, if any
+func GetSession(caseKey string) (*Session, bool) {
+	sessionMutex.Lock()
+	defer sessionMutex.Unlock()
+	s, ok := sessionStore[caseKey]
+	return s, ok
+}
+
+// CaseKeys returns the case key of every session, sorted
+func CaseKeys() []string {
+	sessionMutex.Lock()
+	defer sessionMutex.Unlock()
+	keys := make([]string, 0, len(sessionStore))
+	for key := range sessionStore {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// MarshalJSON encodes the session like it is persisted, flags, channel states and payloads
+func (s *Session) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.state())
+}
+
 func ClearSession(caseKey string) {
 	sessionMutex.Lock()
 	defer sessionMutex.Unlock()
@@ -0,0 +1,69 @@
+package session
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Run with -race: the handlers update a session from one goroutine per weight trigger
+func TestConcurrentAccess(t *testing.T) {
+	channels := []string{"ch1", "ch2", "ch3", "ch4"}
+	s := NewSession(channels)
+
+	var wg sync.WaitGroup
+	for i, channel := range channels {
+		wg.Add(1)
+		go func(i int, channel string) {
+			defer wg.Done()
+			for n := 0; n < 100; n++ {
+				s.SetField("weight"+channel+"_", channel+"_weighing", float64(n))
+				s.UpdateChannel(channel, func(state *ChannelState) {
+					state.WeightTrigger = n%2 == 0
+					state.PrevWeightTrigger = true
+					state.PrevWeightValue = float64(n)
+				})
+				s.SetProcessing(i%2 == 0)
+				s.StartCycle(func() string { return fmt.Sprintf("cycle-%d", i) }, time.Unix(int64(n), 0))
+				_ = s.Merge(s.PayloadKeys()...)
+				_ = s.Payload("weight" + channel + "_")
+				_ = s.Channel(channel)
+				_ = s.IsProcessing()
+			}
+		}(i, channel)
+	}
+	wg.Wait()
+
+	for _, channel := range s.Channels() {
+		if state := s.Channel(channel); !state.PrevWeightTrigger || state.PrevWeightValue != 99 {
+			t.Errorf("Expected %s to keep its last weighing, got %+v", channel, state)
+		}
+		if got := s.Payload("weight" + channel + "_")[channel+"_weighing"]; got != 99.0 {
+			t.Errorf("Expected %s weighing 99, got %v", channel, got)
+		}
+	}
+}
+
+func TestPayloadIsACopy(t *testing.T) {
+	s := NewSession([]string{"ch1"})
+	s.SetField("ch1", "ch1_fill", 1)
+
+	payload := s.Payload("ch1")
+	payload["ch1_fill"] = 2
+	if got := s.Payload("ch1")["ch1_fill"]; got != 1 {
+		t.Errorf("Expected the stored payload to be unchanged, got %v", got)
+	}
+	if s.Payload("missing") != nil {
+		t.Error("Expected nil for a missing payload")
+	}
+
+	s.ClearPayloads("ch1")
+	if len(s.Payload("ch1")) != 0 {
+		t.Error("Expected ch1 to be cleared")
+	}
+	s.DeletePayloads()
+	if len(s.PayloadKeys()) != 0 {
+		t.Error("Expected every payload to be deleted")
+	}
+}
@@ -0,0 +1,245 @@
+package session
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"gopatch/internal/clock"
+)
+
+// SnapshotVersion is the layout of the snapshot file written by Persister.
+// Bump it when sessionState changes incompatibly and teach decodeSnapshot the old layout.
+const SnapshotVersion = 1
+
+// snapshot is the file written by Persister, every session by case key
+type snapshot struct {
+	Version  int                     `json:"version"`
+	SavedAt  time.Time               `json:"saved_at"`
+	Sessions map[string]sessionState `json:"sessions"`
+}
+
+// sessionState is the persisted part of a Session
+type sessionState struct {
+	PrevSealing          float64                   `json:"prev_sealing"`
+	IsProcessing         bool                      `json:"is_processing"`
+	AllSuccessZero       bool                      `json:"all_success_zero"`
+	CycleStartedAt       time.Time                 `json:"cycle_started_at"`
+	CycleID              string                    `json:"cycle_id"`
+	Channels             []string                  `json:"channels"`
+	ChannelStates        map[string]channelState   `json:"channel_states"`
+	ProcessedPayloadsMap map[string]map[string]any `json:"processed_payloads"`
+}
+
+type channelState struct {
+	WeightTrigger     bool    `json:"weight_trigger"`
+	PrevWeightTrigger bool    `json:"prev_weight_trigger"`
+	PrevWeightValue   float64 `json:"prev_weight_value"`
+	Emitted           bool    `json:"emitted"`
+}
+
+// Restore loads the sessions saved to path, replacing the sessions of the same case keys.
+// A missing file is not an error; it returns the number of restored sessions.
+func Restore(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read session snapshot: %w", err)
+	}
+
+	snap, err := decodeSnapshot(data)
+	if err != nil {
+		return 0, err
+	}
+
+	sessionMutex.Lock()
+	defer sessionMutex.Unlock()
+	for caseKey, state := range snap.Sessions {
+		sessionStore[caseKey] = state.session()
+	}
+	return len(snap.Sessions), nil
+}
+
+// decodeSnapshot parses a snapshot of any supported version
+func decodeSnapshot(data []byte) (snapshot, error) {
+	var header struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return snapshot{}, fmt.Errorf("invalid session snapshot: %w", err)
+	}
+
+	switch header.Version {
+	case 1:
+		var snap snapshot
+		if err := json.Unmarshal(data, &snap); err != nil {
+			return snapshot{}, fmt.Errorf("invalid session snapshot: %w", err)
+		}
+		return snap, nil
+	default:
+		return snapshot{}, fmt.Errorf("unsupported session snapshot version %d, expected %d", header.Version, SnapshotVersion)
+	}
+}
+
+// states copies the persisted part of every session
+func states() map[string]sessionState {
+	sessionMutex.Lock()
+	defer sessionMutex.Unlock()
+
+	sessions := make(map[string]sessionState, len(sessionStore))
+	for caseKey, s := range sessionStore {
+		sessions[caseKey] = s.state()
+	}
+	return sessions
+}
+
+func (s *Session) state() sessionState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state := sessionState{
+		PrevSealing:          s.prevSealing,
+		IsProcessing:         s.isProcessing,
+		AllSuccessZero:       s.allSuccessZero,
+		CycleStartedAt:       s.cycleStartedAt,
+		CycleID:              s.cycleID,
+		Channels:             s.channels,
+		ChannelStates:        make(map[string]channelState, len(s.channelStates)),
+		ProcessedPayloadsMap: make(map[string]map[string]any, len(s.payloads)),
+	}
+	for key, payload := range s.payloads {
+		state.ProcessedPayloadsMap[key] = copyMap(payload)
+	}
+	for name, ch := range s.channelStates {
+		state.ChannelStates[name] = channelState(*ch)
+	}
+	return state
+}
+
+func (state sessionState) session() *Session {
+	s := NewSession(state.Channels)
+	s.prevSealing = state.PrevSealing
+	s.isProcessing = state.IsProcessing
+	s.allSuccessZero = state.AllSuccessZero
+	s.cycleStartedAt = state.CycleStartedAt
+	s.cycleID = state.CycleID
+
+	for key, payload := range state.ProcessedPayloadsMap {
+		for field, value := range payload {
+			payload[field] = restoreValue(value)
+		}
+		s.payloads[key] = payload
+	}
+	for name, cs := range state.ChannelStates {
+		*s.channel(name) = ChannelState(cs)
+	}
+	return s
+}
+
+// restoreValue turns JSON number arrays back into []float64, e.g. the pica1 samples of case 5
+func restoreValue(value any) any {
+	list, ok := value.([]any)
+	if !ok {
+		return value
+	}
+	numbers := make([]float64, 0, len(list))
+	for _, item := range list {
+		n, ok := item.(float64)
+		if !ok {
+			return value
+		}
+		numbers = append(numbers, n)
+	}
+	return numbers
+}
+
+// writeFileAtomic writes to a temporary file next to path and renames it over path
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create session snapshot: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write session snapshot: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync session snapshot: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write session snapshot: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace session snapshot: %w", err)
+	}
+	return nil
+}
+
+// Persister snapshots the sessions to a file at most once per interval, and only when they changed.
+// Call Checkpoint between batches, while no handler is changing a session, and once per interval
+// while no batch comes in, so the last changes before an idle period are saved too.
+type Persister struct {
+	mu       sync.Mutex
+	path     string
+	interval time.Duration // 0 saves after every changed batch
+	clk      clock.Clock
+	lastSave time.Time
+	last     []byte // Sessions of the last save
+}
+
+func NewPersister(path string, interval time.Duration, clk clock.Clock) *Persister {
+	return &Persister{path: path, interval: interval, clk: clk}
+}
+
+// Checkpoint saves the sessions when the interval has passed since the last save
+func (p *Persister) Checkpoint() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.lastSave.IsZero() && p.clk.Since(p.lastSave) < p.interval {
+		return nil
+	}
+	return p.save()
+}
+
+// Flush saves the sessions regardless of the interval, e.g. on shutdown
+func (p *Persister) Flush() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.save()
+}
+
+func (p *Persister) save() error {
+	now := p.clk.Now()
+	sessions := states()
+
+	// An unchanged state is not written again
+	state, err := json.Marshal(sessions)
+	if err != nil {
+		return fmt.Errorf("failed to encode sessions: %w", err)
+	}
+	if p.last != nil && bytes.Equal(state, p.last) {
+		p.lastSave = now
+		return nil
+	}
+
+	data, err := json.Marshal(snapshot{Version: SnapshotVersion, SavedAt: now, Sessions: sessions})
+	if err != nil {
+		return fmt.Errorf("failed to encode sessions: %w", err)
+	}
+	if err := writeFileAtomic(p.path, data); err != nil {
+		return err
+	}
+	p.lastSave, p.last = now, state
+	return nil
+}
@@ -0,0 +1,91 @@
+package session
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gopatch/internal/clock"
+)
+
+func TestPersistAndRestore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.json")
+	t.Cleanup(func() { ClearSession("POST_d800,holdfillingweight") })
+
+	s := GetOrCreateSession("POST_d800,holdfillingweight", []string{"ch1", "ch2"})
+	s.SetProcessing(true)
+	s.SetCycle("cycle-1", time.Time{})
+	s.SetField("ch1_", "ch1_fill", 1.0)
+	s.SetField("degas", "pica1", []float64{1, 2.5})
+	s.UpdateChannel("ch2", func(state *ChannelState) {
+		state.PrevWeightTrigger = true
+		state.PrevWeightValue = 101.5
+	})
+
+	clk := clock.NewFake(time.Date(2025, 1, 1, 8, 0, 0, 0, time.UTC))
+	p := NewPersister(path, time.Second, clk)
+	if err := p.Checkpoint(); err != nil {
+		t.Fatalf("Checkpoint: %v", err)
+	}
+
+	// Within the interval nothing is written
+	s.SetField("ch2_", "ch2_fill", 1.0)
+	os.Remove(path)
+	if err := p.Checkpoint(); err != nil {
+		t.Fatalf("Checkpoint: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatal("Expected no snapshot within the interval")
+	}
+	clk.Advance(time.Second)
+	if err := p.Checkpoint(); err != nil {
+		t.Fatalf("Checkpoint: %v", err)
+	}
+
+	// A restart starts with an empty store
+	ClearSession("POST_d800,holdfillingweight")
+	restored, err := Restore(path)
+	if err != nil || restored != 1 {
+		t.Fatalf("Expected 1 restored session, got %d: %v", restored, err)
+	}
+
+	got := GetOrCreateSession("POST_d800,holdfillingweight", nil)
+	if id, _ := got.Cycle(); !got.IsProcessing() || id != "cycle-1" || len(got.Channels()) != 2 {
+		t.Errorf("Unexpected restored session %+v", got.state())
+	}
+	if got.Payload("ch1_")["ch1_fill"] != 1.0 || got.Payload("ch2_")["ch2_fill"] != 1.0 {
+		t.Errorf("Unexpected restored payloads %v", got.state().ProcessedPayloadsMap)
+	}
+	if samples, ok := got.Payload("degas")["pica1"].([]float64); !ok || len(samples) != 2 {
+		t.Errorf("Expected pica1 samples as []float64, got %#v", got.Payload("degas")["pica1"])
+	}
+	if ch2 := got.Channel("ch2"); !ch2.PrevWeightTrigger || ch2.PrevWeightValue != 101.5 {
+		t.Errorf("Unexpected restored channel state %+v", ch2)
+	}
+}
+
+func TestRestoreSnapshotVersions(t *testing.T) {
+	dir := t.TempDir()
+
+	if restored, err := Restore(filepath.Join(dir, "missing.json")); err != nil || restored != 0 {
+		t.Errorf("Expected a missing snapshot to restore nothing, got %d: %v", restored, err)
+	}
+
+	newer := filepath.Join(dir, "newer.json")
+	os.WriteFile(newer, []byte(`{"version": 99, "sessions": {"x": {"layout": "unknown"}}}`), 0644)
+	if _, err := Restore(newer); err == nil {
+		t.Error("Expected an error for an unsupported snapshot version")
+	}
+
+	// Fields unknown to this version are ignored
+	v1 := filepath.Join(dir, "v1.json")
+	os.WriteFile(v1, []byte(`{"version": 1, "sessions": {"POST_m100,hold": {"prev_sealing": 1, "extra": true}}}`), 0644)
+	t.Cleanup(func() { ClearSession("POST_m100,hold") })
+	if restored, err := Restore(v1); err != nil || restored != 1 {
+		t.Fatalf("Expected 1 restored session, got %d: %v", restored, err)
+	}
+	if s := GetOrCreateSession("POST_m100,hold", nil); s.PrevSealing() != 1 {
+		t.Errorf("Expected PrevSealing 1, got %v", s.PrevSealing())
+	}
+}
@@ -0,0 +1,264 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gopatch/config"
+	"gopatch/internal/clock"
+	"gopatch/internal/cycle"
+	"gopatch/internal/metrics"
+	"gopatch/patch"
+)
+
+// Batch collects records and POSTs them to URL as one JSON array once MaxItems are collected
+// or MaxWait passed, so the per-request latency is paid once per batch (PostgREST inserts an
+// array in one statement). Write only queues the record, or sends the batch with its context once
+// full; a record that could not be written is handed to Failed.
+type Batch struct {
+	URL      string
+	Key      string
+	Auth     patch.Authorizer                                 // Sent instead of Key when set
+	Header   http.Header                                      // Optional
+	MaxItems int                                              // Records per request, 1 or less sends every record on its own
+	MaxWait  time.Duration                                    // Longest a record waits for the batch to fill, 0 sends it at once
+	Retry    Retry                                            // Retries of a request, its Sink is not used
+	Failed   func(ctx context.Context, rec Record, err error) // Optional
+
+	mu      sync.Mutex
+	pending []batched
+	stop    chan struct{} // Closed by Close, stops the MaxWait ticker
+	done    chan struct{}
+	sendMu  sync.Mutex // Held while sending, keeps the batches in order
+}
+
+// batched is a queued record with the cycle ID of the context it was written with
+type batched struct {
+	rec   Record
+	cycle string
+}
+
+func (b *Batch) Write(ctx context.Context, rec Record) error {
+	b.mu.Lock()
+	if b.stop == nil && b.MaxWait > 0 {
+		b.start()
+	}
+	b.pending = append(b.pending, batched{rec: rec, cycle: cycle.IDFrom(ctx)})
+	full := len(b.pending) >= b.MaxItems || b.MaxWait <= 0
+	b.mu.Unlock()
+
+	if full {
+		b.Flush(ctx)
+	}
+	return nil
+}
+
+// start sends the pending records every MaxWait, callers hold b.mu
+func (b *Batch) start() {
+	clk := b.Retry.Clock
+	if clk == nil {
+		clk = clock.Real
+	}
+	b.stop, b.done = make(chan struct{}), make(chan struct{})
+	ticker := clk.NewTicker(b.MaxWait)
+
+	go func() {
+		defer close(b.done)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-b.stop:
+				return
+			case <-ticker.C():
+				// No writer waits for this flush to take the context from
+				b.Flush(context.Background())
+			}
+		}
+	}()
+}
+
+// Flush sends every pending record and returns the errors of those not written
+func (b *Batch) Flush(ctx context.Context) error {
+	b.sendMu.Lock()
+	defer b.sendMu.Unlock()
+
+	b.mu.Lock()
+	pending := b.pending
+	b.pending = nil
+	b.mu.Unlock()
+
+	var errs []error
+	for len(pending) > 0 {
+		n := b.chunk(pending)
+		if err := b.send(ctx, pending[:n]); err != nil {
+			errs = append(errs, err)
+		}
+		pending = pending[n:]
+	}
+	return errors.Join(errs...)
+}
+
+// chunk returns how many of the records go into the next request: up to MaxItems with the same
+// fields, as PostgREST takes the columns of an array insert from its first object
+func (b *Batch) chunk(pending []batched) int {
+	n := 1
+	for n < len(pending) && n < b.MaxItems && sameKeys(pending[0].rec.Data, pending[n].rec.Data) {
+		n++
+	}
+	return n
+}
+
+func sameKeys(a, b map[string]any) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k := range a {
+		if _, ok := b[k]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// send writes the records as one array. An array rejected with a permanent error is sent again
+// record by record, so one bad record doesn't lose the others.
+func (b *Batch) send(ctx context.Context, items []batched) error {
+	rows := make([]map[string]any, len(items))
+	for i, it := range items {
+		rows[i] = it.rec.Data
+	}
+	body, err := json.Marshal(rows)
+	if err != nil {
+		return b.fail(ctx, items, fmt.Errorf("failed to encode batch: %w", err))
+	}
+
+	retry := b.Retry
+	retry.Sink = Func(func(ctx context.Context, _ Record) error {
+		_, err := patch.SendPatchRequest(patch.WithAuth(patch.WithHeader(ctx, b.Header), b.Auth), b.URL, b.Key, body, http.MethodPost)
+		return err
+	})
+	err = retry.Write(ctx, Record{Case: items[0].rec.Case})
+	if err == nil {
+		return nil
+	}
+	if len(items) == 1 || Retryable(err) {
+		return b.fail(ctx, items, err)
+	}
+
+	slog.WarnContext(ctx, "Batch rejected, sending its records one by one", "records", len(items), "url", b.URL, "err", err)
+	retry.Sink = Func(func(ctx context.Context, rec Record) error {
+		body, err := json.Marshal(rec.Data)
+		if err != nil {
+			return fmt.Errorf("failed to encode record: %w", err)
+		}
+		_, err = patch.SendPatchRequest(patch.WithAuth(patch.WithHeader(ctx, b.Header), b.Auth), b.URL, b.Key, body, http.MethodPost)
+		return err
+	})
+	var errs []error
+	for _, it := range items {
+		if err := retry.Write(cycle.WithID(ctx, it.cycle), it.rec); err != nil {
+			errs = append(errs, b.fail(ctx, []batched{it}, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// fail reports the records as not written
+func (b *Batch) fail(ctx context.Context, items []batched, err error) error {
+	for _, it := range items {
+		ctx := cycle.WithID(ctx, it.cycle)
+		metrics.SinkFailures.WithLabelValues(it.rec.Case).Inc()
+		slog.ErrorContext(ctx, "Failed to write batched record", "case", it.rec.Case, "err", err)
+		if b.Failed != nil {
+			b.Failed(ctx, it.rec, err)
+		}
+	}
+	return err
+}
+
+// Close stops the MaxWait ticker and sends the pending records
+func (b *Batch) Close(ctx context.Context) error {
+	b.mu.Lock()
+	stop, done := b.stop, b.done
+	b.mu.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+	return b.Flush(ctx)
+}
+
+var (
+	batchesMu   sync.Mutex
+	batches     = map[string]*Batch{}
+	batchFailed atomic.Pointer[func(ctx context.Context, url string, rec Record, err error)]
+	batchClock  atomic.Pointer[clock.Clock]
+)
+
+// Batched returns the batch of the API URL and headers, shared by every case writing to them
+func Batched(url string, header http.Header, cfg config.AppConfig, clk clock.Clock) *Batch {
+	batchesMu.Lock()
+	defer batchesMu.Unlock()
+
+	key := fmt.Sprint(url, " ", cfg.ServiceRoleKey, " ", cfg.APIAuth, " ", header)
+	if b, ok := batches[key]; ok {
+		return b
+	}
+	b := &Batch{
+		URL:      url,
+		Key:      cfg.ServiceRoleKey,
+		Auth:     AuthFor(cfg),
+		Header:   header,
+		MaxItems: cfg.BatchMaxItems,
+		MaxWait:  cfg.BatchMaxWait,
+		Retry:    Retry{MaxAttempts: cfg.RetryMaxAttempts, BaseDelay: cfg.RetryBaseDelay, MaxDelay: cfg.RetryMaxDelay, Clock: clk},
+		Failed: func(ctx context.Context, rec Record, err error) {
+			if fn := batchFailed.Load(); fn != nil {
+				(*fn)(ctx, url, rec, err)
+			}
+		},
+	}
+	batches[key] = b
+	return b
+}
+
+// OnBatchFailure sets the function called for every batched record that could not be written,
+// with the URL it went to
+func OnBatchFailure(fn func(ctx context.Context, url string, rec Record, err error)) {
+	batchFailed.Store(&fn)
+}
+
+// SetBatchClock sets the clock of the batches created from now on, clock.Real by default
+func SetBatchClock(clk clock.Clock) {
+	batchClock.Store(&clk)
+}
+
+// batchClockOrReal returns the clock set with SetBatchClock, or the wall clock
+func batchClockOrReal() clock.Clock {
+	if clk := batchClock.Load(); clk != nil {
+		return *clk
+	}
+	return clock.Real
+}
+
+// CloseBatches closes every batch, sending their pending records; the batches are created again on the next write
+func CloseBatches(ctx context.Context) error {
+	batchesMu.Lock()
+	closing := maps.Clone(batches)
+	clear(batches)
+	batchesMu.Unlock()
+
+	var errs []error
+	for _, b := range closing {
+		errs = append(errs, b.Close(ctx))
+	}
+	return errors.Join(errs...)
+}
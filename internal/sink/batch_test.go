@@ -0,0 +1,129 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// arrayServer records the rows of every request; a request with a row having "bad" set is rejected
+type arrayServer struct {
+	mu       sync.Mutex
+	requests [][]map[string]any
+}
+
+func (s *arrayServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	var rows []map[string]any
+	if err := json.Unmarshal(body, &rows); err != nil {
+		var row map[string]any
+		json.Unmarshal(body, &row)
+		rows = []map[string]any{row}
+	}
+
+	s.mu.Lock()
+	s.requests = append(s.requests, rows)
+	s.mu.Unlock()
+
+	for _, row := range rows {
+		if row["bad"] != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *arrayServer) sizes() []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var sizes []int
+	for _, rows := range s.requests {
+		sizes = append(sizes, len(rows))
+	}
+	return sizes
+}
+
+func TestBatchMaxItems(t *testing.T) {
+	api := &arrayServer{}
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	b := &Batch{URL: server.URL, Key: "key", MaxItems: 3, MaxWait: time.Hour}
+	for i := range 4 {
+		b.Write(context.Background(), Record{Case: "hold", Data: map[string]any{"n": i}})
+	}
+	if sizes := api.sizes(); len(sizes) != 1 || sizes[0] != 3 {
+		t.Fatalf("Expected one request of 3 records once full, got %v", sizes)
+	}
+
+	// The rest is sent on Close
+	if err := b.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if sizes := api.sizes(); len(sizes) != 2 || sizes[1] != 1 {
+		t.Errorf("Expected the last record sent on Close, got %v", sizes)
+	}
+}
+
+func TestBatchMaxWait(t *testing.T) {
+	api := &arrayServer{}
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	b := &Batch{URL: server.URL, Key: "key", MaxItems: 100, MaxWait: 10 * time.Millisecond}
+	defer b.Close(context.Background())
+	b.Write(context.Background(), Record{Case: "hold", Data: map[string]any{"n": 1}})
+	b.Write(context.Background(), Record{Case: "hold", Data: map[string]any{"n": 2}})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(api.sizes()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if sizes := api.sizes(); len(sizes) != 1 || sizes[0] != 2 {
+		t.Errorf("Expected both records in one request after MaxWait, got %v", sizes)
+	}
+}
+
+func TestBatchSplitsByFields(t *testing.T) {
+	api := &arrayServer{}
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	b := &Batch{URL: server.URL, Key: "key", MaxItems: 10, MaxWait: time.Hour}
+	b.Write(context.Background(), Record{Case: "hold", Data: map[string]any{"ch1": 1}})
+	b.Write(context.Background(), Record{Case: "hold", Data: map[string]any{"ch1": 2}})
+	b.Write(context.Background(), Record{Case: "degas", Data: map[string]any{"degas": 1}})
+	if err := b.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if sizes := api.sizes(); len(sizes) != 2 || sizes[0] != 2 || sizes[1] != 1 {
+		t.Errorf("Expected one request per set of fields, got %v", sizes)
+	}
+}
+
+func TestBatchPartialFailure(t *testing.T) {
+	api := &arrayServer{}
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	var failed []any
+	b := &Batch{URL: server.URL, Key: "key", MaxItems: 3, MaxWait: time.Hour,
+		Failed: func(_ context.Context, rec Record, err error) { failed = append(failed, rec.Data["n"]) }}
+	b.Write(context.Background(), Record{Case: "hold", Data: map[string]any{"n": 1, "bad": nil}})
+	b.Write(context.Background(), Record{Case: "hold", Data: map[string]any{"n": 2, "bad": true}})
+	b.Write(context.Background(), Record{Case: "hold", Data: map[string]any{"n": 3, "bad": nil}})
+
+	// The array is rejected, then every record is sent on its own
+	if sizes := api.sizes(); len(sizes) != 4 || sizes[0] != 3 {
+		t.Fatalf("Expected the array and 3 single requests, got %v", sizes)
+	}
+	if len(failed) != 1 || failed[0] != 2 {
+		t.Errorf("Expected only the bad record failed, got %v", failed)
+	}
+}
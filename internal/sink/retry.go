@@ -0,0 +1,106 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"math/rand/v2"
+	"net"
+	"net/url"
+	"syscall"
+	"time"
+
+	"gopatch/internal/clock"
+	"gopatch/internal/metrics"
+)
+
+// Retry repeats the writes of Sink that fail with a retryable error, waiting an exponential
+// backoff with jitter between the attempts, and gives up after MaxAttempts
+type Retry struct {
+	Sink        Sink
+	MaxAttempts int           // Attempts including the first one, 1 or less never retries
+	BaseDelay   time.Duration // Delay before the first retry, doubled before every next one
+	MaxDelay    time.Duration // Upper bound of the delay, 0 for none
+	Clock       clock.Clock
+}
+
+func (r Retry) Write(ctx context.Context, rec Record) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = r.Sink.Write(ctx, rec); err == nil {
+			return nil
+		}
+		if !Retryable(err) {
+			return err
+		}
+		if attempt >= r.MaxAttempts {
+			return fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+		}
+		if ctx.Err() != nil {
+			return err
+		}
+
+		delay := Backoff(r.BaseDelay, r.MaxDelay, attempt)
+		metrics.SinkRetries.WithLabelValues(rec.Case).Inc()
+		slog.WarnContext(ctx, "Write failed, retrying", "case", rec.Case, "attempt", attempt, "max_attempts", r.MaxAttempts,
+			"delay", delay, "err", err)
+		if err := r.sleep(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// sleep waits d, returns ctx.Err() when ctx is done first
+func (r Retry) sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := r.Clock.NewTicker(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C():
+		return nil
+	}
+}
+
+// Backoff returns the delay after the given attempt: base doubled per attempt up to max (0 for none),
+// of which a random half is taken off so retries of several records don't line up
+func Backoff(base, max time.Duration, attempt int) time.Duration {
+	delay := base
+	for i := 1; i < attempt && (max <= 0 || delay < max) && delay <= math.MaxInt64/2; i++ {
+		delay *= 2
+	}
+	if max > 0 && delay > max {
+		delay = max
+	}
+	if delay <= 0 {
+		return 0
+	}
+	half := delay / 2
+	return half + rand.N(delay-half+1)
+}
+
+// Retryable reports whether a failed write may succeed when repeated: a 5xx, 408 or 429 response,
+// a request that timed out or whose connection was refused or reset, or an error reporting Temporary.
+// Other errors, like a 4xx response, an unsupported URL, a bad certificate or a record that can't be
+// encoded, are permanent.
+func Retryable(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	var temp interface{ Temporary() bool }
+	var urlErr *url.Error
+	var netErr net.Error
+	switch {
+	case errors.As(err, &urlErr):
+		return errors.As(urlErr.Err, &netErr) && netErr.Timeout() ||
+			errors.Is(urlErr.Err, syscall.ECONNREFUSED) || errors.Is(urlErr.Err, syscall.ECONNRESET)
+	case errors.As(err, &temp):
+		return temp.Temporary()
+	}
+	return false
+}
@@ -0,0 +1,155 @@
+package sink
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"syscall"
+	"testing"
+	"time"
+
+	"gopatch/internal/clock"
+	"gopatch/patch"
+)
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"503", &patch.StatusError{Code: http.StatusServiceUnavailable}, true},
+		{"429", fmt.Errorf("wrapped: %w", &patch.StatusError{Code: http.StatusTooManyRequests}), true},
+		{"400", &patch.StatusError{Code: http.StatusBadRequest}, false},
+		{"409", &patch.StatusError{Code: http.StatusConflict}, false},
+		{"canceled", context.Canceled, false},
+		{"other", errors.New("failed to encode record"), false},
+		{"timeout", &url.Error{Op: "Post", URL: "http://api", Err: context.DeadlineExceeded}, true},
+		{"reset", &url.Error{Op: "Post", URL: "http://api", Err: &net.OpError{Op: "read", Err: syscall.ECONNRESET}}, true},
+		{"scheme", &url.Error{Op: "Post", URL: "ftp://api", Err: errors.New(`unsupported protocol scheme "ftp"`)}, false},
+		{"certificate", &url.Error{Op: "Post", URL: "https://api", Err: &tls.CertificateVerificationError{Err: errors.New("unknown authority")}}, false},
+	}
+	for _, tt := range tests {
+		if got := Retryable(tt.err); got != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+
+	// No response at all, e.g. a refused connection
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+	_, err := patch.SendPatchRequest(context.Background(), server.URL, "key", []byte(`{}`), http.MethodPatch)
+	if !Retryable(err) {
+		t.Errorf("Expected a refused connection to be retryable, got %v", err)
+	}
+}
+
+// steppingClock fires every ticker as soon as it is created, so a backoff passes without waiting
+type steppingClock struct{ *clock.Fake }
+
+func (c steppingClock) NewTicker(d time.Duration) clock.Ticker {
+	t := c.Fake.NewTicker(d)
+	c.Advance(d)
+	return t
+}
+
+func TestRetry(t *testing.T) {
+	clk := steppingClock{clock.NewFake(time.Date(2025, 1, 1, 8, 0, 0, 0, time.UTC))}
+	start := clk.Now()
+
+	var attempts int
+	failing := Func(func(context.Context, Record) error {
+		attempts++
+		if attempts < 3 {
+			return &patch.StatusError{Code: http.StatusBadGateway}
+		}
+		return nil
+	})
+
+	retry := Retry{Sink: failing, MaxAttempts: 5, BaseDelay: time.Second, MaxDelay: 10 * time.Second, Clock: clk}
+	if err := retry.Write(context.Background(), Record{Case: "hold"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if attempts != 3 {
+		t.Errorf("Expected 3 attempts, got %d", attempts)
+	}
+	// Two backoffs of 1s and 2s, each with up to half taken off by the jitter
+	if waited := clk.Since(start); waited < 1500*time.Millisecond || waited > 3*time.Second {
+		t.Errorf("Expected to wait between 1.5s and 3s, waited %s", waited)
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	clk := steppingClock{clock.NewFake(time.Date(2025, 1, 1, 8, 0, 0, 0, time.UTC))}
+
+	var attempts int
+	unavailable := Func(func(context.Context, Record) error {
+		attempts++
+		return &patch.StatusError{Code: http.StatusServiceUnavailable}
+	})
+	err := Retry{Sink: unavailable, MaxAttempts: 4, BaseDelay: time.Second, Clock: clk}.Write(context.Background(), Record{})
+	var status *patch.StatusError
+	if !errors.As(err, &status) || attempts != 4 {
+		t.Errorf("Expected the 503 after 4 attempts, got %v after %d", err, attempts)
+	}
+
+	// A permanent error is not repeated
+	attempts = 0
+	rejected := Func(func(context.Context, Record) error {
+		attempts++
+		return &patch.StatusError{Code: http.StatusUnprocessableEntity}
+	})
+	if err := (Retry{Sink: rejected, MaxAttempts: 4, Clock: clk}).Write(context.Background(), Record{}); err == nil || attempts != 1 {
+		t.Errorf("Expected one attempt for a 422, got %d (%v)", attempts, err)
+	}
+}
+
+// waitingClock reports on waiting when a backoff starts, its time never moves
+type waitingClock struct {
+	*clock.Fake
+	waiting chan struct{}
+}
+
+func (c waitingClock) NewTicker(d time.Duration) clock.Ticker {
+	close(c.waiting)
+	return c.Fake.NewTicker(d)
+}
+
+func TestRetryCanceledWhileWaiting(t *testing.T) {
+	clk := waitingClock{clock.NewFake(time.Date(2025, 1, 1, 8, 0, 0, 0, time.UTC)), make(chan struct{})}
+	unavailable := Func(func(context.Context, Record) error {
+		return &patch.StatusError{Code: http.StatusServiceUnavailable}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- Retry{Sink: unavailable, MaxAttempts: 5, BaseDelay: time.Minute, Clock: clk}.Write(ctx, Record{})
+	}()
+	<-clk.waiting
+	cancel()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected the cancellation, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Retry kept waiting after ctx was canceled")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for attempt, max := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 10: 5 * time.Second} {
+		for i := 0; i < 20; i++ {
+			if d := Backoff(time.Second, 5*time.Second, attempt); d < max/2 || d > max {
+				t.Errorf("Attempt %d: expected a delay between %s and %s, got %s", attempt, max/2, max, d)
+			}
+		}
+	}
+}
@@ -0,0 +1,342 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+
+	"gopatch/config"
+	"gopatch/internal/app"
+	"gopatch/internal/auth"
+	"gopatch/internal/writeback"
+	"gopatch/patch"
+)
+
+// Record is one record emitted by a case
+type Record struct {
+	Case string         // Case key that produced the record, e.g. "holdfillingweight"
+	Data map[string]any // Fields of the record
+}
+
+// Sink is an output the records are written to
+type Sink interface {
+	Write(ctx context.Context, rec Record) error
+}
+
+// Func adapts a function to a Sink
+type Func func(ctx context.Context, rec Record) error
+
+func (f Func) Write(ctx context.Context, rec Record) error {
+	return f(ctx, rec)
+}
+
+// Multi writes every record to each of its sinks, one failing doesn't stop the others
+type Multi []Sink
+
+func (m Multi) Write(ctx context.Context, rec Record) error {
+	var errs []error
+	for _, s := range m {
+		if err := s.Write(ctx, rec); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// REST sends the record as JSON body with Method to URL, e.g. PATCH or POST.
+// URL, Method and Headers are templates evaluated against the record, e.g. ?ink_lot=eq.{{.ink_lot}}
+// With PLC set, the response is written back following WriteBack; PostgREST only answers
+// a PATCH or POST with the row when asked to with a "Prefer: return=representation" header.
+type REST struct {
+	URL       string
+	Method    string
+	Key       string            // Service role key, sent as apikey and bearer token
+	Auth      patch.Authorizer  // Sent instead of Key when set
+	Headers   map[string]string // Optional
+	WriteBack writeback.Mapping
+	PLC       app.PLCWriter
+}
+
+func (s REST) Write(ctx context.Context, rec Record) error {
+	e, err := expandEndpoint(s.URL, s.Method, s.Headers, rec.Data)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(rec.Data)
+	if err != nil {
+		return fmt.Errorf("failed to encode record: %w", err)
+	}
+	resp, err := patch.SendPatchRequest(patch.WithAuth(patch.WithHeader(ctx, e.header), s.Auth), e.url, s.Key, body, e.method)
+	if err != nil {
+		return err
+	}
+	writeBack(ctx, s.WriteBack, s.PLC, resp)
+	return nil
+}
+
+// RPC calls the PostgREST stored procedure at URL, e.g. /rest/v1/rpc/record_filling,
+// with the fields of the record as its named arguments. With PLC set, the result is written
+// back following WriteBack.
+type RPC struct {
+	URL       string
+	Key       string
+	Auth      patch.Authorizer
+	Headers   map[string]string
+	WriteBack writeback.Mapping
+	PLC       app.PLCWriter
+}
+
+func (s RPC) Write(ctx context.Context, rec Record) error {
+	e, err := expandEndpoint(s.URL, "", s.Headers, rec.Data)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(rec.Data)
+	if err != nil {
+		return fmt.Errorf("failed to encode record: %w", err)
+	}
+	resp, err := patch.SendRPCRequest(patch.WithAuth(patch.WithHeader(ctx, e.header), s.Auth), e.url, s.Key, body)
+	if err != nil {
+		return err
+	}
+	writeBack(ctx, s.WriteBack, s.PLC, resp)
+	return nil
+}
+
+// Upsert upserts the record with PostgREST: a record conflicting with an existing row on the
+// OnConflict columns (or the ?on_conflict=cycle_id of URL) is merged into it, or skipped
+// with Resolution ignore. With PLC set, the written row is asked for and written back following WriteBack.
+type Upsert struct {
+	URL        string
+	Key        string
+	Auth       patch.Authorizer
+	Headers    map[string]string
+	OnConflict string
+	Resolution string // patch.ResolutionMerge (default) or patch.ResolutionIgnore
+	WriteBack  writeback.Mapping
+	PLC        app.PLCWriter
+}
+
+func (s Upsert) Write(ctx context.Context, rec Record) error {
+	e, err := expandEndpoint(s.URL, "", s.Headers, rec.Data)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(rec.Data)
+	if err != nil {
+		return fmt.Errorf("failed to encode record: %w", err)
+	}
+	opts := patch.UpsertOptions{OnConflict: s.OnConflict, Resolution: s.Resolution, Return: len(s.WriteBack) > 0 && s.PLC != nil}
+	resp, err := patch.SendPostgRESTUpsert(patch.WithAuth(patch.WithHeader(ctx, e.header), s.Auth), e.url, s.Key, body, opts)
+	if err != nil {
+		return err
+	}
+	writeBack(ctx, s.WriteBack, s.PLC, resp)
+	return nil
+}
+
+// writeBack writes the values of the response mapped by m to the PLC. The record is written
+// already, so a failure is logged instead of failing it.
+func writeBack(ctx context.Context, m writeback.Mapping, plc app.PLCWriter, resp []byte) {
+	if plc == nil {
+		return
+	}
+	if err := m.Apply(ctx, resp, plc); err != nil {
+		slog.ErrorContext(ctx, "PLC write-back failed", "err", err)
+	}
+}
+
+// byChannel writes the records of each channel with an endpoint of its own to its sink,
+// the other records to Default
+type byChannel struct {
+	Field    string // Record field carrying the channel
+	Channels map[string]Sink
+	Default  Sink
+}
+
+func (s byChannel) Write(ctx context.Context, rec Record) error {
+	channel, _ := rec.Data[s.Field].(string)
+	if channelSink, ok := s.Channels[channel]; ok {
+		return channelSink.Write(ctx, rec)
+	}
+	return s.Default.Write(ctx, rec)
+}
+
+// unbatchedKey marks a context whose writes must not be batched, see WithoutBatching
+type unbatchedKey struct{}
+
+// WithoutBatching returns a copy of ctx whose writes to the batch sink are sent at once as single
+// records, for a caller that must know the record was written, like the outbox worker acknowledging it
+func WithoutBatching(ctx context.Context) context.Context {
+	return context.WithValue(ctx, unbatchedKey{}, true)
+}
+
+func unbatched(ctx context.Context) bool {
+	v, _ := ctx.Value(unbatchedKey{}).(bool)
+	return v
+}
+
+// Builder creates a sink from the configuration; plc receives the write-back (WRITEBACK), nil where none is wanted
+type Builder func(cfg config.AppConfig, plc app.PLCWriter) Sink
+
+func upsert(cfg config.AppConfig, plc app.PLCWriter) Sink {
+	return Upsert{URL: cfg.APIUrl, Key: cfg.ServiceRoleKey, Auth: AuthFor(cfg), Headers: cfg.APIHeaders,
+		OnConflict: cfg.UpsertOnConflict, Resolution: cfg.UpsertResolution, WriteBack: cfg.WriteBack, PLC: plc}
+}
+
+// Merged returns the upsert sink the channel records of a cycle merge into (MERGE_CHANNELS),
+// conflicting on CYCLE_ID_FIELD unless UPSERT_ON_CONFLICT is set
+func Merged(cfg config.AppConfig, plc app.PLCWriter) Sink {
+	if cfg.UpsertOnConflict == "" {
+		cfg.UpsertOnConflict = cfg.CycleIDField
+	}
+	return upsert(cfg, plc)
+}
+
+var (
+	buildersMu sync.RWMutex
+	builders   = map[string]Builder{
+		"rest": func(cfg config.AppConfig, plc app.PLCWriter) Sink {
+			return REST{URL: cfg.APIUrl, Method: cfg.Function, Key: cfg.ServiceRoleKey, Auth: AuthFor(cfg), Headers: cfg.APIHeaders, WriteBack: cfg.WriteBack, PLC: plc}
+		},
+		"patch": func(cfg config.AppConfig, plc app.PLCWriter) Sink {
+			return REST{URL: cfg.APIUrl, Method: "PATCH", Key: cfg.ServiceRoleKey, Auth: AuthFor(cfg), Headers: cfg.APIHeaders, WriteBack: cfg.WriteBack, PLC: plc}
+		},
+		"post": func(cfg config.AppConfig, plc app.PLCWriter) Sink {
+			return REST{URL: cfg.APIUrl, Method: "POST", Key: cfg.ServiceRoleKey, Auth: AuthFor(cfg), Headers: cfg.APIHeaders, WriteBack: cfg.WriteBack, PLC: plc}
+		},
+		"merge":     upsert, // Names of upsert kept for existing configurations
+		"postgrest": upsert,
+		"rpc": func(cfg config.AppConfig, plc app.PLCWriter) Sink {
+			return RPC{URL: cfg.APIUrl, Key: cfg.ServiceRoleKey, Auth: AuthFor(cfg), Headers: cfg.APIHeaders, WriteBack: cfg.WriteBack, PLC: plc}
+		},
+		"upsert": upsert,
+		"batch": func(cfg config.AppConfig, _ app.PLCWriter) Sink {
+			return Func(func(ctx context.Context, rec Record) error {
+				if unbatched(ctx) {
+					return REST{URL: cfg.APIUrl, Method: http.MethodPost, Key: cfg.ServiceRoleKey, Auth: AuthFor(cfg), Headers: cfg.APIHeaders}.Write(ctx, rec)
+				}
+				e, err := expandEndpoint(cfg.APIUrl, "", cfg.APIHeaders, rec.Data)
+				if err != nil {
+					return err
+				}
+				return Batched(e.url, e.header, cfg, batchClockOrReal()).Write(ctx, rec)
+			})
+		},
+	}
+)
+
+// AuthFor returns the provider of the API_AUTH profile of the endpoint in cfg, nil to send the service role key.
+// A profile that can't be used fails every request, so Check reports it at start.
+func AuthFor(cfg config.AppConfig) patch.Authorizer {
+	p, ok, err := cfg.Auth()
+	if err != nil {
+		return failedAuth{err}
+	}
+	if !ok {
+		return nil
+	}
+	a, err := auth.For(p)
+	if err != nil {
+		return failedAuth{err}
+	}
+	return a
+}
+
+// failedAuth fails the requests of an endpoint with an invalid auth profile
+type failedAuth struct {
+	err error
+}
+
+func (f failedAuth) Authorize(context.Context, *http.Request) error {
+	return f.err
+}
+
+// Register makes a sink available by name in SINK and SINK_<CASE>, replacing one of the same name
+func Register(name string, build Builder) {
+	buildersMu.Lock()
+	defer buildersMu.Unlock()
+	builders[name] = build
+}
+
+// For returns the sink the records of a case are written to, combining every sink of SINK_<CASE>,
+// with the endpoint of the case, or of the channel of the record (API_URL_<CASE>_<CHANNEL>)
+func For(caseKey string, cfg config.AppConfig, plc app.PLCWriter) (Sink, error) {
+	s, err := build(caseKey, cfg.EndpointFor(caseKey, ""), plc)
+	if err != nil {
+		return nil, err
+	}
+
+	channels := cfg.EndpointChannels(caseKey)
+	if len(channels) == 0 {
+		return s, nil
+	}
+	routed := byChannel{Field: cfg.ChannelField, Channels: make(map[string]Sink, len(channels)), Default: s}
+	for _, channel := range channels {
+		if routed.Channels[channel], err = build(caseKey, cfg.EndpointFor(caseKey, channel), plc); err != nil {
+			return nil, err
+		}
+	}
+	return routed, nil
+}
+
+// build creates the sinks of SINK_<CASE> with the endpoint in cfg
+func build(caseKey string, cfg config.AppConfig, plc app.PLCWriter) (Sink, error) {
+	names := cfg.SinksFor(caseKey)
+
+	buildersMu.RLock()
+	defer buildersMu.RUnlock()
+	sinks := make(Multi, 0, len(names))
+	for _, name := range names {
+		build, ok := builders[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown sink %q for case %q", name, caseKey)
+		}
+		sinks = append(sinks, build(cfg, plc))
+	}
+	if len(sinks) == 1 {
+		return sinks[0], nil
+	}
+	return sinks, nil
+}
+
+// Check reports the first unknown sink name, invalid endpoint template, auth profile or upsert resolution
+// in the configuration, so it fails at start instead of on the first record
+func Check(cfg config.AppConfig) error {
+	for caseKey := range cfg.Sinks {
+		if _, err := For(caseKey, cfg, nil); err != nil {
+			return err
+		}
+	}
+
+	endpoints := []config.Endpoint{{URL: cfg.APIUrl, Method: cfg.Function, Headers: cfg.APIHeaders, Auth: cfg.APIAuth, Resolution: cfg.UpsertResolution}}
+	for _, e := range cfg.Endpoints {
+		endpoints = append(endpoints, e)
+	}
+	for _, e := range endpoints {
+		texts := []string{e.URL, e.Method}
+		for _, text := range e.Headers {
+			texts = append(texts, text)
+		}
+		if err := checkTemplates(texts...); err != nil {
+			return err
+		}
+		if e.Auth != "" {
+			c := cfg
+			c.APIAuth = e.Auth
+			if failed, ok := AuthFor(c).(failedAuth); ok {
+				return failed.err
+			}
+		}
+		switch e.Resolution {
+		case "", patch.ResolutionMerge, patch.ResolutionIgnore:
+		default:
+			return fmt.Errorf("unknown upsert resolution %q, expected merge or ignore", e.Resolution)
+		}
+	}
+	return nil
+}
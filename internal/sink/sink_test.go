@@ -0,0 +1,241 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"gopatch/config"
+	"gopatch/internal/app"
+	"gopatch/internal/writeback"
+)
+
+func TestREST(t *testing.T) {
+	var got map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPatch {
+			t.Errorf("Expected PATCH, got %s", r.Method)
+		}
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &got)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	rec := Record{Case: "hold", Data: map[string]any{"ch1_weight": 1.5}}
+	if err := (REST{URL: server.URL, Method: http.MethodPatch, Key: "key"}).Write(context.Background(), rec); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got["ch1_weight"] != 1.5 {
+		t.Errorf("Expected the record as body, got %v", got)
+	}
+}
+
+// plcFunc adapts a function to an app.PLCWriter
+type plcFunc func(ctx context.Context, deviceStr string, value any) error
+
+func (f plcFunc) WritePLC(ctx context.Context, deviceStr string, value any) error {
+	return f(ctx, deviceStr, value)
+}
+
+func TestUpsertWriteBack(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if prefer := r.Header.Get("Prefer"); prefer != "resolution=merge-duplicates,return=representation" {
+			t.Errorf("Expected the row asked for, got Prefer %q", prefer)
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`[{"id": 1, "judgement": {"pass": false}}]`))
+	}))
+	defer server.Close()
+
+	written := map[string]any{}
+	plc := plcFunc(func(_ context.Context, deviceStr string, value any) error {
+		written[deviceStr] = value
+		return errors.New("PLC offline")
+	})
+	m, _ := writeback.Parse("judgement.pass=M,700,1,1:int")
+	s := Upsert{URL: server.URL, Key: "key", WriteBack: m, PLC: plc}
+	if err := s.Write(context.Background(), Record{Case: "hold", Data: map[string]any{"id": 1}}); err != nil {
+		t.Fatalf("Expected a failed write-back not to fail the record, got %v", err)
+	}
+	if written["M,700,1,1"] != 0 {
+		t.Errorf("Expected 0 written to M,700,1,1, got %v", written)
+	}
+}
+
+func TestAuthPerEndpoint(t *testing.T) {
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	cfg := config.AppConfig{
+		APIUrl:         server.URL,
+		Function:       http.MethodPost,
+		ServiceRoleKey: "service-role-key",
+		Endpoints:      map[string]config.Endpoint{"hold": {Auth: "Partner"}},
+		AuthProfiles:   map[string]config.AuthProfile{"partner": {Type: config.AuthHeader, Header: "X-API-Key: partner-key"}},
+	}
+	s, err := For("hold", cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Write(context.Background(), Record{Case: "hold", Data: map[string]any{}}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if header.Get("X-API-Key") != "partner-key" || header.Get("apikey") != "" {
+		t.Errorf("Expected the partner header instead of the service role key, got %v", header)
+	}
+
+	if err := Check(cfg); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	cfg.Endpoints["hold"] = config.Endpoint{Auth: "missing"}
+	if err := Check(cfg); err == nil {
+		t.Error("Expected an error for an unknown auth profile")
+	}
+}
+
+func TestMultiWritesEverySink(t *testing.T) {
+	failed := errors.New("failed")
+	var written []string
+	m := Multi{
+		Func(func(_ context.Context, rec Record) error { written = append(written, "a"); return failed }),
+		Func(func(_ context.Context, rec Record) error { written = append(written, "b"); return nil }),
+	}
+
+	err := m.Write(context.Background(), Record{Case: "hold"})
+	if !errors.Is(err, failed) {
+		t.Errorf("Expected the failure of the first sink, got %v", err)
+	}
+	if len(written) != 2 {
+		t.Errorf("Expected both sinks written, got %v", written)
+	}
+}
+
+func TestForPerCase(t *testing.T) {
+	var got []string
+	Register("test", func(cfg config.AppConfig, _ app.PLCWriter) Sink {
+		return Func(func(_ context.Context, rec Record) error {
+			got = append(got, rec.Case)
+			return nil
+		})
+	})
+
+	cfg := config.AppConfig{Sinks: map[string][]string{"weight": {"test", "test"}}}
+	s, err := For("weight", cfg, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := s.Write(context.Background(), Record{Case: "weight"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(got) != 2 {
+		t.Errorf("Expected the record written twice, got %v", got)
+	}
+
+	if s, _ := For("hold", cfg, nil); !reflect.DeepEqual(s, REST{}) {
+		t.Errorf("Expected the rest sink by default, got %#v", s)
+	}
+	if s, _ := For("hold", config.AppConfig{InsertMode: "upsert"}, nil); s == nil {
+		t.Error("Expected the upsert sink")
+	} else if _, ok := s.(Upsert); !ok {
+		t.Errorf("Expected the upsert sink with INSERT_MODE=upsert, got %#v", s)
+	}
+
+	// merge and postgrest are other names of upsert, following UPSERT_RESOLUTION
+	for _, name := range []string{"merge", "postgrest"} {
+		s, _ := For("hold", config.AppConfig{Sinks: map[string][]string{"": {name}}, UpsertResolution: "ignore"}, nil)
+		if u, ok := s.(Upsert); !ok || u.Resolution != "ignore" {
+			t.Errorf("Expected %s to be the upsert sink ignoring duplicates, got %#v", name, s)
+		}
+	}
+
+	cfg.Sinks["hold"] = []string{"unknown"}
+	if err := Check(cfg); err == nil {
+		t.Error("Expected an error for an unknown sink")
+	}
+}
+
+func TestRESTTemplates(t *testing.T) {
+	var method, uri, lot string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, uri, lot = r.Method, r.URL.RequestURI(), r.Header.Get("X-Ink-Lot")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	s := REST{
+		URL:     server.URL + "/rest/v1/filling?ink_lot=eq.{{.ink_lot}}",
+		Method:  "{{if .cycle_id}}PATCH{{else}}POST{{end}}",
+		Key:     "key",
+		Headers: map[string]string{"X-Ink-Lot": "{{.ink_lot}}"},
+	}
+	rec := Record{Case: "hold", Data: map[string]any{"ink_lot": "L 7&8", "cycle_id": "c1"}}
+	if err := s.Write(context.Background(), rec); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if method != http.MethodPatch || uri != "/rest/v1/filling?ink_lot=eq.L%207%268" || lot != "L 7&8" {
+		t.Errorf("Expected the templates evaluated against the record, got %s %s %q", method, uri, lot)
+	}
+
+	// A field missing from the record is a permanent error, no request is sent
+	uri = ""
+	err := s.Write(context.Background(), Record{Case: "hold", Data: map[string]any{"cycle_id": "c1"}})
+	if err == nil || Retryable(err) || uri != "" {
+		t.Errorf("Expected a permanent error for the missing ink_lot, got %v", err)
+	}
+}
+
+func TestForPerChannel(t *testing.T) {
+	var got []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.Method+" "+r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	cfg := config.AppConfig{
+		APIUrl:       server.URL + "/filling",
+		Function:     "POST",
+		Channels:     []string{"ch1", "ch2"},
+		ChannelField: "channel",
+		Endpoints: map[string]config.Endpoint{
+			"hold":     {URL: server.URL + "/hold"},
+			"hold_ch2": {URL: server.URL + "/hold_ch2", Method: "PATCH"},
+		},
+	}
+	s, err := For("hold", cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, channel := range []string{"ch1", "ch2"} {
+		if err := s.Write(context.Background(), Record{Case: "hold", Data: map[string]any{"channel": channel}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	other, _ := For("degas", cfg, nil)
+	other.Write(context.Background(), Record{Case: "degas", Data: map[string]any{}})
+
+	want := []string{"POST /hold", "PATCH /hold_ch2", "POST /filling"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	cfg.Endpoints["hold"] = config.Endpoint{URL: server.URL + "/{{.ink_lot"}
+	if err := Check(cfg); err == nil {
+		t.Error("Expected an error for an invalid template")
+	}
+
+	cfg.Endpoints["hold"] = config.Endpoint{Resolution: "replace"}
+	if err := Check(cfg); err == nil {
+		t.Error("Expected an error for an unknown upsert resolution")
+	}
+}
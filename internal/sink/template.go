@@ -0,0 +1,93 @@
+package sink
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// templates caches the parsed templates by their text
+var templates sync.Map
+
+// parseTemplate parses a template of API_URL, BASH_API or API_HEADERS; a field missing
+// from the record is an error rather than an empty value
+func parseTemplate(text string) (*template.Template, error) {
+	if tmpl, ok := templates.Load(text); ok {
+		return tmpl.(*template.Template), nil
+	}
+	tmpl, err := template.New("").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	templates.Store(text, tmpl)
+	return tmpl, nil
+}
+
+// expand evaluates a template against the fields of the record, e.g. ?ink_lot=eq.{{.ink_lot}};
+// for a URL (escape) the values are percent-encoded
+func expand(text string, data map[string]any, escape bool) (string, error) {
+	if !strings.Contains(text, "{{") {
+		return text, nil
+	}
+	tmpl, err := parseTemplate(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid template %q: %w", text, err)
+	}
+
+	if escape {
+		escaped := make(map[string]any, len(data))
+		for k, v := range data {
+			escaped[k] = strings.ReplaceAll(url.QueryEscape(fmt.Sprint(v)), "+", "%20")
+		}
+		data = escaped
+	}
+
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, data); err != nil {
+		return "", fmt.Errorf("failed to expand %q: %w", text, err)
+	}
+	return sb.String(), nil
+}
+
+// endpoint is the URL, method and headers of one record
+type endpoint struct {
+	url    string
+	method string
+	header http.Header
+}
+
+// expandEndpoint evaluates the URL, method and header templates against the record
+func expandEndpoint(rawURL, method string, headers map[string]string, data map[string]any) (endpoint, error) {
+	var e endpoint
+	var err error
+	if e.url, err = expand(rawURL, data, true); err != nil {
+		return e, err
+	}
+	if e.method, err = expand(method, data, false); err != nil {
+		return e, err
+	}
+	if len(headers) > 0 {
+		e.header = make(http.Header, len(headers))
+		for name, text := range headers {
+			value, err := expand(text, data, false)
+			if err != nil {
+				return e, err
+			}
+			e.header.Set(name, value)
+		}
+	}
+	return e, nil
+}
+
+// checkTemplates parses the templates of every endpoint, so a typo fails at start instead of on the first record
+func checkTemplates(texts ...string) error {
+	for _, text := range texts {
+		if _, err := parseTemplate(text); err != nil {
+			return fmt.Errorf("invalid template %q: %w", text, err)
+		}
+	}
+	return nil
+}
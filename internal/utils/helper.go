@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"gopatch/internal/session"
 	"gopatch/model"
+	"log/slog"
 	"os"
 	"strings"
 )
@@ -32,7 +33,7 @@ func CompareAndUpdateNestedMap(parentMap map[string]map[string]interface{}, pare
 			continue
 		}
 
-		fmt.Println("Comparing:", checkKey, newValue, existingFloat, *prevWeightValue)
+		slog.Debug("Comparing weighing", "key", checkKey, "new", newValue, "existing", existingFloat, "prev", *prevWeightValue)
 
 		if !okExist {
 			continue
@@ -40,7 +41,7 @@ func CompareAndUpdateNestedMap(parentMap map[string]map[string]interface{}, pare
 
 		// If the new value is greater than the existing one and greater than or equal to the previous weight
 		if newValue > existingFloat && newValue >= *prevWeightValue {
-			fmt.Println("Updating value:", checkKey, existingFloat, "->", newValue, "prevWeight:", *prevWeightValue)
+			slog.Debug("Updating weighing", "key", checkKey, "from", existingFloat, "to", newValue)
 			nestedMap[checkKey] = newValue
 			*prevWeightValue = newValue
 		}
@@ -116,7 +117,7 @@ func ParseTriggerKey(triggerKey string) []TriggerKey {
 
 	// Check if the number of items in the triggerKeySlice is even
 	if len(triggerKeySlice)%2 != 0 {
-		fmt.Println("Warning: Malformed triggerKey input. Ensure it contains pairs of trigger and case numbers.")
+		slog.Warn("Malformed TRIGGER_DEVICE, expected pairs of trigger device and case", "trigger", triggerKey)
 		return triggerkeys // Return empty slice if the input is malformed
 	}
 
@@ -178,8 +179,8 @@ func CalculateAndStoreInklot(jsonPayloads *SafeJsonPayloads) {
 }
 
 // Helper Function, a generic function to replace device names in the JSON payload
-// with readable keys for a specific case.
-func Hold_changeName_generic(jsonPayloads *SafeJsonPayloads, key string, session *session.Session) map[string]any {
+// with readable keys for a specific case. A value missing or 0 keeps its value from prev, if given.
+func Hold_changeName_generic(jsonPayloads *SafeJsonPayloads, key string, prev map[string]any) map[string]any {
 	holdkeyTransformations := GetKeyTransformationsFromEnv(key)
 	result := make(map[string]any)
 
@@ -194,10 +195,8 @@ func Hold_changeName_generic(jsonPayloads *SafeJsonPayloads, key string, session
 			}
 		}
 
-		if session != nil && session.Prev != nil {
-			if prevVal, ok := session.Prev[newKey]; ok {
-				result[newKey] = prevVal
-			}
+		if prevVal, ok := prev[newKey]; ok {
+			result[newKey] = prevVal
 		}
 	}
 
@@ -263,12 +262,12 @@ func ConvertAndStoreModelName(jsonPayloads *SafeJsonPayloads) {
 			envKey := fmt.Sprintf("%s%d", t.keyPrefix, i)
 			deviceKey, ok := keyTransformations[envKey]
 			if !ok {
-				fmt.Printf("Warning: Missing env key %s\n", envKey)
+				slog.Warn("Missing env key", "key", envKey)
 				continue
 			}
 			val, ok := jsonPayloads.GetString(deviceKey)
 			if !ok {
-				fmt.Printf("Warning: Missing payload key %s (from %s)\n", deviceKey, envKey)
+				slog.Warn("Missing payload key", "address", deviceKey, "env", envKey)
 				continue
 			}
 			reversed := reverseString(val)
@@ -307,9 +306,7 @@ func StoreFlattenedPayloadToSession(jsonPayloads *SafeJsonPayloads, session *ses
 }
 
 func storeToSession(session *session.Session, key string, val any) {
-	session.Mutex.Lock()
-	defer session.Mutex.Unlock()
-	session.ProcessedPayloadsMap[key] = map[string]any{key: val}
+	session.SetPayload(key, map[string]any{key: val})
 }
 
 func sanitizeString(s string) string {
@@ -2,6 +2,7 @@ package utils
 
 import (
 	"fmt"
+	"log/slog"
 	"strings"
 	"sync"
 )
@@ -179,6 +180,11 @@ func (s *SafeJsonPayloads) Range(fn func(key string, val any)) {
 	}
 }
 
+// LogValue logs a copy of the payloads, only when the log line is enabled
+func (s *SafeJsonPayloads) LogValue() slog.Value {
+	return slog.AnyValue(s.Data())
+}
+
 func (s *SafeJsonPayloads) Data() map[string]any {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
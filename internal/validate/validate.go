@@ -0,0 +1,125 @@
+package validate
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Actions taken when a record fails validation
+const (
+	ActionDrop  = "drop"  // Discard the record
+	ActionFlag  = "flag"  // Send it anyway with the failure reason
+	ActionRoute = "route" // Send it to a separate endpoint with the failure reason
+)
+
+// Range bounds a numeric field, nil means unbounded
+type Range struct {
+	Min *float64
+	Max *float64
+}
+
+// Rules describe when a record of a case is complete
+type Rules struct {
+	Required []string          // Fields that must be present and not null
+	Optional []string          // Fields that may be null, not counted by MaxNulls
+	Types    map[string]string // Expected type per field: number, string or bool
+	Ranges   map[string]Range  // Allowed numeric range per field
+	MaxNulls int               // Maximum null fields not listed as optional, -1 for no limit
+
+	OnInvalid   string // ActionDrop, ActionFlag or ActionRoute
+	RouteAPIUrl string // Endpoint for ActionRoute
+}
+
+// Default keeps the historical behaviour: drop records with more than 3 null values
+func Default() Rules {
+	return Rules{MaxNulls: 3, OnInvalid: ActionDrop}
+}
+
+// Validate checks the record against the rules and returns every failure reason, sorted
+func (r Rules) Validate(record map[string]any) []string {
+	var reasons []string
+
+	for _, field := range r.Required {
+		if value, ok := record[field]; !ok || value == nil {
+			reasons = append(reasons, fmt.Sprintf("missing required field %s", field))
+		}
+	}
+
+	if r.MaxNulls >= 0 {
+		optional := make(map[string]bool, len(r.Optional))
+		for _, field := range r.Optional {
+			optional[field] = true
+		}
+		nullCount := 0
+		for field, value := range record {
+			if value == nil && !optional[field] {
+				nullCount++
+			}
+		}
+		if nullCount > r.MaxNulls {
+			reasons = append(reasons, fmt.Sprintf("%d null fields, more than %d allowed", nullCount, r.MaxNulls))
+		}
+	}
+
+	for field, kind := range r.Types {
+		value, ok := record[field]
+		if !ok || value == nil {
+			continue
+		}
+		if !isType(value, kind) {
+			reasons = append(reasons, fmt.Sprintf("field %s is %T, expected %s", field, value, kind))
+		}
+	}
+
+	for field, bounds := range r.Ranges {
+		value, ok := record[field]
+		if !ok || value == nil {
+			continue
+		}
+		number, ok := toFloat64(value)
+		if !ok {
+			reasons = append(reasons, fmt.Sprintf("field %s is %T, expected number for range check", field, value))
+			continue
+		}
+		if bounds.Min != nil && number < *bounds.Min {
+			reasons = append(reasons, fmt.Sprintf("field %s=%v below minimum %v", field, number, *bounds.Min))
+		}
+		if bounds.Max != nil && number > *bounds.Max {
+			reasons = append(reasons, fmt.Sprintf("field %s=%v above maximum %v", field, number, *bounds.Max))
+		}
+	}
+
+	sort.Strings(reasons)
+	return reasons
+}
+
+func isType(value any, kind string) bool {
+	switch kind {
+	case "number":
+		_, ok := toFloat64(value)
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "bool":
+		_, ok := value.(bool)
+		return ok
+	default:
+		return true
+	}
+}
+
+func toFloat64(value any) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,49 @@
+package validate
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDefaultRules(t *testing.T) {
+	record := map[string]any{"a": nil, "b": nil, "c": nil, "d": 1.0}
+	if reasons := Default().Validate(record); len(reasons) != 0 {
+		t.Errorf("Expected 3 nulls to pass, got %v", reasons)
+	}
+
+	record["e"] = nil
+	if reasons := Default().Validate(record); len(reasons) != 1 {
+		t.Errorf("Expected 4 nulls to fail, got %v", reasons)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	min, max := 0.0, 500.0
+	rules := Rules{
+		Required: []string{"ink_lot", "ch1_weighing"},
+		Optional: []string{"ch1_do"},
+		Types:    map[string]string{"ink_lot": "string", "ch1_fill": "number"},
+		Ranges:   map[string]Range{"ch1_weighing": {Min: &min, Max: &max}},
+		MaxNulls: 0,
+	}
+
+	valid := map[string]any{"ink_lot": "A123", "ch1_weighing": 120.5, "ch1_fill": 1, "ch1_do": nil}
+	if reasons := rules.Validate(valid); len(reasons) != 0 {
+		t.Errorf("Expected valid record, got %v", reasons)
+	}
+
+	invalid := map[string]any{"ink_lot": 42.0, "ch1_weighing": 620.0, "ch2_fica1": nil}
+	expected := []string{
+		"1 null fields, more than 0 allowed",
+		"field ch1_weighing=620 above maximum 500",
+		"field ink_lot is float64, expected string",
+	}
+	if reasons := rules.Validate(invalid); !reflect.DeepEqual(reasons, expected) {
+		t.Errorf("Expected %v, got %v", expected, reasons)
+	}
+
+	missing := map[string]any{"ink_lot": "A123"}
+	if reasons := rules.Validate(missing); !reflect.DeepEqual(reasons, []string{"missing required field ch1_weighing"}) {
+		t.Errorf("Unexpected reasons %v", reasons)
+	}
+}
@@ -0,0 +1,218 @@
+// Package writeback writes values of an API response to PLC devices, so the server can
+// hand its decisions (pass/fail, next recipe, limits) back to the machine
+package writeback
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Writer writes a value to a PLC device given as "Type,Number,ProcessNumber,Registers",
+// like app.PLCWriter
+type Writer interface {
+	WritePLC(ctx context.Context, deviceStr string, value any) error
+}
+
+// Types a response value is written as
+const (
+	TypeAuto   = ""       // As in the response: a string, a bool, an int for a whole number, a float otherwise
+	TypeString = "string" // Numbers and bools formatted as text
+	TypeInt    = "int"    // Whole numbers, numeric strings and bools as 1 or 0
+	TypeFloat  = "float"
+	TypeBool   = "bool" // Bools, numbers other than 0 and "true"/"false"
+)
+
+// Target is one response value written to a PLC device
+type Target struct {
+	Path   string // Dotted path in the response, numbers index arrays, e.g. "limits.0.max"
+	Device string // "Type,Number,ProcessNumber,Registers"
+	Type   string
+}
+
+// Mapping lists the values of a response written back, in order
+type Mapping []Target
+
+// Parse reads a mapping from "path=Type,Number,ProcessNumber,Registers[:type]" targets separated by ";",
+// e.g. "result=D,610,1,1:int;next_recipe=D,620,1,10:string"
+func Parse(spec string) (Mapping, error) {
+	var m Mapping
+	for _, item := range strings.Split(spec, ";") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		path, device, ok := strings.Cut(item, "=")
+		if !ok || strings.TrimSpace(path) == "" {
+			return nil, fmt.Errorf("invalid write-back target %q, expected path=device", item)
+		}
+		device, typ, _ := strings.Cut(device, ":")
+		t := Target{Path: strings.TrimSpace(path), Device: strings.ReplaceAll(device, " ", ""), Type: strings.ToLower(strings.TrimSpace(typ))}
+		if len(strings.Split(t.Device, ",")) != 4 {
+			return nil, fmt.Errorf("invalid write-back device %q, expected Type,Number,ProcessNumber,Registers", device)
+		}
+		switch t.Type {
+		case TypeAuto, TypeString, TypeInt, TypeFloat, TypeBool:
+		default:
+			return nil, fmt.Errorf("unknown write-back type %q, expected string, int, float or bool", t.Type)
+		}
+		m = append(m, t)
+	}
+	return m, nil
+}
+
+// Legacy returns the mapping of PLC_DEVICE_UPSERT: y_status, x_status and vacuum_status
+// written to its three devices, in that order
+func Legacy(devices string) (Mapping, error) {
+	parts := strings.Split(devices, ",")
+	if len(parts) != 12 {
+		return nil, fmt.Errorf("expected 3 devices of Type,Number,ProcessNumber,Registers, got %q", devices)
+	}
+	fields := []Target{{Path: "y_status", Type: TypeString}, {Path: "x_status", Type: TypeString}, {Path: "vacuum_status", Type: TypeBool}}
+	for i := range fields {
+		fields[i].Device = strings.Join(parts[i*4:i*4+4], ",")
+	}
+	return fields, nil
+}
+
+// Apply writes the values of the response body to their devices. A response holding an array
+// (the rows PostgREST returns) is read from its first element, an empty one writes nothing.
+// A value missing from the response is skipped; the other targets are still written when one fails.
+func (m Mapping) Apply(ctx context.Context, body []byte, w Writer) error {
+	if len(m) == 0 || w == nil || len(body) == 0 {
+		return nil
+	}
+	var response any
+	if err := json.Unmarshal(body, &response); err != nil {
+		return fmt.Errorf("failed to parse response JSON: %w", err)
+	}
+
+	var errs []error
+	for _, t := range m {
+		root := response
+		if rows, ok := root.([]any); ok && !isIndex(t.Path) {
+			if len(rows) == 0 {
+				continue
+			}
+			root = rows[0]
+		}
+		value, ok := Lookup(root, t.Path)
+		if !ok || value == nil {
+			slog.WarnContext(ctx, "Write-back value missing from the response", "path", t.Path, "device", t.Device)
+			continue
+		}
+		converted, err := Convert(value, t.Type)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", t.Path, err))
+			continue
+		}
+		if err := w.WritePLC(ctx, t.Device, converted); err != nil {
+			errs = append(errs, fmt.Errorf("%s to %s: %w", t.Path, t.Device, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// isIndex reports whether the path starts by indexing an array
+func isIndex(path string) bool {
+	first, _, _ := strings.Cut(path, ".")
+	_, err := strconv.Atoi(first)
+	return err == nil
+}
+
+// Lookup returns the value at the dotted path of a decoded JSON value
+func Lookup(v any, path string) (any, bool) {
+	for _, key := range strings.Split(path, ".") {
+		switch node := v.(type) {
+		case map[string]any:
+			next, ok := node[key]
+			if !ok {
+				return nil, false
+			}
+			v = next
+		case []any:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, false
+			}
+			v = node[i]
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// Convert returns a decoded JSON scalar as the type written to the PLC
+func Convert(v any, typ string) (any, error) {
+	switch v.(type) {
+	case string, bool, float64:
+	default:
+		return nil, fmt.Errorf("cannot write %T to a PLC device", v)
+	}
+
+	switch typ {
+	case TypeAuto:
+		if f, ok := v.(float64); ok && f == math.Trunc(f) && math.Abs(f) < 1<<53 {
+			return int(f), nil
+		}
+		return v, nil
+	case TypeString:
+		switch v := v.(type) {
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64), nil
+		case bool:
+			return strconv.FormatBool(v), nil
+		}
+		return v, nil
+	case TypeInt:
+		f, err := toFloat(v)
+		if err != nil {
+			return nil, err
+		}
+		if f != math.Trunc(f) {
+			return nil, fmt.Errorf("%v is not a whole number", v)
+		}
+		return int(f), nil
+	case TypeFloat:
+		return toFloat(v)
+	case TypeBool:
+		switch v := v.(type) {
+		case bool:
+			return v, nil
+		case float64:
+			return v != 0, nil
+		default:
+			b, err := strconv.ParseBool(v.(string))
+			if err != nil {
+				return nil, fmt.Errorf("%q is not a bool", v)
+			}
+			return b, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown write-back type %q", typ)
+}
+
+// toFloat returns a number, numeric string or bool as a float
+func toFloat(v any) (float64, error) {
+	switch v := v.(type) {
+	case float64:
+		return v, nil
+	case bool:
+		if v {
+			return 1, nil
+		}
+		return 0, nil
+	default:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v.(string)), 64)
+		if err != nil {
+			return 0, fmt.Errorf("%q is not a number", v)
+		}
+		return f, nil
+	}
+}
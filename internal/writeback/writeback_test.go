@@ -0,0 +1,95 @@
+package writeback
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// recorder keeps the writes, failing on the devices in fail
+type recorder struct {
+	writes map[string]any
+	fail   string
+}
+
+func (r *recorder) WritePLC(_ context.Context, deviceStr string, value any) error {
+	if deviceStr == r.fail {
+		return errors.New("write failed")
+	}
+	r.writes[deviceStr] = value
+	return nil
+}
+
+func TestParse(t *testing.T) {
+	m, err := Parse("result.pass=M,700,1,1:bool; next_recipe = D,710,1,10:String;limits.0=D,720,1,2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Mapping{
+		{Path: "result.pass", Device: "M,700,1,1", Type: TypeBool},
+		{Path: "next_recipe", Device: "D,710,1,10", Type: TypeString},
+		{Path: "limits.0", Device: "D,720,1,2", Type: TypeAuto},
+	}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("Expected %+v, got %+v", want, m)
+	}
+
+	for _, spec := range []string{"result", "result=D,700", "result=D,700,1,1:word", "=D,700,1,1"} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Expected an error for %q", spec)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	m, _ := Parse("result.pass=M,700,1,1:bool;result.recipe=D,710,1,1:int;limits.1=D,720,1,2:float;note=D,730,1,5;missing=D,740,1,1;count=D,750,1,1")
+	plc := &recorder{writes: map[string]any{}, fail: "D,720,1,2"}
+
+	body := `[{"result": {"pass": "true", "recipe": 12}, "limits": [1, 2.5], "note": "ok", "count": 3}, {"note": "second row"}]`
+	err := m.Apply(context.Background(), []byte(body), plc)
+	if err == nil {
+		t.Error("Expected the failed write reported")
+	}
+
+	want := map[string]any{"M,700,1,1": true, "D,710,1,1": 12, "D,730,1,5": "ok", "D,750,1,1": 3}
+	if !reflect.DeepEqual(plc.writes, want) {
+		t.Errorf("Expected %v, got %v", want, plc.writes)
+	}
+
+	plc = &recorder{writes: map[string]any{}}
+	if err := m.Apply(context.Background(), []byte(`[]`), plc); err != nil || len(plc.writes) != 0 {
+		t.Errorf("Expected nothing written for no rows, got %v, %v", plc.writes, err)
+	}
+}
+
+func TestConvert(t *testing.T) {
+	tests := []struct {
+		value any
+		typ   string
+		want  any
+	}{
+		{1.5, TypeAuto, 1.5},
+		{2.0, TypeAuto, 2},
+		{true, TypeInt, 1},
+		{"7", TypeInt, 7},
+		{3.0, TypeString, "3"},
+		{false, TypeString, "false"},
+		{"2.5", TypeFloat, 2.5},
+		{0.0, TypeBool, false},
+	}
+	for _, tt := range tests {
+		if got, err := Convert(tt.value, tt.typ); err != nil || got != tt.want {
+			t.Errorf("Convert(%v, %q) = %v, %v, expected %v", tt.value, tt.typ, got, err, tt.want)
+		}
+	}
+
+	for _, value := range []any{1.5, "high"} {
+		if _, err := Convert(value, TypeInt); err == nil {
+			t.Errorf("Expected an error converting %v to int", value)
+		}
+	}
+	if _, err := Convert(map[string]any{}, TypeAuto); err == nil {
+		t.Error("Expected an error converting an object")
+	}
+}
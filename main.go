@@ -5,17 +5,32 @@ import (
 	//"net/http"
 	//_ "net/http/pprof"
 
-	"log"
+	"context"
+	"log/slog"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"gopatch/config"
 	"gopatch/handler"
+	"gopatch/internal/admin"
 	"gopatch/internal/app"
+	"gopatch/internal/auth"
+	"gopatch/internal/clock"
+	"gopatch/internal/dryrun"
+	"gopatch/internal/logging"
+	"gopatch/internal/metrics"
+	"gopatch/internal/outbox"
+	"gopatch/internal/session"
+	"gopatch/internal/sink"
 	"gopatch/mqtts"
+	"gopatch/patch"
 )
 
+// shutdownGrace is how long a request in flight at shutdown may still take
+const shutdownGrace = 5 * time.Second
+
 func main() {
 	// Register the profiling handlers with the default HTTP server mux.
 	// This will serve the profiling endpoints at /debug/pprof.
@@ -31,52 +46,193 @@ func main() {
 	//	}
 	//}()
 
+	// Subcommands
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		if err := runReplay(os.Args[2:]); err != nil {
+			logging.Fatal("Replay failed", "err", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "dlq" {
+		if err := runDLQ(os.Args[2:]); err != nil {
+			logging.Fatal("Dead-letter command failed", "err", err)
+		}
+		return
+	}
+
 	// Load configuration
 	config.Load(".env.local")
+	logging.Setup(os.Stdout, config.LogLevel, config.LogFormat, config.LogDev)
+	if err := sink.Check(config.GetAppConfig()); err != nil {
+		logging.Fatal("Invalid sink configuration", "err", err)
+	}
 
-	logger := log.New(os.Stdout, "[PLC] ", log.LstdFlags)
-	// Create the Application once at startup
-	plcApp, err := app.NewApplication(config.GetPlcConfig(), logger)
+	// One HTTP client for every API and token request, so the connections are reused
+	apiClient, err := patch.NewClient(config.GetHttpConfig())
 	if err != nil {
-		logger.Fatalf("Failed to init PLC Application: %v", err)
+		logging.Fatal("Invalid API client configuration", "err", err)
+	}
+	patch.SetClient(apiClient)
+	auth.SetClient(apiClient)
+
+	// Cancelled on shutdown to abort the requests still in flight
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handler.SetContext(ctx)
+
+	logger := slog.Default().With("component", "plc")
+	// Create the Application once at startup
+	var plcApp *app.Application
+	if config.DryRun {
+		// Log the requests and PLC frames instead of sending them
+		dryRunLog, err := dryrun.New(slog.Default(), config.DryRunFile)
+		if err != nil {
+			logging.Fatal("Failed to init dry-run", "err", err)
+		}
+		defer dryRunLog.Close()
+
+		patch.SetTransport(dryrun.Transport{Log: dryRunLog})
+		auth.SetTransport(dryrun.Transport{Log: dryRunLog})
+		plcApp = app.NewDryRunApplication(config.GetPlcConfig(), logger, dryRunLog)
+	} else {
+		var err error
+		plcApp, err = app.NewApplication(config.GetPlcConfig(), logger)
+		if err != nil {
+			logging.Fatal("Failed to init PLC Application", "err", err)
+		}
 	}
 	defer plcApp.Close()
 
+	// Restore the hold sessions of the last run, so a restart mid-cycle doesn't lose the cycle
+	var persister *session.Persister
+	if config.SessionStoreFile != "" {
+		restored, err := session.Restore(config.SessionStoreFile)
+		if err != nil {
+			slog.Warn("Starting with empty sessions", "file", config.SessionStoreFile, "err", err)
+		} else if restored > 0 {
+			slog.Info("Restored sessions", "sessions", restored, "file", config.SessionStoreFile)
+		}
+		persister = session.NewPersister(config.SessionStoreFile, config.SessionStoreInterval, clock.Real)
+	}
+
+	// Send the records pending in the batch sink at exit
+	defer handler.UseBatches(config.GetAppConfig(), clock.Real)()
+
+	// Queue the records on disk and deliver them in the background, so an API outage loses none
+	if config.OutboxDir != "" {
+		queue, err := outbox.Open(config.OutboxDir, outbox.Options{
+			Retention: config.OutboxRetention,
+			MaxBytes:  config.OutboxMaxBytes,
+		}, clock.Real)
+		if err != nil {
+			logging.Fatal("Failed to open outbox", "dir", config.OutboxDir, "err", err)
+		}
+		defer queue.Close()
+		metrics.WatchOutbox(queue.Len, queue.Bytes)
+
+		stopDelivery := handler.UseOutbox(queue, config.GetAppConfig(), plcApp, clock.Real)
+		defer stopDelivery()
+		slog.Info("Queueing records in the outbox", "dir", config.OutboxDir, "queued", queue.Len())
+	}
+
+	// Admin API to inspect and control the sessions while running
+	if config.AdminAddr != "" {
+		adminSrv, err := admin.Start(config.AdminAddr, config.AdminToken, config.GetAppConfig(), clock.Real)
+		if err != nil {
+			logging.Fatal("Failed to start admin API", "err", err)
+		}
+		defer adminSrv.Close()
+		slog.Info("Admin API listening", "addr", config.AdminAddr)
+	}
+
 	// Channels for communication and termination
 	stopProcessing := make(chan struct{})
+	processingDone := make(chan struct{})
 	clientDone := make(chan struct{})
 	// Channel for receiving MQTT messages as JSON strings
 	receivedMessagesJSONChan := make(chan string, 1000)
 
+	// Prometheus metrics
+	if config.MetricsAddr != "" {
+		metrics.WatchChannelDepth(func() int { return len(receivedMessagesJSONChan) })
+		metricsSrv := metrics.Start(config.MetricsAddr)
+		defer metricsSrv.Close()
+		slog.Info("Metrics listening", "addr", config.MetricsAddr, "path", "/metrics")
+	}
+
 	// Start the MQTT client in a separate goroutine
 	go mqtts.Client(
 		config.GetMqttConfig(),
 		receivedMessagesJSONChan,
 		clientDone,
+		clock.Real,
 	)
 
 	// Process MQTT data
 	go func() {
-		defer close(clientDone)
+		defer close(processingDone)
 		for {
 			select {
 			case <-stopProcessing:
 				return
 			default:
 				handler.ProcessMQTTData(
-					config.GetAppConfig(), receivedMessagesJSONChan, plcApp)
+					config.GetAppConfig(), receivedMessagesJSONChan, plcApp, clock.Real)
+
+				// Snapshot between batches, while no handler is changing a session
+				if persister != nil {
+					if err := persister.Checkpoint(); err != nil {
+						slog.Error("Failed to save sessions", "file", config.SessionStoreFile, "err", err)
+					}
+				}
 			}
 		}
 	}()
 
+	// Save the changes of the last batches before the line goes idle, once the interval passed
+	if persister != nil && config.SessionStoreInterval > 0 {
+		go func() {
+			ticker := time.NewTicker(config.SessionStoreInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-processingDone:
+					return
+				case <-ticker.C:
+					handler.BetweenBatches(func() {
+						if err := persister.Checkpoint(); err != nil {
+							slog.Error("Failed to save sessions", "file", config.SessionStoreFile, "err", err)
+						}
+					})
+				}
+			}
+		}()
+	}
+
 	// Set up signal handling for graceful shutdown
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGTERM, syscall.SIGINT)
 	<-sigCh
 
-	// Initiate graceful shutdown
+	// Initiate graceful shutdown, the processor returns after the batch in progress
 	close(stopProcessing)
+	handler.StopProcessing()
 
+	// Wait for the batch in progress to finish, aborting a request that hangs past the grace period
+	select {
+	case <-processingDone:
+	case <-time.After(shutdownGrace):
+		slog.Warn("Aborting in-flight requests", "grace", shutdownGrace)
+		cancel()
+		<-processingDone
+	}
 	// Wait for client to finish
 	<-clientDone
+
+	// Save the sessions once no handler changes them anymore
+	if persister != nil {
+		if err := persister.Flush(); err != nil {
+			slog.Error("Failed to save sessions", "file", config.SessionStoreFile, "err", err)
+		}
+	}
 }
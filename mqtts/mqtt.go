@@ -6,7 +6,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"gopatch/config"
-	"log"
+	"gopatch/internal/clock"
+	"gopatch/internal/logging"
+	"gopatch/internal/metrics"
+	"gopatch/internal/replay"
+	"log/slog"
 	"os"
 	"os/signal"
 	"strconv"
@@ -28,6 +32,7 @@ var (
 	receivedMessages      []MqttData
 	receivedMessagesMutex sync.Mutex
 	droppedMessagesCount  int64
+	batchRecorder         *replay.Recorder // Records every flushed batch when RECORD_FILE is set
 )
 
 const (
@@ -80,7 +85,7 @@ func ECSgetClientOptionsTLS(broker, port, ECScaCert, ECSclientCert, ECSclientKey
 	return opts, nil
 }
 
-func Client(cfg config.MqttConfig, receivedMessagesJSONChan chan<- string, clientDone chan<- struct{}) {
+func Client(cfg config.MqttConfig, receivedMessagesJSONChan chan<- string, clientDone chan<- struct{}, clk clock.Clock) {
 	// Parse the string value into a boolean, defaulting to false if parsing fails
 	mqtts, _ := strconv.ParseBool(cfg.MQTTSStr)
 	var opts *mqtt.ClientOptions
@@ -92,7 +97,7 @@ func Client(cfg config.MqttConfig, receivedMessagesJSONChan chan<- string, clien
 		// AWS ECS version
 		opts, err = ECSgetClientOptionsTLS(cfg.Broker, cfg.Port, cfg.ECScaCert, cfg.ECSclientCert, cfg.ECSclientKey)
 		if err != nil {
-			log.Fatalf("Error requesting MQTT TLS configuration: %v", err.Error())
+			logging.Fatal("Failed to build MQTT TLS configuration", "err", err)
 			return
 		}
 	} else {
@@ -105,10 +110,10 @@ func Client(cfg config.MqttConfig, receivedMessagesJSONChan chan<- string, clien
 		if token := client.Connect(); token.Wait() && token.Error() == nil {
 			break
 		} else {
-			log.Printf("MQTT connect failed (attempt %d/%d): %v", i, maxAttempts, token.Error())
-			time.Sleep(2 * time.Second)
+			slog.Warn("MQTT connect failed", "broker", cfg.Broker, "attempt", i, "max_attempts", maxAttempts, "err", token.Error())
+			clk.Sleep(2 * time.Second)
 			if i == maxAttempts {
-				log.Fatalf("MQTT connect failed after %d attempts", maxAttempts)
+				logging.Fatal("MQTT connect failed, giving up", "broker", cfg.Broker, "attempts", maxAttempts)
 			}
 		}
 	}
@@ -116,15 +121,26 @@ func Client(cfg config.MqttConfig, receivedMessagesJSONChan chan<- string, clien
 	if token := client.Subscribe(cfg.Topic, 0, func(client mqtt.Client, msg mqtt.Message) {
 		messageReceived(msg)
 	}); token.Wait() && token.Error() != nil {
-		log.Fatalf("Error subscribing to topic: %v", token.Error())
+		logging.Fatal("Failed to subscribe", "topic", cfg.Topic, "err", token.Error())
 		return
 	}
 
-	log.Printf("Subscribed to topic: %s\n", cfg.Topic)
+	slog.Info("Subscribed", "topic", cfg.Topic)
+
+	if cfg.RecordFile != "" {
+		recorder, err := replay.NewRecorder(cfg.RecordFile)
+		if err != nil {
+			slog.Warn("Recording disabled", "err", err)
+		} else {
+			batchRecorder = recorder
+			defer recorder.Close()
+			slog.Info("Recording MQTT batches", "file", cfg.RecordFile)
+		}
+	}
 
 	// Start background batch flusher
 	stopFlusher := make(chan struct{})
-	go startBatchFlusher(receivedMessagesJSONChan, stopFlusher)
+	go startBatchFlusher(receivedMessagesJSONChan, stopFlusher, clk)
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
@@ -134,15 +150,17 @@ func Client(cfg config.MqttConfig, receivedMessagesJSONChan chan<- string, clien
 	close(stopFlusher)
 	client.Unsubscribe(cfg.Topic)
 	client.Disconnect(250)
+	metrics.MQTTConnected.Set(0)
 	close(clientDone)
-	log.Println("MQTT client shut down gracefully.")
+	slog.Info("MQTT client shut down gracefully")
 }
 
 // messageReceived handles the received MQTT message
 func messageReceived(msg mqtt.Message) {
+	metrics.MessagesReceived.WithLabelValues(msg.Topic()).Inc()
 	var mqttData MqttData
 	if err := json.Unmarshal(msg.Payload(), &mqttData); err != nil {
-		log.Printf("Error parsing JSON: %v\n", err)
+		slog.Error("Failed to parse MQTT message", "topic", msg.Topic(), "err", err)
 		return
 	}
 
@@ -151,25 +169,25 @@ func messageReceived(msg mqtt.Message) {
 	receivedMessagesMutex.Unlock()
 }
 
-func startBatchFlusher(receivedMessagesJSONChan chan<- string, stopFlusher <-chan struct{}) {
-	ticker := time.NewTicker(FlushInterval)
+func startBatchFlusher(receivedMessagesJSONChan chan<- string, stopFlusher <-chan struct{}, clk clock.Clock) {
+	ticker := clk.NewTicker(FlushInterval)
 	defer ticker.Stop()
 
 	for {
 		select {
-		case <-ticker.C:
-			flushMessages(receivedMessagesJSONChan, true) // Forced flush by timer
+		case <-ticker.C():
+			flushMessages(receivedMessagesJSONChan, true, clk) // Forced flush by timer
 		case <-stopFlusher:
-			flushMessages(receivedMessagesJSONChan, true) // Final flush
+			flushMessages(receivedMessagesJSONChan, true, clk) // Final flush
 			return
 		default:
-			time.Sleep(50 * time.Millisecond)
-			flushMessages(receivedMessagesJSONChan, false) // Soft flush
+			clk.Sleep(50 * time.Millisecond)
+			flushMessages(receivedMessagesJSONChan, false, clk) // Soft flush
 		}
 	}
 }
 
-func flushMessages(receivedMessagesJSONChan chan<- string, force bool) {
+func flushMessages(receivedMessagesJSONChan chan<- string, force bool, clk clock.Clock) {
 	receivedMessagesMutex.Lock()
 	defer receivedMessagesMutex.Unlock()
 
@@ -185,25 +203,35 @@ func flushMessages(receivedMessagesJSONChan chan<- string, force bool) {
 
 		jsonData, err := json.Marshal(messagesToSend)
 		if err != nil {
-			log.Printf("Error marshaling JSON: %v\n", err)
+			slog.Error("Failed to encode MQTT batch", "err", err)
 			return
 		}
 
+		if batchRecorder != nil {
+			if err := batchRecorder.Record(clk.Now(), jsonData); err != nil {
+				slog.Error("Failed to record batch", "err", err)
+			}
+		}
+
 		select {
 		case receivedMessagesJSONChan <- string(jsonData):
+			metrics.BatchesFlushed.Inc()
 		default:
 			atomic.AddInt64(&droppedMessagesCount, 1)
-			log.Println("Received data dropped, channel full")
+			metrics.BatchesDropped.Inc()
+			slog.Warn("MQTT batch dropped, channel full", "messages", len(messagesToSend), "dropped_total", atomic.LoadInt64(&droppedMessagesCount))
 		}
 	}
 }
 
 var connectHandler mqtt.OnConnectHandler = func(client mqtt.Client) {
-	log.Println("Connected to MQTT broker")
+	metrics.MQTTConnected.Set(1)
+	slog.Info("Connected to MQTT broker")
 }
 
 var connectLostHandler mqtt.ConnectionLostHandler = func(client mqtt.Client, err error) {
-	log.Fatalf("Connection lost: %v\n", err)
+	metrics.MQTTConnected.Set(0)
+	logging.Fatal("MQTT connection lost", "err", err)
 }
 
 func ResetReceivedMessages() {
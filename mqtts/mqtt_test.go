@@ -6,6 +6,8 @@ import (
 	"testing"
 	"time"
 
+	"gopatch/internal/clock"
+
 	"github.com/stretchr/testify/assert"
 )
 
@@ -29,7 +31,7 @@ func TestMessageReceivedAndFlush(t *testing.T) {
 	ResetReceivedMessages()
 
 	// Start flusher in background
-	go startBatchFlusher(receivedMessagesJSONChan, stopFlusher)
+	go startBatchFlusher(receivedMessagesJSONChan, stopFlusher, clock.Real)
 
 	// Fill up the queue
 	for i := 0; i < MaxQueueSize; i++ {
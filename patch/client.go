@@ -0,0 +1,94 @@
+package patch
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+
+	"gopatch/config"
+	"gopatch/internal/metrics"
+)
+
+// client sends every request of the package, shared so the API connections are kept alive and reused
+var client atomic.Pointer[http.Client]
+
+func init() {
+	client.Store(&http.Client{Transport: http.DefaultTransport})
+}
+
+// SetClient replaces the client used by every request, e.g. with one made by NewClient
+func SetClient(c *http.Client) {
+	client.Store(c)
+}
+
+// SetTransport replaces the transport used by every request, keeping the client timeout;
+// used to stub the sink, e.g. in replay and tests
+func SetTransport(rt http.RoundTripper) {
+	c := *client.Load()
+	c.Transport = rt
+	client.Store(&c)
+}
+
+// NewClient builds the client for the API: a timeout per request, a pool of kept-alive
+// connections, an optional proxy and optional mTLS
+func NewClient(cfg config.HttpConfig) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{Timeout: cfg.DialTimeout, KeepAlive: 30 * time.Second}).DialContext
+	transport.IdleConnTimeout = cfg.IdleConnTimeout
+	transport.MaxIdleConnsPerHost = cfg.MaxIdleConns
+	transport.MaxConnsPerHost = cfg.MaxConns
+
+	// HTTPS_PROXY, HTTP_PROXY and NO_PROXY apply unless API_PROXY names one
+	if cfg.Proxy != "" {
+		proxy, err := url.Parse(cfg.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid API proxy: %w", err)
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+
+	if cfg.CaCert != "" || cfg.ClientCert != "" {
+		tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+		if cfg.CaCert != "" {
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM([]byte(cfg.CaCert)) {
+				return nil, fmt.Errorf("failed to append API CA certificate")
+			}
+			tlsConfig.RootCAs = pool
+		}
+		if cfg.ClientCert != "" {
+			cert, err := tls.X509KeyPair([]byte(cfg.ClientCert), []byte(cfg.ClientKey))
+			if err != nil {
+				return nil, fmt.Errorf("error loading API client certificate/key: %w", err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	return &http.Client{Transport: transport, Timeout: cfg.Timeout}, nil
+}
+
+// do sends the request with the shared client and reads the whole response body.
+// The request is abandoned when its context is cancelled or the client timeout passes.
+func do(req *http.Request) (*http.Response, []byte, error) {
+	startTime := time.Now()
+	resp, err := client.Load().Do(req)
+	metrics.ObserveSinkRequest(req.Method, resp, time.Since(startTime))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to send HTTP request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+	return resp, body, nil
+}
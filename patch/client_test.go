@@ -0,0 +1,90 @@
+package patch
+
+import (
+	"context"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gopatch/config"
+)
+
+func useClient(t *testing.T, cfg config.HttpConfig) {
+	t.Helper()
+	c, err := NewClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := client.Load()
+	SetClient(c)
+	t.Cleanup(func() { SetClient(prev) })
+}
+
+func TestClientTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	useClient(t, config.HttpConfig{Timeout: 50 * time.Millisecond, DialTimeout: time.Second})
+
+	_, err := SendPatchRequest(context.Background(), server.URL, "dummy-key", []byte(`{}`), "PATCH")
+	var timeout interface{ Timeout() bool }
+	if !errors.As(err, &timeout) || !timeout.Timeout() {
+		t.Fatalf("Expected a timeout, got %v", err)
+	}
+}
+
+func TestClientCancel(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	useClient(t, config.HttpConfig{Timeout: time.Minute})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	_, err := SendPatchRequest(ctx, server.URL, "dummy-key", []byte(`{}`), "PATCH")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected the request cancelled, got %v", err)
+	}
+}
+
+func TestClientCaCert(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	// Untrusted without the CA of the API
+	useClient(t, config.HttpConfig{Timeout: 5 * time.Second})
+	if _, err := SendPatchRequest(context.Background(), server.URL, "dummy-key", []byte(`{}`), "PATCH"); err == nil {
+		t.Fatal("Expected the self-signed certificate rejected")
+	}
+
+	caCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	useClient(t, config.HttpConfig{Timeout: 5 * time.Second, CaCert: string(caCert)})
+	if _, err := SendPatchRequest(context.Background(), server.URL, "dummy-key", []byte(`{}`), "PATCH"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestNewClientInvalid(t *testing.T) {
+	for name, cfg := range map[string]config.HttpConfig{
+		"proxy":       {Proxy: "://proxy"},
+		"ca":          {CaCert: "not a certificate"},
+		"client cert": {ClientCert: "not a certificate", ClientKey: "not a key"},
+	} {
+		if _, err := NewClient(cfg); err == nil {
+			t.Errorf("Expected an error for an invalid %s", name)
+		}
+	}
+}
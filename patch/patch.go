@@ -2,49 +2,121 @@ package patch
 
 import (
 	"bytes"
+	"context"
 	"fmt"
-	"io/ioutil"
 	"net/http"
+
+	"gopatch/internal/cycle"
 )
 
-func SendPatchRequest(apiUrl, serviceRoleKey string, jsonPayload []byte, function string) ([]byte, error) {
-	// Create a PATCH request
-	req, err := http.NewRequest(function, apiUrl, bytes.NewBuffer(jsonPayload))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create HTTP request: %v", err)
+// StatusError is a response with a status code other than 200, 201 or 204
+type StatusError struct {
+	Code int
+	Body string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("request failed with status code: %d - Response: %s", e.Code, e.Body)
+}
+
+// Temporary reports whether the request may succeed when repeated: 5xx, 408 Request Timeout and 429 Too Many Requests
+func (e *StatusError) Temporary() bool {
+	return e.Code >= 500 || e.Code == http.StatusRequestTimeout || e.Code == http.StatusTooManyRequests
+}
+
+// CorrelationHeader carries the cycle ID of the request context, so API logs can be tied to the cycle
+const CorrelationHeader = "X-Correlation-ID"
+
+// headerKey carries extra request headers in a context
+type headerKey struct{}
+
+// WithHeader returns a copy of ctx whose requests carry header as well, replacing the default headers of the same name
+func WithHeader(ctx context.Context, header http.Header) context.Context {
+	return context.WithValue(ctx, headerKey{}, header)
+}
+
+// Authorizer sets the credentials of a request, in place of the service role key
+type Authorizer interface {
+	Authorize(ctx context.Context, req *http.Request) error
+}
+
+// authKey carries the Authorizer of the requests in a context
+type authKey struct{}
+
+// WithAuth returns a copy of ctx whose requests are authorized by a instead of the service role key;
+// a nil a keeps the key
+func WithAuth(ctx context.Context, a Authorizer) context.Context {
+	if a == nil {
+		return ctx
 	}
+	return context.WithValue(ctx, authKey{}, a)
+}
 
-	// Set request headers
+// authorize sets the credentials of the request: those of the Authorizer carried by ctx,
+// or the service role key as apikey and bearer token
+func authorize(ctx context.Context, req *http.Request, serviceRoleKey string) error {
+	if a, ok := ctx.Value(authKey{}).(Authorizer); ok {
+		if err := a.Authorize(ctx, req); err != nil {
+			return fmt.Errorf("failed to authorize request: %w", err)
+		}
+		return nil
+	}
 	req.Header.Set("apikey", serviceRoleKey)
 	req.Header.Set("Authorization", "Bearer "+serviceRoleKey)
-	req.Header.Set("Content-Type", "application/json")
-	//req.Header.Set("Prefer", "return=minimal")
+	return nil
+}
 
-	// Reuse an HTTP client
-	client := &http.Client{}
+// setHeaders sets the correlation ID and the extra headers carried by ctx
+func setHeaders(ctx context.Context, req *http.Request) {
+	if id := cycle.IDFrom(ctx); id != "" {
+		req.Header.Set(CorrelationHeader, id)
+	}
+	header, _ := ctx.Value(headerKey{}).(http.Header)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+}
+
+func SendPatchRequest(ctx context.Context, apiUrl, serviceRoleKey string, jsonPayload []byte, function string) ([]byte, error) {
+	return sendRequest(ctx, apiUrl, serviceRoleKey, jsonPayload, function, "")
+}
 
-	// Send the request
-	resp, err := client.Do(req)
+func sendRequest(ctx context.Context, apiUrl, serviceRoleKey string, jsonPayload []byte, function, prefer string) ([]byte, error) {
+	// Create a PATCH request
+	req, err := http.NewRequestWithContext(ctx, function, apiUrl, bytes.NewBuffer(jsonPayload))
 	if err != nil {
-		return nil, fmt.Errorf("failed to send HTTP request: %v", err)
+		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
 	}
-	defer resp.Body.Close()
 
-	// Read the response body
-	body, err := ioutil.ReadAll(resp.Body)
+	// Set request headers
+	if err := authorize(ctx, req, serviceRoleKey); err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	//req.Header.Set("Prefer", "return=minimal")
+	if prefer != "" {
+		req.Header.Set("Prefer", prefer)
+	}
+	setHeaders(ctx, req)
+
+	resp, body, err := do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %v", err)
+		return nil, err
 	}
 
-	// Check the HTTP status code
+	// Check the HTTP status code: 200 answers a PATCH or RPC, 201 an insert (with the rows when
+	// return=representation), 204 a request without representation or a void RPC
 	switch resp.StatusCode {
 	case http.StatusOK:
 		return body, nil
 	case http.StatusNoContent:
 		return nil, nil
 	case http.StatusCreated:
-		return nil, nil
+		if len(bytes.TrimSpace(body)) == 0 {
+			return nil, nil
+		}
+		return body, nil
 	default:
-		return body, fmt.Errorf("request failed with status code: %d - Response: %s", resp.StatusCode, string(body))
+		return body, &StatusError{Code: resp.StatusCode, Body: string(body)}
 	}
 }
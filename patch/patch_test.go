@@ -1,6 +1,7 @@
 package patch
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
@@ -51,7 +52,7 @@ func TestSendPatchRequest(t *testing.T) {
 			defer server.Close()
 
 			jsonPayload := []byte(`{"key":"value"}`)
-			body, err := SendPatchRequest(server.URL, "dummy-key", jsonPayload, "PATCH")
+			body, err := SendPatchRequest(context.Background(), server.URL, "dummy-key", jsonPayload, "PATCH")
 
 			if (err != nil) != tt.expectError {
 				t.Fatalf("Expected error: %v, got: %v", tt.expectError, err)
@@ -78,3 +79,38 @@ func TestSendPatchRequest(t *testing.T) {
 		})
 	}
 }
+
+// authFunc adapts a function to an Authorizer
+type authFunc func(ctx context.Context, req *http.Request) error
+
+func (f authFunc) Authorize(ctx context.Context, req *http.Request) error {
+	return f(ctx, req)
+}
+
+func TestWithAuth(t *testing.T) {
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	ctx := WithAuth(context.Background(), authFunc(func(_ context.Context, req *http.Request) error {
+		req.Header.Set("X-API-Key", "partner-key")
+		return nil
+	}))
+	if _, err := SendPatchRequest(ctx, server.URL, "service-role-key", []byte(`{}`), http.MethodPost); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if header.Get("X-API-Key") != "partner-key" || header.Get("apikey") != "" || header.Get("Authorization") != "" {
+		t.Errorf("Expected only the header of the authorizer, got %v", header)
+	}
+
+	// Without an authorizer the service role key is sent
+	if _, err := SendPatchRequest(WithAuth(context.Background(), nil), server.URL, "service-role-key", []byte(`{}`), http.MethodPost); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if header.Get("apikey") != "service-role-key" || header.Get("Authorization") != "Bearer service-role-key" {
+		t.Errorf("Expected the service role key, got %v", header)
+	}
+}
@@ -0,0 +1,68 @@
+package patch
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Resolutions of a record conflicting with an existing row on the on_conflict columns
+const (
+	ResolutionMerge  = "merge"  // Update the existing row with the columns of the record
+	ResolutionIgnore = "ignore" // Keep the existing row, the record is skipped
+)
+
+// UpsertOptions make an insert a PostgREST upsert
+type UpsertOptions struct {
+	OnConflict string // Comma separated unique columns, "" for the primary key
+	Resolution string // ResolutionMerge (default) or ResolutionIgnore
+	Return     bool   // Answer with the written rows (return=representation)
+}
+
+// prefer returns the Prefer header of the upsert
+func (o UpsertOptions) prefer() string {
+	prefer := "resolution=merge-duplicates"
+	if o.Resolution == ResolutionIgnore {
+		prefer = "resolution=ignore-duplicates"
+	}
+	if o.Return {
+		prefer += ",return=representation"
+	}
+	return prefer
+}
+
+// url adds on_conflict to the API URL, unless it names the columns already
+func (o UpsertOptions) url(apiUrl string) (string, error) {
+	if o.OnConflict == "" {
+		return apiUrl, nil
+	}
+	u, err := url.Parse(apiUrl)
+	if err != nil {
+		return "", fmt.Errorf("invalid API URL: %w", err)
+	}
+	query := u.Query()
+	if query.Has("on_conflict") {
+		return apiUrl, nil
+	}
+	query.Set("on_conflict", strings.ReplaceAll(o.OnConflict, " ", ""))
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// SendPostgRESTUpsert POSTs the record, or an array of records, as a PostgREST upsert.
+// Returns the written rows with Return; a record skipped by ResolutionIgnore returns no row.
+func SendPostgRESTUpsert(ctx context.Context, apiUrl, serviceRoleKey string, jsonPayload []byte, opts UpsertOptions) ([]byte, error) {
+	upsertUrl, err := opts.url(apiUrl)
+	if err != nil {
+		return nil, err
+	}
+	return sendRequest(ctx, upsertUrl, serviceRoleKey, jsonPayload, http.MethodPost, opts.prefer())
+}
+
+// SendRPCRequest calls a PostgREST stored procedure, apiUrl ending in /rpc/<function>, with the
+// fields of the record as named arguments. Returns the result, nil for a void function.
+func SendRPCRequest(ctx context.Context, apiUrl, serviceRoleKey string, jsonPayload []byte) ([]byte, error) {
+	return sendRequest(ctx, apiUrl, serviceRoleKey, jsonPayload, http.MethodPost, "")
+}
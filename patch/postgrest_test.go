@@ -0,0 +1,106 @@
+package patch
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSendPostgRESTUpsert(t *testing.T) {
+	tests := []struct {
+		name         string
+		query        string
+		opts         UpsertOptions
+		expectPrefer string
+		expectQuery  string
+	}{
+		{
+			name:         "Merge on the primary key",
+			expectPrefer: "resolution=merge-duplicates",
+		},
+		{
+			name:         "Ignore on conflict columns, returning the rows",
+			opts:         UpsertOptions{OnConflict: "ink_lot, cycle_id", Resolution: ResolutionIgnore, Return: true},
+			expectPrefer: "resolution=ignore-duplicates,return=representation",
+			expectQuery:  "on_conflict=ink_lot%2Ccycle_id",
+		},
+		{
+			name:         "Conflict columns named by the URL",
+			query:        "?on_conflict=cycle_id",
+			opts:         UpsertOptions{OnConflict: "ink_lot"},
+			expectPrefer: "resolution=merge-duplicates",
+			expectQuery:  "on_conflict=cycle_id",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodPost {
+					t.Errorf("Expected POST, got %s", r.Method)
+				}
+				if got := r.Header.Get("Prefer"); got != tt.expectPrefer {
+					t.Errorf("Expected Prefer %q, got %q", tt.expectPrefer, got)
+				}
+				if r.URL.RawQuery != tt.expectQuery {
+					t.Errorf("Expected query %q, got %q", tt.expectQuery, r.URL.RawQuery)
+				}
+				w.WriteHeader(http.StatusCreated)
+			}))
+			defer server.Close()
+
+			body, err := SendPostgRESTUpsert(context.Background(), server.URL+tt.query, "dummy-key", []byte(`{"cycle_id":"1"}`), tt.opts)
+			if err != nil || body != nil {
+				t.Fatalf("Expected no body and no error for 201 without representation, got %s, %v", body, err)
+			}
+		})
+	}
+}
+
+func TestSendRPCRequest(t *testing.T) {
+	tests := []struct {
+		name       string
+		statusCode int
+		response   string
+		expectBody string
+	}{
+		{name: "Result - 200 OK", statusCode: http.StatusOK, response: `42`, expectBody: `42`},
+		{name: "Void function - 204 No Content", statusCode: http.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodPost || r.URL.Path != "/rest/v1/rpc/record_filling" {
+					t.Errorf("Expected POST to the function, got %s %s", r.Method, r.URL.Path)
+				}
+				w.WriteHeader(tt.statusCode)
+				w.Write([]byte(tt.response))
+			}))
+			defer server.Close()
+
+			body, err := SendRPCRequest(context.Background(), server.URL+"/rest/v1/rpc/record_filling", "dummy-key", []byte(`{"ink_lot":"L1"}`))
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if string(body) != tt.expectBody {
+				t.Errorf("Expected body %q, got %q", tt.expectBody, body)
+			}
+		})
+	}
+}
+
+func TestSendUpsertReturnsRows(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`[{"id":"1","x_status":"OK"}]`))
+	}))
+	defer server.Close()
+
+	opts := UpsertOptions{Return: true}
+	body, err := SendPostgRESTUpsert(context.Background(), server.URL, "dummy-key", []byte(`{"id":"1"}`), opts)
+	if err != nil || string(body) != `[{"id":"1","x_status":"OK"}]` {
+		t.Errorf("Expected the rows of the 201 answer, got %s, %v", body, err)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"gopatch/config"
+	"gopatch/handler"
+	"gopatch/internal/app"
+	"gopatch/internal/auth"
+	"gopatch/internal/clock"
+	"gopatch/internal/dryrun"
+	"gopatch/internal/logging"
+	"gopatch/internal/replay"
+	"gopatch/internal/sink"
+	"gopatch/patch"
+)
+
+// runReplay feeds a recording made with RECORD_FILE through the handler pipeline,
+// with the REST API and the PLC stubbed.
+//
+//	gopatch replay [-env .env.local] [-speed 1] recording.jsonl
+func runReplay(args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	envFile := fs.String("env", ".env.local", "env file with the case configuration")
+	speed := fs.Float64("speed", 1, "playback speed, 1 keeps the original timing, 0 sends batches back to back")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: gopatch replay [-env file] [-speed n] recording.jsonl")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("expected one recording file")
+	}
+
+	config.Load(*envFile)
+	logging.Setup(os.Stdout, config.LogLevel, config.LogFormat, config.LogDev)
+	if err := sink.Check(config.GetAppConfig()); err != nil {
+		return err
+	}
+
+	batches, err := replay.Read(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	slog.Info("Replaying", "batches", len(batches), "file", fs.Arg(0), "speed", *speed)
+
+	// Print requests instead of sending them to the API
+	dryRunLog, err := dryrun.New(slog.Default(), config.DryRunFile)
+	if err != nil {
+		return err
+	}
+	defer dryRunLog.Close()
+	patch.SetTransport(dryrun.Transport{Log: dryRunLog})
+	auth.SetTransport(dryrun.Transport{Log: dryRunLog})
+
+	logger := slog.Default().With("component", "plc")
+	plcApp := app.NewDryRunApplication(config.GetPlcConfig(), logger, dryRunLog)
+	defer plcApp.Close()
+
+	// The handlers run on a fake clock set to the recorded time of each batch, so windowed
+	// cases measure the recorded timing whatever the speed; the wall clock only paces playback
+	clk := clock.NewFake(time.Time{})
+	defer handler.UseBatches(config.GetAppConfig(), clk)()
+
+	// Every batch gets its own channel, so a case draining its input after a cycle
+	// doesn't discard the batches recorded after it
+	replay.Play(batches, *speed, clock.Real, func(batch replay.Batch) {
+		clk.Set(batch.Time)
+		receivedMessagesJSONChan := make(chan string, 1)
+		receivedMessagesJSONChan <- string(batch.Messages)
+		close(receivedMessagesJSONChan)
+		handler.ProcessMQTTData(config.GetAppConfig(), receivedMessagesJSONChan, plcApp, clk)
+	})
+	slog.Info("Replay finished")
+	return nil
+}